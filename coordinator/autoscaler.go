@@ -0,0 +1,346 @@
+// Autoscaling hints (webhook)
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	AUTOSCALER_DEFAULT_INTERVAL             = 10  // Default interval to check the capacity (seconds)
+	AUTOSCALER_DEFAULT_SCALE_UP_THRESHOLD   = 80  // Default utilization (percentage) to request more encoders
+	AUTOSCALER_DEFAULT_SCALE_DOWN_THRESHOLD = 40  // Default utilization (percentage) to allow removing encoders
+	AUTOSCALER_DEFAULT_SCALE_UP_DELAY       = 0   // Default time the scale-up condition must hold before sending the hint (seconds)
+	AUTOSCALER_DEFAULT_SCALE_DOWN_DELAY     = 300 // Default time the scale-down condition must hold before sending the hint (seconds)
+	AUTOSCALER_DEFAULT_COOLDOWN             = 120 // Default time to wait after a hint before sending the same hint again (seconds)
+	AUTOSCALER_DEFAULT_MIN_ENCODERS         = 1   // Default min number of encoders to keep when scaling down
+)
+
+const AUTOSCALER_REQUEST_TIMEOUT = 10 * time.Second // Timeout for the requests to the autoscaler
+
+// Scaling actions
+const (
+	AUTOSCALER_ACTION_SCALE_UP   = "scale-up"
+	AUTOSCALER_ACTION_SCALE_DOWN = "scale-down"
+)
+
+// Configuration and status of the autoscaling hints
+type Autoscaler struct {
+	url           string // URL to send the hints
+	authorization string // Value for the Authorization header
+
+	interval time.Duration // Interval to check the capacity
+
+	scaleUpThreshold   int // Utilization (percentage) to request more encoders
+	scaleDownThreshold int // Utilization (percentage) to allow removing encoders
+
+	scaleUpDelay   time.Duration // Time the scale-up condition must hold before sending the hint
+	scaleDownDelay time.Duration // Time the scale-down condition must hold before sending the hint
+	cooldown       time.Duration // Time to wait after a hint before sending the same hint again
+
+	minEncoders int // Min number of encoders to keep when scaling down
+
+	scaleUpSince   time.Time // Time since the scale-up condition holds (zero if it does not)
+	scaleDownSince time.Time // Time since the scale-down condition holds (zero if it does not)
+
+	lastScaleUp   time.Time // Time of the last scale-up hint
+	lastScaleDown time.Time // Time of the last scale-down hint
+}
+
+// Encoder that can be removed when scaling down
+type AutoscalerRemovableEncoder struct {
+	Id       uint64 `json:"id"`       // Encoder ID
+	IP       string `json:"ip"`       // IP address of the encoder
+	Capacity int    `json:"capacity"` // Encoder capacity
+}
+
+// Scaling hint, sent to the autoscaler
+type AutoscalerHint struct {
+	Action    string `json:"action"`    // Scaling action: scale-up or scale-down
+	Timestamp int64  `json:"timestamp"` // Unix timestamp (milliseconds)
+
+	Load             int     `json:"load"`             // Number of streams being encoded
+	Capacity         int     `json:"capacity"`         // Total capacity of the encoders (-1 means infinite)
+	EncoderCount     int     `json:"encoderCount"`     // Number of encoders
+	QueuedPublishers int     `json:"queuedPublishers"` // Number of publish requests waiting for encoder capacity
+	Utilization      float64 `json:"utilization"`      // Load relative to the capacity (percentage)
+
+	CapacityNeeded    int                          `json:"capacityNeeded,omitempty"`    // Additional capacity needed (for scale-up)
+	RemovableEncoders []AutoscalerRemovableEncoder `json:"removableEncoders,omitempty"` // Idle encoders that can be removed (for scale-down)
+}
+
+// Loads the autoscaler configuration from the environment
+// Returns the autoscaler, or nil if AUTOSCALER_URL is not set
+func LoadAutoscaler() *Autoscaler {
+	url := os.Getenv("AUTOSCALER_URL")
+
+	if url == "" {
+		return nil
+	}
+
+	return &Autoscaler{
+		url:                url,
+		authorization:      makeEventCallbackAuthorization(os.Getenv("AUTOSCALER_AUTH"), os.Getenv("AUTOSCALER_AUTH_USER"), os.Getenv("AUTOSCALER_AUTH_PASSWORD"), os.Getenv("AUTOSCALER_AUTH_TOKEN"), os.Getenv("AUTOSCALER_AUTH_CUSTOM")),
+		interval:           time.Duration(max(1, getEnvInt("AUTOSCALER_INTERVAL_SECONDS", AUTOSCALER_DEFAULT_INTERVAL))) * time.Second,
+		scaleUpThreshold:   getEnvInt("AUTOSCALER_SCALE_UP_THRESHOLD", AUTOSCALER_DEFAULT_SCALE_UP_THRESHOLD),
+		scaleDownThreshold: getEnvInt("AUTOSCALER_SCALE_DOWN_THRESHOLD", AUTOSCALER_DEFAULT_SCALE_DOWN_THRESHOLD),
+		scaleUpDelay:       time.Duration(getEnvInt("AUTOSCALER_SCALE_UP_DELAY_SECONDS", AUTOSCALER_DEFAULT_SCALE_UP_DELAY)) * time.Second,
+		scaleDownDelay:     time.Duration(getEnvInt("AUTOSCALER_SCALE_DOWN_DELAY_SECONDS", AUTOSCALER_DEFAULT_SCALE_DOWN_DELAY)) * time.Second,
+		cooldown:           time.Duration(getEnvInt("AUTOSCALER_COOLDOWN_SECONDS", AUTOSCALER_DEFAULT_COOLDOWN)) * time.Second,
+		minEncoders:        getEnvInt("AUTOSCALER_MIN_ENCODERS", AUTOSCALER_DEFAULT_MIN_ENCODERS),
+	}
+}
+
+// Periodically checks the capacity, sending scaling hints to the autoscaler (AUTOSCALER_URL)
+// Does nothing if the autoscaler is not configured
+func (server *Streaming_Coordinator_Server) RunAutoscaler() {
+	autoscaler := LoadAutoscaler()
+
+	if autoscaler == nil {
+		return
+	}
+
+	LogInfo("[AUTOSCALER] Sending scaling hints to " + autoscaler.url)
+
+	for {
+		time.Sleep(autoscaler.interval)
+
+		server.checkAutoscaling(autoscaler, time.Now())
+	}
+}
+
+// Checks the capacity, and sends a scaling hint if needed
+// The scale-up hint has priority: while it is needed, the encoders are not removed
+// autoscaler - The autoscaler
+// now - Current time
+func (server *Streaming_Coordinator_Server) checkAutoscaling(autoscaler *Autoscaler, now time.Time) {
+	capacity := server.coordinator.GetCapacity()
+	queued := server.coordinator.publishQueue.Length()
+
+	hint := AutoscalerHint{
+		Timestamp:        now.UnixMilli(),
+		Load:             capacity.Load,
+		Capacity:         capacity.Capacity,
+		EncoderCount:     capacity.EncoderCount,
+		QueuedPublishers: queued,
+	}
+
+	if capacity.Capacity > 0 {
+		hint.Utilization = float64(capacity.Load) * 100 / float64(capacity.Capacity)
+	} else if capacity.Capacity == 0 && capacity.Load > 0 {
+		hint.Utilization = 100
+	}
+
+	// Scale up
+
+	if autoscaler.needsScaleUp(capacity, queued) {
+		autoscaler.scaleDownSince = time.Time{}
+
+		if autoscaler.scaleUpSince.IsZero() {
+			autoscaler.scaleUpSince = now
+		}
+
+		if now.Sub(autoscaler.scaleUpSince) < autoscaler.scaleUpDelay || now.Sub(autoscaler.lastScaleUp) < autoscaler.cooldown {
+			return
+		}
+
+		hint.Action = AUTOSCALER_ACTION_SCALE_UP
+		hint.CapacityNeeded = autoscaler.getCapacityNeeded(capacity, queued)
+
+		if autoscaler.SendHint(&hint) {
+			autoscaler.lastScaleUp = now
+		}
+
+		return
+	}
+
+	autoscaler.scaleUpSince = time.Time{}
+
+	// Scale down
+
+	if queued > 0 || capacity.Capacity <= 0 || capacity.Load*100 > autoscaler.scaleDownThreshold*capacity.Capacity {
+		autoscaler.scaleDownSince = time.Time{}
+		return
+	}
+
+	removable := server.getRemovableEncoders(autoscaler, capacity)
+
+	if len(removable) == 0 {
+		autoscaler.scaleDownSince = time.Time{}
+		return
+	}
+
+	if autoscaler.scaleDownSince.IsZero() {
+		autoscaler.scaleDownSince = now
+	}
+
+	if now.Sub(autoscaler.scaleDownSince) < autoscaler.scaleDownDelay || now.Sub(autoscaler.lastScaleDown) < autoscaler.cooldown || now.Sub(autoscaler.lastScaleUp) < autoscaler.cooldown {
+		return
+	}
+
+	hint.Action = AUTOSCALER_ACTION_SCALE_DOWN
+	hint.RemovableEncoders = removable
+
+	if autoscaler.SendHint(&hint) {
+		autoscaler.lastScaleDown = now
+	}
+}
+
+// Checks if more encoders are needed
+// capacity - Current capacity
+// queued - Number of queued publish requests
+// Returns true if the utilization reached the scale-up threshold, or there are requests waiting for capacity
+func (autoscaler *Autoscaler) needsScaleUp(capacity CapacityAPIResponse, queued int) bool {
+	if queued > 0 {
+		return true
+	}
+
+	if capacity.Capacity < 0 {
+		return false // Infinite capacity
+	}
+
+	if capacity.Capacity == 0 {
+		return capacity.Load > 0
+	}
+
+	return capacity.Load*100 >= autoscaler.scaleUpThreshold*capacity.Capacity
+}
+
+// Computes the additional capacity needed to get the utilization below the scale-up threshold
+// capacity - Current capacity
+// queued - Number of queued publish requests
+// Returns the number of streams
+func (autoscaler *Autoscaler) getCapacityNeeded(capacity CapacityAPIResponse, queued int) int {
+	if capacity.Capacity < 0 || autoscaler.scaleUpThreshold <= 0 {
+		return max(1, queued)
+	}
+
+	targetCapacity := (capacity.Load+queued)*100/autoscaler.scaleUpThreshold + 1
+
+	return max(1, targetCapacity-capacity.Capacity)
+}
+
+// Finds the idle encoders that can be removed
+// The remaining capacity must keep the utilization below the scale-up threshold, and at least AUTOSCALER_MIN_ENCODERS encoders are kept
+// autoscaler - The autoscaler
+// capacity - Current capacity
+// Returns the list of encoders
+func (server *Streaming_Coordinator_Server) getRemovableEncoders(autoscaler *Autoscaler, capacity CapacityAPIResponse) []AutoscalerRemovableEncoder {
+	removable := make([]AutoscalerRemovableEncoder, 0)
+	remainingEncoders := 0 // Draining encoders are not counted, since they are going to be removed
+
+	server.coordinator.mutex.Lock()
+
+	for _, encoder := range server.coordinator.hlsEncoders {
+		if encoder.draining {
+			continue
+		}
+
+		remainingEncoders++
+
+		if encoder.load == 0 && encoder.capacity > 0 {
+			removable = append(removable, AutoscalerRemovableEncoder{
+				Id:       encoder.id,
+				Capacity: encoder.capacity,
+			})
+		}
+	}
+
+	server.coordinator.mutex.Unlock()
+
+	// Remove the newest encoders first
+	sort.Slice(removable, func(i, j int) bool {
+		return removable[i].Id > removable[j].Id
+	})
+
+	remainingCapacity := capacity.Capacity
+	count := 0
+
+	for i := 0; i < len(removable); i++ {
+		if remainingEncoders-1 < autoscaler.minEncoders {
+			break
+		}
+
+		newCapacity := remainingCapacity - removable[i].Capacity
+
+		if newCapacity <= 0 && capacity.Load > 0 {
+			continue
+		}
+
+		if newCapacity > 0 && capacity.Load*100 >= autoscaler.scaleUpThreshold*newCapacity {
+			continue
+		}
+
+		remainingCapacity = newCapacity
+		remainingEncoders--
+
+		removable[count] = removable[i]
+		count++
+	}
+
+	removable = removable[:count]
+
+	for i := 0; i < len(removable); i++ {
+		session := server.GetSession(removable[i].Id)
+
+		if session != nil {
+			removable[i].IP = session.ip
+		}
+	}
+
+	return removable
+}
+
+// Sends a scaling hint to the autoscaler
+// hint - The hint
+// Returns true if the autoscaler received it
+func (autoscaler *Autoscaler) SendHint(hint *AutoscalerHint) bool {
+	body, err := json.Marshal(hint)
+
+	if err != nil {
+		LogError(err)
+		return false
+	}
+
+	req, err := http.NewRequest("POST", autoscaler.url, bytes.NewReader(body))
+
+	if err != nil {
+		LogError(err)
+		return false
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if autoscaler.authorization != "" {
+		req.Header.Set("Authorization", autoscaler.authorization)
+	}
+
+	client := &http.Client{
+		Timeout: AUTOSCALER_REQUEST_TIMEOUT,
+	}
+
+	res, err := client.Do(req)
+
+	if err != nil {
+		LogError(err)
+		LogWarning("[AUTOSCALER] Could not send the " + hint.Action + " hint")
+		return false
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		LogWarning("[AUTOSCALER] The autoscaler responded to the " + hint.Action + " hint with status code " + fmt.Sprint(res.StatusCode))
+		return false
+	}
+
+	LogInfo("[AUTOSCALER] Sent " + hint.Action + " hint | LOAD: " + fmt.Sprint(hint.Load) + "/" + fmt.Sprint(hint.Capacity) + " | QUEUED: " + fmt.Sprint(hint.QueuedPublishers))
+
+	return true
+}
@@ -0,0 +1,340 @@
+// Tests for the autoscaling hints
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Creates a coordinator server for the autoscaler tests
+// encoders - The encoders
+func makeTestAutoscalerServer(encoders []*HLS_Encoder_Server) *Streaming_Coordinator_Server {
+	server := &Streaming_Coordinator_Server{
+		sessions: make(map[uint64]*ControlSession),
+		mutex:    &sync.Mutex{},
+		coordinator: &Streaming_Coordinator{
+			mutex:        &sync.Mutex{},
+			hlsEncoders:  make(map[uint64]*HLS_Encoder_Server),
+			publishQueue: NewPublishQueue(),
+		},
+	}
+
+	for i := 0; i < len(encoders); i++ {
+		server.coordinator.hlsEncoders[encoders[i].id] = encoders[i]
+	}
+
+	return server
+}
+
+// Creates an autoscaler for the tests, with the default thresholds
+// url - URL to send the hints
+func makeTestAutoscaler(url string) *Autoscaler {
+	return &Autoscaler{
+		url:                url,
+		interval:           time.Second,
+		scaleUpThreshold:   80,
+		scaleDownThreshold: 40,
+		scaleUpDelay:       0,
+		scaleDownDelay:     60 * time.Second,
+		cooldown:           30 * time.Second,
+		minEncoders:        1,
+	}
+}
+
+// Test autoscaler endpoint, recording the received hints
+type testAutoscalerEndpoint struct {
+	server *httptest.Server
+
+	mutex      *sync.Mutex
+	hints      []AutoscalerHint
+	statusCode int
+}
+
+// Starts the test autoscaler endpoint
+func startTestAutoscalerEndpoint(t *testing.T) *testAutoscalerEndpoint {
+	endpoint := &testAutoscalerEndpoint{
+		mutex:      &sync.Mutex{},
+		hints:      make([]AutoscalerHint, 0),
+		statusCode: http.StatusOK,
+	}
+
+	endpoint.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hint := AutoscalerHint{}
+
+		err := json.NewDecoder(r.Body).Decode(&hint)
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		endpoint.mutex.Lock()
+		defer endpoint.mutex.Unlock()
+
+		endpoint.hints = append(endpoint.hints, hint)
+
+		w.WriteHeader(endpoint.statusCode)
+	}))
+
+	t.Cleanup(endpoint.server.Close)
+
+	return endpoint
+}
+
+// Takes the hints received since the last call
+// Returns the list of actions
+func (endpoint *testAutoscalerEndpoint) takeActions() []string {
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+
+	actions := make([]string, len(endpoint.hints))
+
+	for i := 0; i < len(endpoint.hints); i++ {
+		actions[i] = endpoint.hints[i].Action
+	}
+
+	endpoint.hints = endpoint.hints[:0]
+
+	return actions
+}
+
+// Step of an autoscaling test: sets the encoder loads and checks the capacity at a given time
+type autoscalerTestStep struct {
+	at       time.Duration // Time since the start of the test
+	loads    []int         // Load of each encoder
+	expected string        // Expected hint action (empty if none)
+}
+
+// Runs the steps of an autoscaling test
+func runAutoscalerTestSteps(t *testing.T, name string, autoscaler *Autoscaler, endpoint *testAutoscalerEndpoint, encoders []*HLS_Encoder_Server, steps []autoscalerTestStep) {
+	server := makeTestAutoscalerServer(encoders)
+	start := time.Now()
+
+	for i := 0; i < len(steps); i++ {
+		for j := 0; j < len(encoders); j++ {
+			encoders[j].load = steps[i].loads[j]
+		}
+
+		server.checkAutoscaling(autoscaler, start.Add(steps[i].at))
+
+		actions := endpoint.takeActions()
+
+		if steps[i].expected == "" && len(actions) > 0 {
+			t.Errorf("%s: step %d (%s): expected no hint, got %v", name, i, steps[i].at, actions)
+		} else if steps[i].expected != "" && (len(actions) != 1 || actions[0] != steps[i].expected) {
+			t.Errorf("%s: step %d (%s): expected %s hint, got %v", name, i, steps[i].at, steps[i].expected, actions)
+		}
+	}
+}
+
+func TestAutoscalerNeedsScaleUp(t *testing.T) {
+	autoscaler := makeTestAutoscaler("")
+
+	cases := []struct {
+		name     string
+		capacity CapacityAPIResponse
+		queued   int
+		expected bool
+	}{
+		{"below threshold", CapacityAPIResponse{Load: 15, Capacity: 20, EncoderCount: 2}, 0, false},
+		{"at threshold", CapacityAPIResponse{Load: 16, Capacity: 20, EncoderCount: 2}, 0, true},
+		{"full", CapacityAPIResponse{Load: 20, Capacity: 20, EncoderCount: 2}, 0, true},
+		{"queued requests", CapacityAPIResponse{Load: 1, Capacity: 20, EncoderCount: 2}, 1, true},
+		{"infinite capacity", CapacityAPIResponse{Load: 100, Capacity: -1, EncoderCount: 2}, 0, false},
+		{"no encoders", CapacityAPIResponse{Load: 0, Capacity: 0, EncoderCount: 0}, 0, false},
+		{"only draining encoders", CapacityAPIResponse{Load: 2, Capacity: 0, EncoderCount: 1}, 0, true},
+	}
+
+	for _, c := range cases {
+		result := autoscaler.needsScaleUp(c.capacity, c.queued)
+
+		if result != c.expected {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, result)
+		}
+	}
+}
+
+func TestAutoscalerCapacityNeeded(t *testing.T) {
+	autoscaler := makeTestAutoscaler("")
+
+	cases := []struct {
+		name     string
+		capacity CapacityAPIResponse
+		queued   int
+		expected int
+	}{
+		{"at threshold", CapacityAPIResponse{Load: 16, Capacity: 20, EncoderCount: 2}, 0, 1},
+		{"full", CapacityAPIResponse{Load: 20, Capacity: 20, EncoderCount: 2}, 0, 6},
+		{"queued requests", CapacityAPIResponse{Load: 20, Capacity: 20, EncoderCount: 2}, 4, 11},
+		{"no encoders", CapacityAPIResponse{Load: 0, Capacity: 0, EncoderCount: 0}, 2, 3},
+		{"infinite capacity", CapacityAPIResponse{Load: 20, Capacity: -1, EncoderCount: 2}, 3, 3},
+	}
+
+	for _, c := range cases {
+		result := autoscaler.getCapacityNeeded(c.capacity, c.queued)
+
+		if result != c.expected {
+			t.Errorf("%s: expected %d, got %d", c.name, c.expected, result)
+		}
+	}
+}
+
+func TestAutoscalerRemovableEncoders(t *testing.T) {
+	drainingEncoder := func(id uint64, capacity int) *HLS_Encoder_Server {
+		encoder := makeTestEncoder(id, capacity, 0)
+		encoder.draining = true
+		return encoder
+	}
+
+	cases := []struct {
+		name        string
+		encoders    []*HLS_Encoder_Server
+		minEncoders int
+		expected    []uint64
+	}{
+		{"no encoders", []*HLS_Encoder_Server{}, 1, []uint64{}},
+		{"excludes draining and loaded", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 2), makeTestEncoder(2, 4, 0), drainingEncoder(3, 4), makeTestEncoder(4, 4, 1)}, 1, []uint64{2}},
+		{"keeps the utilization below the scale-up threshold", []*HLS_Encoder_Server{makeTestEncoder(1, 5, 4), makeTestEncoder(2, 4, 0), makeTestEncoder(3, 4, 0)}, 1, []uint64{3}},
+		{"newest first", []*HLS_Encoder_Server{makeTestEncoder(1, 10, 1), makeTestEncoder(2, 4, 0), makeTestEncoder(3, 4, 0)}, 1, []uint64{3, 2}},
+		{"keeps the min encoders", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 0), makeTestEncoder(2, 4, 0), makeTestEncoder(3, 4, 0)}, 2, []uint64{3}},
+		{"keeps at least one encoder", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 0), makeTestEncoder(2, 4, 0)}, 1, []uint64{2}},
+		{"draining encoders do not count as kept", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 0), drainingEncoder(2, 4)}, 1, []uint64{}},
+	}
+
+	for _, c := range cases {
+		server := makeTestAutoscalerServer(c.encoders)
+
+		server.sessions[2] = &ControlSession{id: 2, ip: "10.0.0.2"}
+
+		autoscaler := makeTestAutoscaler("")
+		autoscaler.minEncoders = c.minEncoders
+
+		result := server.getRemovableEncoders(autoscaler, server.coordinator.GetCapacity())
+
+		if len(result) != len(c.expected) {
+			t.Errorf("%s: expected %d encoders, got %d", c.name, len(c.expected), len(result))
+			continue
+		}
+
+		for i := 0; i < len(result); i++ {
+			if result[i].Id != c.expected[i] {
+				t.Errorf("%s: expected encoder #%d at position %d, got #%d", c.name, c.expected[i], i, result[i].Id)
+			}
+
+			if result[i].Id == 2 && result[i].IP != "10.0.0.2" {
+				t.Errorf("%s: expected the IP of encoder #2, got %s", c.name, result[i].IP)
+			}
+		}
+	}
+}
+
+func TestAutoscalerHysteresis(t *testing.T) {
+	endpoint := startTestAutoscalerEndpoint(t)
+
+	encoders := []*HLS_Encoder_Server{makeTestEncoder(1, 10, 0), makeTestEncoder(2, 10, 0)}
+
+	runAutoscalerTestSteps(t, "hysteresis", makeTestAutoscaler(endpoint.server.URL), endpoint, encoders, []autoscalerTestStep{
+		{0, []int{6, 4}, ""}, // 50%, between the thresholds
+		{10 * time.Second, []int{10, 6}, AUTOSCALER_ACTION_SCALE_UP},   // 80%
+		{20 * time.Second, []int{10, 6}, ""},                           // Cooldown
+		{41 * time.Second, []int{10, 6}, AUTOSCALER_ACTION_SCALE_UP},   // Cooldown passed
+		{50 * time.Second, []int{6, 0}, ""},                            // 30%, scale-down delay starts
+		{100 * time.Second, []int{6, 0}, ""},                           // Scale-down delay
+		{111 * time.Second, []int{6, 0}, AUTOSCALER_ACTION_SCALE_DOWN}, // Scale-down delay passed
+		{120 * time.Second, []int{6, 0}, ""},                           // Cooldown
+		{150 * time.Second, []int{6, 4}, ""},                           // 50%, scale-down delay is reset
+		{160 * time.Second, []int{6, 0}, ""},                           // 30%, scale-down delay starts again
+		{200 * time.Second, []int{6, 0}, ""},                           // Scale-down delay
+		{221 * time.Second, []int{6, 0}, AUTOSCALER_ACTION_SCALE_DOWN}, // Scale-down delay passed
+	})
+}
+
+func TestAutoscalerScaleUpDelay(t *testing.T) {
+	endpoint := startTestAutoscalerEndpoint(t)
+
+	autoscaler := makeTestAutoscaler(endpoint.server.URL)
+	autoscaler.scaleUpDelay = 20 * time.Second
+
+	encoders := []*HLS_Encoder_Server{makeTestEncoder(1, 10, 0), makeTestEncoder(2, 10, 0)}
+
+	runAutoscalerTestSteps(t, "scale-up delay", autoscaler, endpoint, encoders, []autoscalerTestStep{
+		{0, []int{10, 6}, ""},                                        // 80%, scale-up delay starts
+		{10 * time.Second, []int{10, 6}, ""},                         // Scale-up delay
+		{15 * time.Second, []int{10, 5}, ""},                         // 75%, scale-up delay is reset
+		{20 * time.Second, []int{10, 6}, ""},                         // 80%, scale-up delay starts again
+		{30 * time.Second, []int{10, 6}, ""},                         // Scale-up delay
+		{40 * time.Second, []int{10, 6}, AUTOSCALER_ACTION_SCALE_UP}, // Scale-up delay passed
+	})
+}
+
+func TestAutoscalerScaleDownAfterScaleUp(t *testing.T) {
+	endpoint := startTestAutoscalerEndpoint(t)
+
+	autoscaler := makeTestAutoscaler(endpoint.server.URL)
+	autoscaler.scaleDownDelay = 0
+
+	encoders := []*HLS_Encoder_Server{makeTestEncoder(1, 10, 0), makeTestEncoder(2, 10, 0)}
+
+	runAutoscalerTestSteps(t, "scale-down after scale-up", autoscaler, endpoint, encoders, []autoscalerTestStep{
+		{0, []int{10, 6}, AUTOSCALER_ACTION_SCALE_UP},                 // 80%
+		{10 * time.Second, []int{6, 0}, ""},                           // 30%, cooldown of the scale-up
+		{31 * time.Second, []int{6, 0}, AUTOSCALER_ACTION_SCALE_DOWN}, // Cooldown passed
+	})
+}
+
+func TestAutoscalerNoScaleDownWithLoad(t *testing.T) {
+	endpoint := startTestAutoscalerEndpoint(t)
+
+	autoscaler := makeTestAutoscaler(endpoint.server.URL)
+	autoscaler.scaleDownDelay = 0
+
+	encoders := []*HLS_Encoder_Server{makeTestEncoder(1, 10, 0), makeTestEncoder(2, 10, 0), makeTestEncoder(3, 10, 0)}
+	encoders[2].draining = true
+
+	runAutoscalerTestSteps(t, "no scale-down with load", autoscaler, endpoint, encoders, []autoscalerTestStep{
+		{0, []int{3, 3, 0}, ""}, // 30%, but every active encoder has load, and #3 is draining
+		{10 * time.Second, []int{6, 0, 0}, AUTOSCALER_ACTION_SCALE_DOWN}, // #2 is idle
+	})
+}
+
+func TestAutoscalerRetry(t *testing.T) {
+	endpoint := startTestAutoscalerEndpoint(t)
+	endpoint.statusCode = http.StatusInternalServerError
+
+	encoders := []*HLS_Encoder_Server{makeTestEncoder(1, 10, 0), makeTestEncoder(2, 10, 0)}
+	server := makeTestAutoscalerServer(encoders)
+	autoscaler := makeTestAutoscaler(endpoint.server.URL)
+
+	encoders[0].load = 10
+	encoders[1].load = 6
+
+	start := time.Now()
+
+	server.checkAutoscaling(autoscaler, start)
+
+	if len(endpoint.takeActions()) != 1 {
+		t.Fatal("expected a scale-up hint")
+	}
+
+	endpoint.mutex.Lock()
+	endpoint.statusCode = http.StatusOK
+	endpoint.mutex.Unlock()
+
+	// The hint was not accepted, so there is no cooldown
+
+	server.checkAutoscaling(autoscaler, start.Add(time.Second))
+
+	if len(endpoint.takeActions()) != 1 {
+		t.Fatal("expected the scale-up hint to be sent again")
+	}
+
+	server.checkAutoscaling(autoscaler, start.Add(2*time.Second))
+
+	if len(endpoint.takeActions()) != 0 {
+		t.Fatal("expected no hint during the cooldown")
+	}
+}
@@ -0,0 +1,155 @@
+// Redundant ingest (primary and backup publishers)
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const BACKUP_INGEST_DEFAULT_STALL_TIMEOUT = 10 // Default time the source of the primary publisher can be stalled before switching to the backup (seconds)
+
+// Reasons to switch to the backup publisher
+const (
+	BACKUP_SWITCH_REASON_ENDED   = "publisher-ended"
+	BACKUP_SWITCH_REASON_STALLED = "publisher-stalled"
+	BACKUP_SWITCH_REASON_LOST    = "publisher-lost"
+)
+
+// Accepts a backup publisher for a channel that is already being published (backupIngest policy)
+// The backup publisher stays on standby until the primary publisher ends or stalls
+// Must be called with the channel acquired. The channel is released
+// requestId - Request ID
+// channelData - The channel
+// key - The streaming key
+// ip - User IP
+func (session *ControlSession) AcceptBackupPublisher(requestId string, channelData *StreamingChannel, key string, ip string) {
+	channel := channelData.id
+	streamId := channelData.streamId
+
+	if channelData.publisher == session.id {
+		// A streaming server can only have one publisher for each channel
+		session.server.coordinator.ReleaseChannel(channelData)
+		session.DenyPublish(requestId, channel, ip, PUBLISH_DENY_REASON_ALREADY_PUBLISHING)
+		return
+	}
+
+	channelData.backupPublisher = session.id
+	if session.sessionType == SESSION_TYPE_RTMP {
+		channelData.backupPublishMethod = PUBLISH_METHOD_RTMP
+	} else {
+		channelData.backupPublishMethod = PUBLISH_METHOD_WS
+	}
+	channelData.backupSourceURL = session.GeneratePublishSourceURL(channel, key)
+	session.AssociateChannel(channel)
+
+	session.server.coordinator.ReleaseChannel(channelData)
+
+	session.SendPublishAccept(requestId, channel, streamId)
+
+	session.log("BACKUP PUBLISHER: " + channel + "/" + streamId)
+
+	METRICS.OnPublishRequest(true, "")
+
+	session.server.coordinator.liveEvents.Publish(&LiveEvent{
+		EventType:  LIVE_EVENT_PUBLISH_ACCEPTED,
+		Channel:    channel,
+		StreamId:   streamId,
+		ServerId:   session.id,
+		ServerType: GetStreamingServerTypeName(session.sessionType),
+	})
+}
+
+// Switches a stream to its backup publisher
+// The stream keeps the same ID, and the encoding is restarted from the backup source, continuing the fragment numbering
+// Must be called with the channel acquired
+// channelData - The channel
+// encoderSession - Session of the encoder of the stream (nil if not connected)
+// reason - Reason of the switch (BACKUP_SWITCH_REASON_*)
+func (server *Streaming_Coordinator_Server) switchToBackupPublisher(channelData *StreamingChannel, encoderSession *ControlSession, reason string) {
+	channel := channelData.id
+	streamId := channelData.streamId
+	primary := channelData.publisher
+
+	channelData.publisher = channelData.backupPublisher
+	channelData.publishMethod = channelData.backupPublishMethod
+	channelData.sourceURL = channelData.backupSourceURL
+	channelData.sourceBitrate = 0
+	channelData.sourceStalledSince = 0
+
+	channelData.backupPublisher = 0
+	channelData.backupSourceURL = ""
+
+	server.coordinator.UpdateChannelState(channelData)
+
+	if channelData.encoder != 0 {
+		// The encoding is restarted from the backup source when the current task ends
+		// If the encoder is disconnected, the stream is moved to another encoder using the backup source
+		server.coordinator.AddMigratingStream(channel, streamId, 0)
+
+		if encoderSession != nil {
+			encoderSession.SendEncodeStop(channel, streamId)
+		}
+	}
+
+	LogInfo("[BACKUP] Switched " + channel + "/" + streamId + " from streaming server #" + fmt.Sprint(primary) + " to the backup publisher in streaming server #" + fmt.Sprint(channelData.publisher) + " | REASON: " + reason)
+
+	server.coordinator.liveEvents.Publish(&LiveEvent{
+		EventType: LIVE_EVENT_BACKUP_SWITCH,
+		Channel:   channel,
+		StreamId:  streamId,
+		ServerId:  channelData.publisher,
+		Reason:    reason,
+	})
+}
+
+// Releases the backup publisher of a stream, disconnecting it
+// Call it when the stream is closed
+// Must be called with the channel acquired
+// channelData - The channel
+// backupSession - Session of the streaming server of the backup publisher (nil if not connected)
+func (server *Streaming_Coordinator_Server) releaseBackupPublisher(channelData *StreamingChannel, backupSession *ControlSession) {
+	if channelData.backupPublisher == 0 {
+		return
+	}
+
+	channelData.backupPublisher = 0
+	channelData.backupSourceURL = ""
+
+	if backupSession != nil {
+		backupSession.SendStreamKill(channelData.id, channelData.streamId)
+		backupSession.DisassociateChannel(channelData.id)
+	}
+}
+
+// Checks if the source of the primary publisher is stalled (reported bitrate is 0)
+// If it is stalled for longer than BACKUP_INGEST_STALL_SECONDS, the primary publisher is killed, and the stream switches to the backup publisher
+// Must be called with the channel acquired, after updating the source bitrate
+// session - Session of the streaming server of the primary publisher
+// channelData - The channel
+func (session *ControlSession) checkPublisherStall(channelData *StreamingChannel) {
+	if channelData.sourceBitrate > 0 {
+		channelData.sourceStalledSince = 0
+		return
+	}
+
+	now := time.Now().UnixMilli()
+
+	if channelData.sourceStalledSince == 0 {
+		channelData.sourceStalledSince = now
+		return
+	}
+
+	stallTimeout := session.server.coordinator.backupStallTimeout
+
+	if channelData.backupPublisher == 0 || stallTimeout <= 0 || now-channelData.sourceStalledSince < stallTimeout.Milliseconds() {
+		return
+	}
+
+	session.log("PUBLISHER STALLED: " + channelData.id + "/" + channelData.streamId + ". Switching to the backup publisher.")
+
+	session.SendStreamKill(channelData.id, channelData.streamId)
+	session.DisassociateChannel(channelData.id)
+
+	session.server.switchToBackupPublisher(channelData, session.server.GetSession(channelData.encoder), BACKUP_SWITCH_REASON_STALLED)
+}
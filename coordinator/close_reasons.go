@@ -0,0 +1,74 @@
+// Reasons for closing the streams
+
+package main
+
+import "strings"
+
+// Close reasons
+const (
+	CLOSE_REASON_PUBLISHER_ENDED     = "publisher-ended"     // The publisher stopped publishing (default if the streaming server does not send any reason)
+	CLOSE_REASON_PUBLISHER_LOST      = "publisher-lost"      // The streaming server of the publisher disconnected
+	CLOSE_REASON_KILLED              = "killed"              // The stream was closed with the close command
+	CLOSE_REASON_MAX_DURATION        = "max-duration"        // The stream reached its max duration
+	CLOSE_REASON_ENCODER_LOST        = "encoder-lost"        // The encoder disconnected, and the stream could not be moved to another one
+	CLOSE_REASON_COORDINATOR_RESTART = "coordinator-restart" // The stream was active before the coordinator restarted, and it was not recovered
+)
+
+// Close reasons reported by the encoder when the stream fails
+const (
+	CLOSE_REASON_PROBE_ERROR   = "probe-error"   // The encoder could not probe the source stream
+	CLOSE_REASON_ENCODER_ERROR = "encoder-error" // The encoding process failed
+)
+
+// Reason why a stream was closed
+type StreamCloseReason struct {
+	code    string // Reason code
+	message string // Human readable message
+}
+
+// Creates a close reason from the parameters of a message
+// Line breaks are removed from the message
+// code - Reason code
+// message - Message
+// Returns the close reason
+func MakeStreamCloseReason(code string, message string) StreamCloseReason {
+	return StreamCloseReason{
+		code:    strings.ToLower(code),
+		message: strings.NewReplacer("\r", "", "\n", " ").Replace(message),
+	}
+}
+
+// Checks if the reason indicates the stream failed, instead of ending normally
+// Returns true if the stream failed
+func (reason StreamCloseReason) IsFailure() bool {
+	switch reason.code {
+	case CLOSE_REASON_PROBE_ERROR, CLOSE_REASON_ENCODER_ERROR, CLOSE_REASON_ENCODER_LOST:
+		return true
+	default:
+		return false
+	}
+}
+
+// Sets the reason why the current stream of the channel is being closed
+// If a reason was already set, it is kept, since it is the original cause
+// Must be called with the channel acquired
+// reason - The reason
+func (channelData *StreamingChannel) SetCloseReason(reason StreamCloseReason) {
+	if channelData.closeReason.code != "" || reason.code == "" {
+		return
+	}
+
+	channelData.closeReason = reason
+}
+
+// Gets the reason why a stream of the channel was closed
+// Must be called with the channel acquired
+// streamId - The stream ID
+// Returns the reason (empty if unknown, or if the stream is not the current stream of the channel)
+func (channelData *StreamingChannel) GetCloseReason(streamId string) StreamCloseReason {
+	if channelData.streamId != streamId {
+		return StreamCloseReason{}
+	}
+
+	return channelData.closeReason
+}
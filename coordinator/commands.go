@@ -40,6 +40,10 @@ func (server *Streaming_Coordinator_Server) KillStream(channel string, streamId
 		return
 	}
 
+	if channelData.streamId == streamId || streamId == "" || streamId == "*" {
+		channelData.SetCloseReason(MakeStreamCloseReason(CLOSE_REASON_KILLED, "The stream was closed by the application"))
+	}
+
 	publishSession := server.GetSession(channelData.publisher)
 
 	server.coordinator.ReleaseChannel(channelData)
@@ -100,6 +104,15 @@ func (coord *Streaming_Coordinator) GetCapacity() CapacityAPIResponse {
 
 		totalLoad += encoder.load
 
+		if encoder.draining {
+			// Draining encoders do not accept new streams, so they count as full
+			if totalCapacity >= 0 {
+				totalCapacity += encoder.load
+			}
+
+			continue
+		}
+
 		if totalCapacity >= 0 {
 			if encoder.capacity < 0 {
 				totalCapacity = -1
@@ -124,25 +137,58 @@ type ReportAPIResponse_StreamingServer struct {
 	Port       int    `json:"port"`
 	SSL        bool   `json:"ssl"`
 	ServerType string `json:"serverType"`
+	Draining   bool   `json:"draining"`
+}
+
+type ReportAPIResponse_EncoderTask struct {
+	Channel       string  `json:"channel"`
+	StreamId      string  `json:"streamId"`
+	Speed         float64 `json:"speed"`
+	FPS           float64 `json:"fps"`
+	DroppedFrames uint64  `json:"droppedFrames"`
+}
+
+type ReportAPIResponse_EncoderStats struct {
+	CPUUsage    float64                         `json:"cpuUsage"`
+	MemoryUsed  uint64                          `json:"memoryUsed"`
+	MemoryTotal uint64                          `json:"memoryTotal"`
+	Tasks       []ReportAPIResponse_EncoderTask `json:"tasks"`
+	Timestamp   int64                           `json:"timestamp"`
 }
 
 type ReportAPIResponse_Encoder struct {
-	Id       uint64 `json:"id"`
-	Capacity int    `json:"capacity"`
-	Load     int    `json:"load"`
+	Id       uint64                          `json:"id"`
+	Capacity int                             `json:"capacity"`
+	Load     int                             `json:"load"`
+	Tags     []string                        `json:"tags"`
+	Stats    *ReportAPIResponse_EncoderStats `json:"stats,omitempty"`
+
+	Draining      bool  `json:"draining"`
+	DrainDeadline int64 `json:"drainDeadline,omitempty"`
 }
 
 type ReportAPIResponse_ActiveStream struct {
+	Channel      string            `json:"channel"`
+	StreamId     string            `json:"streamId"`
+	StreamServer uint64            `json:"streamServer"`
+	BackupServer uint64            `json:"backupStreamServer,omitempty"`
+	Encoder      uint64            `json:"encoder"`
+	SourceInfo   *StreamSourceInfo `json:"sourceInfo,omitempty"`
+	Stats        StreamStats       `json:"stats"`
+}
+
+type ReportAPIResponse_QueuedPublisher struct {
 	Channel      string `json:"channel"`
-	StreamId     string `json:"streamId"`
 	StreamServer uint64 `json:"streamServer"`
-	Encoder      uint64 `json:"encoder"`
+	Priority     int    `json:"priority"`
+	QueueTime    int64  `json:"queueTime"`
 }
 
 type ReportAPIResponse struct {
 	StreamingServers []ReportAPIResponse_StreamingServer `json:"streamingServers"`
 	Encoders         []ReportAPIResponse_Encoder         `json:"encoders"`
 	ActiveStreams    []ReportAPIResponse_ActiveStream    `json:"activeStreams"`
+	PublishQueue     []ReportAPIResponse_QueuedPublisher `json:"publishQueue"`
 }
 
 // Runs report command
@@ -175,6 +221,70 @@ func (server *Streaming_Coordinator_Server) RunReportCommand(w http.ResponseWrit
 	fmt.Fprintf(w, string(json))
 }
 
+// Runs the stream report command
+// Reports the status and stats of the active stream of a channel
+// w - Writer to send the response
+// req - Client request
+func (server *Streaming_Coordinator_Server) RunStreamReportCommand(w http.ResponseWriter, req *http.Request) {
+	authentication := req.Header.Get("Authorization")
+
+	if !CheckCommandAuthentication(authentication) {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Invalid authorization header.")
+		return
+	}
+
+	w.Header().Add("Cache-Control", "no-cache")
+
+	channel := req.Header.Get("x-streaming-channel")
+
+	taskStats := server.coordinator.GetEncoderTaskStatsMap()
+
+	channelData := server.coordinator.AcquireChannel(channel)
+
+	if channelData.closed {
+		server.coordinator.ReleaseChannel(channelData)
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Not found.")
+		return
+	}
+
+	report := channelData.GetActiveStreamReport(taskStats)
+
+	server.coordinator.ReleaseChannel(channelData)
+
+	json, err := json.Marshal(report)
+
+	if err != nil {
+		LogError(err)
+		w.WriteHeader(500)
+		fmt.Fprintf(w, "ERROR: "+err.Error())
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+
+	w.WriteHeader(200)
+	fmt.Fprintf(w, string(json))
+}
+
+// Generates the report of the active stream of a channel
+// Must be called with the channel acquired
+// channelData - The channel
+// taskStats - Stats of the encoding tasks (from GetEncoderTaskStatsMap)
+// Returns the report
+func (channelData *StreamingChannel) GetActiveStreamReport(taskStats map[string]EncoderTaskStats) ReportAPIResponse_ActiveStream {
+	return ReportAPIResponse_ActiveStream{
+		Channel:      channelData.id,
+		StreamId:     channelData.streamId,
+		StreamServer: channelData.publisher,
+		BackupServer: channelData.backupPublisher,
+		Encoder:      channelData.encoder,
+		SourceInfo:   channelData.sourceInfo,
+		Stats:        channelData.GetStats(taskStats),
+	}
+}
+
 // Generates a report of the current status
 func (coord *Streaming_Coordinator) GetReport() ReportAPIResponse {
 	channelList := make([]string, 0)
@@ -194,6 +304,7 @@ func (coord *Streaming_Coordinator) GetReport() ReportAPIResponse {
 			Port:       server.port,
 			SSL:        server.ssl,
 			ServerType: "RTMP",
+			Draining:   server.draining,
 		})
 	}
 
@@ -204,31 +315,58 @@ func (coord *Streaming_Coordinator) GetReport() ReportAPIResponse {
 			Port:       server.port,
 			SSL:        server.ssl,
 			ServerType: "WS",
+			Draining:   server.draining,
 		})
 	}
 
 	for _, encoder := range coord.hlsEncoders {
+		var stats *ReportAPIResponse_EncoderStats = nil
+
+		if encoder.stats != nil {
+			tasks := make([]ReportAPIResponse_EncoderTask, 0)
+
+			for i := 0; i < len(encoder.stats.tasks); i++ {
+				tasks = append(tasks, ReportAPIResponse_EncoderTask{
+					Channel:       encoder.stats.tasks[i].channel,
+					StreamId:      encoder.stats.tasks[i].streamId,
+					Speed:         encoder.stats.tasks[i].speed,
+					FPS:           encoder.stats.tasks[i].fps,
+					DroppedFrames: encoder.stats.tasks[i].droppedFrames,
+				})
+			}
+
+			stats = &ReportAPIResponse_EncoderStats{
+				CPUUsage:    encoder.stats.cpuUsage,
+				MemoryUsed:  encoder.stats.memoryUsed,
+				MemoryTotal: encoder.stats.memoryTotal,
+				Tasks:       tasks,
+				Timestamp:   encoder.stats.timestamp,
+			}
+		}
+
 		encoders = append(encoders, ReportAPIResponse_Encoder{
 			Id:       encoder.id,
 			Capacity: encoder.capacity,
 			Load:     encoder.load,
+			Tags:     encoder.tags,
+			Stats:    stats,
+
+			Draining:      encoder.draining,
+			DrainDeadline: encoder.drainDeadline,
 		})
 	}
 
 	coord.mutex.Unlock()
 
+	taskStats := coord.GetEncoderTaskStatsMap()
+
 	activeStreams := make([]ReportAPIResponse_ActiveStream, 0)
 
 	for i := 0; i < len(channelList); i++ {
 		channelData := coord.AcquireChannel(channelList[i])
 
 		if !channelData.closed {
-			activeStreams = append(activeStreams, ReportAPIResponse_ActiveStream{
-				Channel:      channelData.id,
-				StreamId:     channelData.id,
-				StreamServer: channelData.publisher,
-				Encoder:      channelData.encoder,
-			})
+			activeStreams = append(activeStreams, channelData.GetActiveStreamReport(taskStats))
 		}
 
 		coord.ReleaseChannel(channelData)
@@ -238,5 +376,6 @@ func (coord *Streaming_Coordinator) GetReport() ReportAPIResponse {
 		ActiveStreams:    activeStreams,
 		StreamingServers: streamingServers,
 		Encoders:         encoders,
+		PublishQueue:     coord.publishQueue.GetReport(),
 	}
 }
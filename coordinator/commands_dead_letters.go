@@ -0,0 +1,102 @@
+// Dead letters commands
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Response for the dead letters API
+type DeadLettersAPIResponse struct {
+	DeadLetters []EventDeadLetter `json:"deadLetters"`
+}
+
+// Runs dead letters list command
+// w - Writer to send the response
+// req - Client request
+func (server *Streaming_Coordinator_Server) RunDeadLettersListCommand(w http.ResponseWriter, req *http.Request) {
+	authentication := req.Header.Get("Authorization")
+
+	if !CheckCommandAuthentication(authentication) {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Invalid authorization header.")
+		return
+	}
+
+	w.Header().Add("Cache-Control", "no-cache")
+
+	response := DeadLettersAPIResponse{
+		DeadLetters: server.coordinator.outbox.GetDeadLetters(),
+	}
+
+	json, err := json.Marshal(response)
+
+	if err != nil {
+		LogError(err)
+		w.WriteHeader(500)
+		fmt.Fprintf(w, "ERROR: "+err.Error())
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+
+	w.WriteHeader(200)
+	fmt.Fprint(w, string(json))
+}
+
+// Runs dead letters replay command
+// w - Writer to send the response
+// req - Client request
+func (server *Streaming_Coordinator_Server) RunDeadLettersReplayCommand(w http.ResponseWriter, req *http.Request) {
+	authentication := req.Header.Get("Authorization")
+
+	if !CheckCommandAuthentication(authentication) {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Invalid authorization header.")
+		return
+	}
+
+	eventId := req.Header.Get("x-event-id")
+	subscriptionId := req.Header.Get("x-subscription-id")
+
+	idsToReplay := make([]string, 0)
+
+	deadLetters := server.coordinator.outbox.GetDeadLetters()
+
+	for i := 0; i < len(deadLetters); i++ {
+		if eventId != "*" && deadLetters[i].Event.Id != eventId {
+			continue
+		}
+
+		if subscriptionId != "" && deadLetters[i].Subscription != subscriptionId {
+			continue
+		}
+
+		idsToReplay = append(idsToReplay, deadLetters[i].Id)
+	}
+
+	replayed := 0
+
+	for i := 0; i < len(idsToReplay); i++ {
+		delivery := server.coordinator.outbox.ReplayDeadLetter(idsToReplay[i])
+
+		if delivery == nil {
+			continue
+		}
+
+		replayed++
+
+		go server.coordinator.SendOutboxDelivery(delivery)
+	}
+
+	if replayed == 0 && eventId != "*" {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Event not found.")
+		return
+	}
+
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "SUCCESS")
+}
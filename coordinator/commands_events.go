@@ -0,0 +1,225 @@
+// Live events command
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const LIVE_EVENTS_HEARTBEAT_INTERVAL = 20 * time.Second
+
+// Runs the live events command
+// Streams the events using Server-Sent Events, or WebSocket if the client requests an upgrade
+// w - Writer to send the response
+// req - Client request
+func (server *Streaming_Coordinator_Server) RunLiveEventsCommand(w http.ResponseWriter, req *http.Request) {
+	authentication := req.Header.Get("Authorization")
+
+	if !CheckCommandAuthentication(authentication) {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Invalid authorization header.")
+		return
+	}
+
+	lastSequence := uint64(0)
+	lastEventId := req.Header.Get("Last-Event-ID")
+
+	if lastEventId != "" {
+		n, err := strconv.ParseUint(lastEventId, 10, 64)
+
+		if err != nil {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "Invalid Last-Event-ID header.")
+			return
+		}
+
+		lastSequence = n
+	}
+
+	if websocket.IsWebSocketUpgrade(req) {
+		server.runLiveEventsWebSocket(w, req, lastSequence)
+	} else {
+		server.runLiveEventsSSE(w, req, lastSequence)
+	}
+}
+
+// Gets the events to send after subscribing
+// missed - Events missed by the client
+// lost - True if some events were lost
+// Returns the list of events to send
+func getLiveEventsToResume(missed []*LiveEvent, lost bool) []*LiveEvent {
+	if !lost {
+		return missed
+	}
+
+	result := []*LiveEvent{
+		{
+			Sequence:  0,
+			Timestamp: time.Now().UnixMilli(),
+			EventType: LIVE_EVENT_EVENTS_LOST,
+		},
+	}
+
+	return append(result, missed...)
+}
+
+// Streams the live events using Server-Sent Events
+// w - Writer to send the response
+// req - Client request
+// lastSequence - Sequence number of the last event received by the client
+func (server *Streaming_Coordinator_Server) runLiveEventsSSE(w http.ResponseWriter, req *http.Request, lastSequence uint64) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, "ERROR: Streaming not supported.")
+		return
+	}
+
+	subscriber, missed, lost := server.coordinator.liveEvents.Subscribe(lastSequence)
+	defer server.coordinator.liveEvents.Unsubscribe(subscriber)
+
+	w.Header().Add("Content-Type", "text/event-stream")
+	w.Header().Add("Cache-Control", "no-cache")
+	w.Header().Add("Connection", "keep-alive")
+
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	writeEvent := func(event *LiveEvent) bool {
+		data, err := json.Marshal(event)
+
+		if err != nil {
+			LogError(err)
+			return true
+		}
+
+		if event.Sequence > 0 {
+			_, err = fmt.Fprintf(w, "id: %d\n", event.Sequence)
+
+			if err != nil {
+				return false
+			}
+		}
+
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.EventType, string(data))
+
+		if err != nil {
+			return false
+		}
+
+		flusher.Flush()
+
+		return true
+	}
+
+	resumeEvents := getLiveEventsToResume(missed, lost)
+
+	for i := 0; i < len(resumeEvents); i++ {
+		if !writeEvent(resumeEvents[i]) {
+			return
+		}
+	}
+
+	heartbeatTicker := time.NewTicker(LIVE_EVENTS_HEARTBEAT_INTERVAL)
+	defer heartbeatTicker.Stop()
+
+	for {
+		select {
+		case event, ok := <-subscriber.events:
+			if !ok {
+				return // Too slow, the client must resume
+			}
+
+			if !writeEvent(event) {
+				return
+			}
+		case <-heartbeatTicker.C:
+			_, err := fmt.Fprintf(w, ": heartbeat\n\n")
+
+			if err != nil {
+				return
+			}
+
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// Streams the live events using a WebSocket connection
+// w - Writer to send the response
+// req - Client request
+// lastSequence - Sequence number of the last event received by the client
+func (server *Streaming_Coordinator_Server) runLiveEventsWebSocket(w http.ResponseWriter, req *http.Request, lastSequence uint64) {
+	conn, err := server.wsUpgrader.Upgrade(w, req, nil)
+
+	if err != nil {
+		LogError(err)
+		return
+	}
+
+	defer conn.Close()
+
+	subscriber, missed, lost := server.coordinator.liveEvents.Subscribe(lastSequence)
+
+	// Read incoming messages (ignored) until the connection is closed
+
+	go func() {
+		defer server.coordinator.liveEvents.Unsubscribe(subscriber)
+
+		for {
+			_, _, err := conn.ReadMessage()
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	writeEvent := func(event *LiveEvent) bool {
+		data, err := json.Marshal(event)
+
+		if err != nil {
+			LogError(err)
+			return true
+		}
+
+		return conn.WriteMessage(websocket.TextMessage, data) == nil
+	}
+
+	resumeEvents := getLiveEventsToResume(missed, lost)
+
+	for i := 0; i < len(resumeEvents); i++ {
+		if !writeEvent(resumeEvents[i]) {
+			return
+		}
+	}
+
+	heartbeatTicker := time.NewTicker(LIVE_EVENTS_HEARTBEAT_INTERVAL)
+	defer heartbeatTicker.Stop()
+
+	for {
+		select {
+		case event, ok := <-subscriber.events:
+			if !ok {
+				return // Closed or too slow
+			}
+
+			if !writeEvent(event) {
+				return
+			}
+		case <-heartbeatTicker.C:
+			if conn.WriteMessage(websocket.PingMessage, nil) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Key validation cache invalidation command
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Runs the key validation cache invalidation command
+// w - Writer to send the response
+// req - Client request
+func (server *Streaming_Coordinator_Server) RunInvalidateKeyCommand(w http.ResponseWriter, req *http.Request) {
+	authentication := req.Header.Get("Authorization")
+
+	if !CheckCommandAuthentication(authentication) {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Invalid authorization header.")
+		return
+	}
+
+	channel := req.Header.Get("x-streaming-channel")
+	key := req.Header.Get("x-streaming-key")
+
+	if channel == "" {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Missing x-streaming-channel header.")
+		return
+	}
+
+	if key == "*" {
+		key = ""
+	}
+
+	removed := server.coordinator.keyValidationCache.Invalidate(channel, key)
+
+	LogDebug("Invalidated " + fmt.Sprint(removed) + " cached key validation results for channel: " + channel)
+
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "SUCCESS")
+}
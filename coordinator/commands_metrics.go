@@ -0,0 +1,37 @@
+// Metrics command
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Runs metrics command
+// Requires the commands authentication, unless METRICS_PUBLIC is set to YES
+// w - Writer to send the response
+// req - Client request
+func (server *Streaming_Coordinator_Server) RunMetricsCommand(w http.ResponseWriter, req *http.Request) {
+	if os.Getenv("METRICS_PUBLIC") != "YES" {
+		authentication := req.Header.Get("Authorization")
+
+		if !CheckCommandAuthentication(authentication) {
+			w.WriteHeader(401)
+			fmt.Fprintf(w, "Invalid authorization header.")
+			return
+		}
+	}
+
+	sb := &strings.Builder{}
+
+	server.coordinator.SerializeMetrics(sb)
+	METRICS.Serialize(sb)
+
+	w.Header().Add("Cache-Control", "no-cache")
+	w.Header().Add("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	w.WriteHeader(200)
+	fmt.Fprint(w, sb.String())
+}
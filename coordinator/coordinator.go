@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +23,28 @@ type Streaming_Coordinator struct {
 
 	hlsEncoders map[uint64]*HLS_Encoder_Server // Map of HLS encoders
 
+	encoderAssignmentStrategy   string                         // Name of the strategy to assign encoders
+	encoderStickyTTL            time.Duration                  // Time to remember the encoder that last served a closed channel
+	channelLastEncoder          map[string]*ChannelLastEncoder // Encoder that last served each channel. Map: channel -> Last encoder
+	channelLastEncoderPruneTime int64                          // Unix milliseconds of the last time the expired entries of channelLastEncoder were pruned
+
+	encoderAssignmentFilter EncoderAssignmentFilter // Configuration to exclude overloaded encoders, by their stats
+
+	encoderFailover bool // True to move the streams to another encoder if their encoder disconnects
+
+	encoderReconnectGracePeriod time.Duration     // Time to wait for a disconnected encoder to reconnect and adopt its streams
+	lostEncoderStreams          map[string]uint64 // Streams whose encoder disconnected, waiting for it to reconnect. Map: channel:streamId -> encoder ID
+
+	publisherMigrationGracePeriod time.Duration     // Time to wait for a migrating publisher to reconnect to another streaming server
+	migratingStreams              map[string]uint64 // Streams whose publisher is being replaced (migration or takeover). Map: channel:streamId -> ID of the draining server (0 for a takeover)
+
+	backupStallTimeout time.Duration // Time the source of the primary publisher can be stalled before switching to the backup publisher. 0 = Do not switch on stalls
+
+	publishQueue *PublishQueue // Queue of publish requests waiting for encoder capacity
+
+	recoveryGracePeriod time.Duration                    // Time to wait for the servers to reconnect after a restart
+	recoveringStreams   map[string]*ChannelStateSnapshot // Streams open before the restart, waiting for their publisher and encoder to reconnect. Map: channel:streamId -> Snapshot
+
 	mutex *sync.Mutex // Mutex to access the data
 
 	nextStreamId  uint32      // ID for the next stream
@@ -29,9 +52,27 @@ type Streaming_Coordinator struct {
 
 	pendingStreamClosedEvents map[string]*PendingStreamClosedEvent // List of stream closed event being sent
 
+	maxDurationWarningTime time.Duration // Time before the max duration of a stream to send the warning event
+
+	eventSubscriptions []*EventSubscription    // Subscriptions to receive the event callbacks
+	eventRetryConfig   EventRetryConfiguration // Configuration to retry event callbacks
+
+	outbox *EventOutbox // Outbox to persist the events pending of being delivered
+
+	liveEvents *LiveEventsBus // Live events stream
+
+	keyValidationCache *KeyValidationCache // Cache for the key validation results
+
+	store StateStore // Store to persist the state
+
 	savingActiveStreams             bool   // True if saving active streams
 	pendingSaveActiveStreams        bool   // True if there is pending active streams to save
 	pendingSaveActiveStreamsContent string // Content to save in the pending streams file
+
+	channelsState                   map[string]*ChannelStateSnapshot // Snapshot of the open channels. Map: channel -> Snapshot
+	savingChannelsState             bool                             // True if saving the channels state
+	pendingSaveChannelsState        bool                             // True if there is pending channels state to save
+	pendingSaveChannelsStateContent []byte                           // Content to save in the pending channels state file
 }
 
 const (
@@ -53,6 +94,23 @@ type StreamingChannel struct {
 	publisher uint64 // Id of the server where the publisher is connected
 	encoder   uint64 // ID of the HLS encoder assigned to the stream
 
+	sourceURL       string                // URL for the encoder to fetch the stream
+	resolutions     ResolutionList        // List of resolutions to encode
+	record          bool                  // True if recording is enabled
+	previews        PreviewsConfiguration // Configuration for the image previews
+	config          StreamConfiguration   // Extra configuration of the stream
+	encodeStartTime int64                 // Unix timestamp (milliseconds) when the encoding started
+	sourceInfo      *StreamSourceInfo     // Information of the source stream (nil until the encoder probes it)
+	sourceBitrate   uint64                // Bitrate of the source stream, reported by the streaming server (bits/s). 0 = Unknown
+
+	sourceStalledSince int64 // Unix timestamp (milliseconds) since the streaming server reports a bitrate of 0 for the source. 0 = Not stalled
+
+	backupPublisher     uint64 // ID of the server where the backup publisher is connected. 0 = No backup publisher
+	backupPublishMethod int    // Publish method of the backup publisher
+	backupSourceURL     string // URL for the encoder to fetch the stream from the backup publisher
+
+	closeReason StreamCloseReason // Reason why the current stream is being closed (empty if unknown)
+
 	nextEventId   uint64                                  // Id for the next stream-available event
 	pendingEvents map[uint64]*PendingStreamAvailableEvent // Pending stream-available events
 
@@ -63,6 +121,9 @@ type StreamingChannel struct {
 type PendingStreamAvailableEvent struct {
 	id uint64 // ID of the event call
 
+	eventId   string // Unique event ID
+	timestamp int64  // Event creation timestamp (unix milliseconds)
+
 	channel  string // Channel ID
 	streamId string // Stream ID
 
@@ -81,6 +142,8 @@ type Streaming_RTMP_Server struct {
 	ip   string // Server IP
 	port int    // Server port
 	ssl  bool   // True if uses SSL
+
+	draining bool // True if the server is draining (it does not accept new publishers)
 }
 
 // Stores information about a websocket streaming server
@@ -90,6 +153,8 @@ type Streaming_WSS_Server struct {
 	ip   string // Server IP
 	port int    // Server port
 	ssl  bool   // True if uses SSL
+
+	draining bool // True if the server is draining (it does not accept new publishers)
 }
 
 // Stores information about a HLS encoder
@@ -99,20 +164,35 @@ type HLS_Encoder_Server struct {
 	capacity int // Server capacity (number of streams it can handle in parallel)
 
 	load int // Current server load (number of streams being handled)
+
+	tags []string // Tags of the encoder, to restrict which streams it can handle
+
+	stats *EncoderStats // Last resource usage stats reported by the encoder (nil if none)
+
+	draining      bool  // True if the encoder is draining (it is not assigned new streams)
+	drainDeadline int64 // Unix timestamp (milliseconds) to move the remaining streams to other encoders. 0 = No deadline
 }
 
 // Stores the information for sending Stream-Closed events
 type PendingStreamClosedEvent struct {
+	eventId   string // Unique event ID
+	timestamp int64  // Event creation timestamp (unix milliseconds)
+
 	channel  string // Channel ID
 	streamId string // Stream ID
 
+	closeReason StreamCloseReason // Reason why the stream was closed (empty if unknown)
+
 	cancelled bool // True if the event got cancelled
 }
 
 // Initializes the coordinator status data
-func (coord *Streaming_Coordinator) Initialize() {
+// store - Store to load and save the state
+func (coord *Streaming_Coordinator) Initialize(store StateStore) {
 	coord.mutex = &sync.Mutex{}
 
+	coord.store = store
+
 	coord.channels = make(map[string]*StreamingChannel)
 
 	coord.activeStreams = make(map[string]bool)
@@ -122,15 +202,44 @@ func (coord *Streaming_Coordinator) Initialize() {
 
 	coord.hlsEncoders = make(map[uint64]*HLS_Encoder_Server)
 
+	coord.encoderAssignmentStrategy = GetEncoderAssignmentStrategyName()
+	coord.encoderStickyTTL = time.Duration(getEnvInt("ENCODER_STICKY_TTL_SECONDS", ENCODER_STICKY_DEFAULT_TTL)) * time.Second
+	coord.channelLastEncoder = make(map[string]*ChannelLastEncoder)
+	coord.encoderAssignmentFilter = GetEncoderAssignmentFilter()
+	coord.encoderFailover = os.Getenv("ENCODER_FAILOVER") == "YES"
+	coord.encoderReconnectGracePeriod = time.Duration(getEnvInt("ENCODER_RECONNECT_GRACE_SECONDS", ENCODER_RECONNECT_DEFAULT_GRACE_PERIOD)) * time.Second
+	coord.lostEncoderStreams = make(map[string]uint64)
+	coord.publisherMigrationGracePeriod = time.Duration(getEnvInt("PUBLISHER_MIGRATION_GRACE_SECONDS", PUBLISHER_MIGRATION_DEFAULT_GRACE_PERIOD)) * time.Second
+	coord.migratingStreams = make(map[string]uint64)
+	coord.backupStallTimeout = time.Duration(getEnvInt("BACKUP_INGEST_STALL_SECONDS", BACKUP_INGEST_DEFAULT_STALL_TIMEOUT)) * time.Second
+	coord.publishQueue = NewPublishQueue()
+	coord.recoveryGracePeriod = time.Duration(getEnvInt("COORDINATOR_RECOVERY_GRACE_SECONDS", COORDINATOR_RECOVERY_DEFAULT_GRACE_PERIOD)) * time.Second
+	coord.recoveringStreams = make(map[string]*ChannelStateSnapshot)
+
 	coord.nextStreamId = 0
 	coord.streamIdMutex = &sync.Mutex{}
 
 	coord.pendingStreamClosedEvents = make(map[string]*PendingStreamClosedEvent)
 
+	coord.maxDurationWarningTime = time.Duration(getEnvInt("MAX_DURATION_WARNING_SECONDS", STREAM_DURATION_DEFAULT_WARNING_TIME)) * time.Second
+
 	coord.pendingSaveActiveStreams = false
 	coord.pendingSaveActiveStreamsContent = ""
 
+	coord.channelsState = make(map[string]*ChannelStateSnapshot)
+
+	coord.liveEvents = CreateLiveEventsBus()
+
+	coord.keyValidationCache = CreateKeyValidationCache()
+
+	coord.eventSubscriptions = LoadEventSubscriptions()
+	coord.eventRetryConfig = GetEventRetryConfiguration()
+
+	coord.outbox = LoadEventOutbox(store.GetOutboxPath())
+
+	coord.RestoreChannelsState()
 	coord.LoadPastActiveStreams()
+	coord.ReplayPendingEvents()
 }
 
 // Acquires the access to a streaming channel data struct
@@ -152,6 +261,8 @@ func (coord *Streaming_Coordinator) AcquireChannel(channelId string) *StreamingC
 			publishMethod: 0,
 			publisher:     0,
 			encoder:       0,
+			sourceURL:     "",
+			record:        false,
 			nextEventId:   0,
 			pendingEvents: make(map[uint64]*PendingStreamAvailableEvent),
 			closed:        true,
@@ -185,6 +296,8 @@ func (coord *Streaming_Coordinator) ReleaseChannel(channel *StreamingChannel) {
 
 		delete(coord.channels, channel.id)
 
+		coord.releaseChannelLastEncoder(channel.id, time.Now().UnixMilli())
+
 		coord.mutex.Unlock()
 	}
 }
@@ -215,7 +328,8 @@ func (coord *Streaming_Coordinator) GenerateStreamID() string {
 // Registers encoder server
 // id - Server ID
 // capacity - Server capacity
-func (coord *Streaming_Coordinator) RegisterEncoder(id uint64, capacity int) {
+// tags - Server tags
+func (coord *Streaming_Coordinator) RegisterEncoder(id uint64, capacity int, tags []string) {
 	coord.mutex.Lock()
 	defer coord.mutex.Unlock()
 
@@ -223,7 +337,14 @@ func (coord *Streaming_Coordinator) RegisterEncoder(id uint64, capacity int) {
 		id:       id,
 		capacity: capacity,
 		load:     0,
+		tags:     tags,
 	}
+
+	coord.liveEvents.Publish(&LiveEvent{
+		EventType: LIVE_EVENT_ENCODER_REGISTER,
+		EncoderId: id,
+		Capacity:  capacity,
+	})
 }
 
 // Deregister encoder server
@@ -232,7 +353,22 @@ func (coord *Streaming_Coordinator) DeregisterEncoder(id uint64) {
 	coord.mutex.Lock()
 	defer coord.mutex.Unlock()
 
+	if coord.hlsEncoders[id] == nil {
+		return
+	}
+
 	delete(coord.hlsEncoders, id)
+
+	for channel, lastEncoder := range coord.channelLastEncoder {
+		if lastEncoder.encoder == id {
+			delete(coord.channelLastEncoder, channel)
+		}
+	}
+
+	coord.liveEvents.Publish(&LiveEvent{
+		EventType: LIVE_EVENT_ENCODER_DEREGISTER,
+		EncoderId: id,
+	})
 }
 
 // Removes streaming server
@@ -260,7 +396,17 @@ func (coord *Streaming_Coordinator) RegisterStreamingServer(sessionType int, id
 			port: port,
 			ssl:  ssl,
 		}
+	default:
+		return
 	}
+
+	coord.liveEvents.Publish(&LiveEvent{
+		EventType:  LIVE_EVENT_SERVER_REGISTER,
+		ServerId:   id,
+		ServerType: GetStreamingServerTypeName(sessionType),
+		IP:         ip,
+		Port:       port,
+	})
 }
 
 // Removes streaming server
@@ -272,9 +418,39 @@ func (coord *Streaming_Coordinator) DeregisterStreamingServer(sessionType int, i
 
 	switch sessionType {
 	case SESSION_TYPE_RTMP:
+		if coord.rtmpServers[id] == nil {
+			return
+		}
+
 		delete(coord.rtmpServers, id)
 	case SESSION_TYPE_WSS:
+		if coord.wssServers[id] == nil {
+			return
+		}
+
 		delete(coord.wssServers, id)
+	default:
+		return
+	}
+
+	coord.liveEvents.Publish(&LiveEvent{
+		EventType:  LIVE_EVENT_SERVER_DEREGISTER,
+		ServerId:   id,
+		ServerType: GetStreamingServerTypeName(sessionType),
+	})
+}
+
+// Gets the name of a streaming server type
+// sessionType - Type of server
+// Returns the name (RTMP or WS)
+func GetStreamingServerTypeName(sessionType int) string {
+	switch sessionType {
+	case SESSION_TYPE_RTMP:
+		return "RTMP"
+	case SESSION_TYPE_WSS:
+		return "WS"
+	default:
+		return ""
 	}
 }
 
@@ -296,7 +472,8 @@ func (coord *Streaming_Coordinator) AddActiveStream(channel string, streamId str
 // Call when an active stream is closed (HLS encoder process ends)
 // channel - The channel
 // streamId - Stream ID
-func (coord *Streaming_Coordinator) OnActiveStreamClosed(channel string, streamId string) {
+// closeReason - Reason why the stream was closed
+func (coord *Streaming_Coordinator) OnActiveStreamClosed(channel string, streamId string, closeReason StreamCloseReason) {
 	id := channel + ":" + streamId
 
 	coord.mutex.Lock()
@@ -304,15 +481,161 @@ func (coord *Streaming_Coordinator) OnActiveStreamClosed(channel string, streamI
 
 	if coord.activeStreams[id] && coord.pendingStreamClosedEvents[streamId] == nil {
 		event := &PendingStreamClosedEvent{
-			channel:   channel,
-			streamId:  streamId,
-			cancelled: false,
+			eventId:     GenerateEventId(),
+			timestamp:   time.Now().UnixMilli(),
+			channel:     channel,
+			streamId:    streamId,
+			closeReason: closeReason,
+			cancelled:   false,
 		}
 
 		coord.pendingStreamClosedEvents[streamId] = event
 
-		go SendStreamClosedEvent(coord, event)
+		deliveries := coord.EnqueueEvent(event.CallbackEvent())
+
+		go SendStreamClosedEvent(coord, event, deliveries)
+
+		coord.liveEvents.Publish(&LiveEvent{
+			EventType: LIVE_EVENT_STREAM_CLOSED,
+			Channel:   channel,
+			StreamId:  streamId,
+			Reason:    closeReason.code,
+			Message:   closeReason.message,
+		})
+	}
+}
+
+// Call when a publish request is accepted
+// channel - The channel
+// streamId - Stream ID
+// ip - User IP
+// publishMethod - Publish method (RTMP or WS)
+func (coord *Streaming_Coordinator) OnPublishStarted(channel string, streamId string, ip string, publishMethod string) {
+	event := &CallbackEvent{
+		Id:            GenerateEventId(),
+		Timestamp:     time.Now().UnixMilli(),
+		EventType:     "publish-started",
+		Channel:       channel,
+		StreamId:      streamId,
+		UserIP:        ip,
+		PublishMethod: publishMethod,
+	}
+
+	deliveries := coord.EnqueueEvent(event)
+
+	go coord.SendEventDeliveries(deliveries, func() bool {
+		return false
+	})
+}
+
+// Call when a publish request is denied
+// channel - The channel
+// ip - User IP
+// publishMethod - Publish method (RTMP or WS)
+// reason - The reason to deny the request
+func (coord *Streaming_Coordinator) OnPublishDenied(channel string, ip string, publishMethod string, reason string) {
+	event := &CallbackEvent{
+		Id:            GenerateEventId(),
+		Timestamp:     time.Now().UnixMilli(),
+		EventType:     "publish-denied",
+		Channel:       channel,
+		UserIP:        ip,
+		PublishMethod: publishMethod,
+		DenyReason:    reason,
 	}
+
+	deliveries := coord.EnqueueEvent(event)
+
+	go coord.SendEventDeliveries(deliveries, func() bool {
+		return false
+	})
+}
+
+// Call when a stream fails, because of an encoding error, or because its encoder was lost
+// Unlike stream-closed, it is sent even if the stream never became available
+// channel - The channel
+// streamId - Stream ID
+// closeReason - Reason of the failure
+func (coord *Streaming_Coordinator) OnStreamFailed(channel string, streamId string, closeReason StreamCloseReason) {
+	event := &CallbackEvent{
+		Id:           GenerateEventId(),
+		Timestamp:    time.Now().UnixMilli(),
+		EventType:    "stream-failed",
+		Channel:      channel,
+		StreamId:     streamId,
+		CloseReason:  closeReason.code,
+		CloseMessage: closeReason.message,
+	}
+
+	deliveries := coord.EnqueueEvent(event)
+
+	go coord.SendEventDeliveries(deliveries, func() bool {
+		return false
+	})
+
+	coord.liveEvents.Publish(&LiveEvent{
+		EventType: LIVE_EVENT_STREAM_FAILED,
+		Channel:   channel,
+		StreamId:  streamId,
+		Reason:    closeReason.code,
+		Message:   closeReason.message,
+	})
+}
+
+// Call when the encoder reports the information of the source stream
+// channel - The channel
+// streamId - Stream ID
+// info - Information of the source stream
+func (coord *Streaming_Coordinator) OnStreamInfo(channel string, streamId string, info *StreamSourceInfo) {
+	event := &CallbackEvent{
+		Id:         GenerateEventId(),
+		Timestamp:  time.Now().UnixMilli(),
+		EventType:  "stream-info",
+		Channel:    channel,
+		StreamId:   streamId,
+		SourceInfo: info,
+	}
+
+	deliveries := coord.EnqueueEvent(event)
+
+	go coord.SendEventDeliveries(deliveries, func() bool {
+		return false
+	})
+
+	coord.liveEvents.Publish(&LiveEvent{
+		EventType:  LIVE_EVENT_STREAM_INFO,
+		Channel:    channel,
+		StreamId:   streamId,
+		SourceInfo: info,
+	})
+}
+
+// Call when an active stream is interrupted, because its encoder disconnected
+// The stream was moved to another encoder, so it will continue
+// channel - The channel
+// streamId - Stream ID
+// encoderId - ID of the new encoder
+func (coord *Streaming_Coordinator) OnActiveStreamInterrupted(channel string, streamId string, encoderId uint64) {
+	event := &CallbackEvent{
+		Id:        GenerateEventId(),
+		Timestamp: time.Now().UnixMilli(),
+		EventType: "stream-interrupted",
+		Channel:   channel,
+		StreamId:  streamId,
+	}
+
+	deliveries := coord.EnqueueEvent(event)
+
+	go coord.SendEventDeliveries(deliveries, func() bool {
+		return false
+	})
+
+	coord.liveEvents.Publish(&LiveEvent{
+		EventType: LIVE_EVENT_STREAM_INTERRUPTED,
+		Channel:   channel,
+		StreamId:  streamId,
+		EncoderId: encoderId,
+	})
 }
 
 // Removes an active stream from the list
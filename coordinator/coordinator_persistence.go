@@ -3,22 +3,25 @@
 package main
 
 import (
-	"io/ioutil"
-	"os"
+	"encoding/json"
+	"fmt"
 	"strings"
+	"sync"
+	"time"
 )
 
-const ACTIVE_STREAMS_TMP_FILE = "active_streams.tmp"
 const ACTIVE_STREAMS_FILE = "active_streams.txt"
 
-// Loads the list of active streams from a file
+// Loads the list of active streams from the state store
+// The streams being recovered are kept, the rest are closed
 func (coord *Streaming_Coordinator) LoadPastActiveStreams() {
 	coord.mutex.Lock()
 	defer coord.mutex.Unlock()
 
-	content, err := ioutil.ReadFile(ACTIVE_STREAMS_FILE)
+	content, err := coord.store.Load(ACTIVE_STREAMS_FILE)
 
 	if err != nil {
+		LogError(err)
 		return
 	}
 
@@ -36,19 +39,114 @@ func (coord *Streaming_Coordinator) LoadPastActiveStreams() {
 
 		coord.activeStreams[channel+":"+streamId] = true
 
+		if coord.recoveringStreams[channel+":"+streamId] != nil {
+			continue // Being recovered, it will be closed if it does not reappear
+		}
+
+		if coord.outbox.HasPending("stream-closed", channel, streamId) {
+			continue // Already pending, it will be replayed
+		}
+
+		// The stream was active before the restart, add a stream-closed event to the outbox
+
 		event := &PendingStreamClosedEvent{
-			channel:   channel,
-			streamId:  streamId,
-			cancelled: false,
+			eventId:     GenerateEventId(),
+			timestamp:   time.Now().UnixMilli(),
+			channel:     channel,
+			streamId:    streamId,
+			closeReason: MakeStreamCloseReason(CLOSE_REASON_COORDINATOR_RESTART, "The stream was active when the coordinator stopped"),
+			cancelled:   false,
+		}
+
+		deliveries := coord.EnqueueEvent(event.CallbackEvent())
+
+		if len(deliveries) == 0 {
+			// No subscription wants the event
+			delete(coord.activeStreams, channel+":"+streamId)
+		}
+	}
+}
+
+// Replays the event deliveries pending of being completed (loaded from the outbox)
+// For each subscription, the events are sent in order, each one after the previous one was delivered
+func (coord *Streaming_Coordinator) ReplayPendingEvents() {
+	deliveries := coord.outbox.GetPending()
+
+	if len(deliveries) == 0 {
+		return
+	}
+
+	LogInfo("Replaying " + fmt.Sprint(len(deliveries)) + " pending event deliveries from " + EVENTS_OUTBOX_FILE)
+
+	closedEvents := make(map[string]*PendingStreamClosedEvent)
+	closedEventsWaitGroups := make(map[string]*sync.WaitGroup)
+
+	subscriptionsOrder := make([]string, 0)
+	deliveriesBySubscription := make(map[string][]*EventDelivery)
+
+	coord.mutex.Lock()
+
+	for i := 0; i < len(deliveries); i++ {
+		delivery := deliveries[i]
+
+		if deliveriesBySubscription[delivery.Subscription] == nil {
+			subscriptionsOrder = append(subscriptionsOrder, delivery.Subscription)
+		}
+
+		deliveriesBySubscription[delivery.Subscription] = append(deliveriesBySubscription[delivery.Subscription], delivery)
+
+		if delivery.Event.EventType != "stream-closed" {
+			continue
 		}
 
-		coord.pendingStreamClosedEvents[streamId] = event
+		if closedEvents[delivery.Event.Id] == nil {
+			event := &PendingStreamClosedEvent{
+				eventId:   delivery.Event.Id,
+				timestamp: delivery.Event.Timestamp,
+				channel:   delivery.Event.Channel,
+				streamId:  delivery.Event.StreamId,
+				cancelled: false,
+			}
+
+			coord.pendingStreamClosedEvents[event.streamId] = event
+			closedEvents[event.eventId] = event
+			closedEventsWaitGroups[event.eventId] = &sync.WaitGroup{}
+		}
+
+		closedEventsWaitGroups[delivery.Event.Id].Add(1)
+	}
+
+	coord.mutex.Unlock()
+
+	// Once all the deliveries of a stream-closed event are completed, remove the active stream
 
-		go SendStreamClosedEvent(coord, event)
+	for eventId, event := range closedEvents {
+		go func(event *PendingStreamClosedEvent, wg *sync.WaitGroup) {
+			wg.Wait()
+			coord.RemoveActiveStream(event.channel, event.streamId)
+		}(event, closedEventsWaitGroups[eventId])
+	}
+
+	for i := 0; i < len(subscriptionsOrder); i++ {
+		go func(deliveries []*EventDelivery) {
+			for j := 0; j < len(deliveries); j++ {
+				closedEvent := closedEvents[deliveries[j].Event.Id]
+
+				if closedEvent != nil {
+					coord.SendEventDelivery(deliveries[j], func() bool {
+						return closedEvent.cancelled
+					})
+
+					closedEventsWaitGroups[closedEvent.eventId].Done()
+				} else {
+					coord.SendOutboxDelivery(deliveries[j])
+				}
+			}
+		}(deliveriesBySubscription[subscriptionsOrder[i]])
 	}
 }
 
-// Saves the current list of active streams to a file
+// Saves the current list of active streams to the state store
 func (coord *Streaming_Coordinator) SavePastActiveStreams() {
 	str := ""
 
@@ -65,23 +163,17 @@ func (coord *Streaming_Coordinator) SavePastActiveStreams() {
 	}
 }
 
-// Internal method to save the active streams to the file
+// Internal method to save the active streams to the state store
 // content - Content to save
 func (coord *Streaming_Coordinator) SavePastActiveStreamsInternal(content []byte) {
 	done := false
 	toSave := content
 
 	for !done {
-		err := ioutil.WriteFile(ACTIVE_STREAMS_TMP_FILE, toSave, FILE_PERMISSION)
+		err := coord.store.Save(ACTIVE_STREAMS_FILE, toSave)
 
 		if err != nil {
 			LogError(err)
-		} else {
-			err = os.Rename(ACTIVE_STREAMS_TMP_FILE, ACTIVE_STREAMS_FILE)
-
-			if err != nil {
-				LogError(err)
-			}
 		}
 
 		coord.mutex.Lock()
@@ -98,3 +190,137 @@ func (coord *Streaming_Coordinator) SavePastActiveStreamsInternal(content []byte
 		coord.mutex.Unlock()
 	}
 }
+
+const CHANNELS_STATE_FILE = "channels_state.json"
+
+// Snapshot of the state of an open channel, persisted to recover the streams after a restart
+type ChannelStateSnapshot struct {
+	Channel  string `json:"channel"`   // Channel ID
+	StreamId string `json:"stream_id"` // Stream ID
+
+	PublishMethod int    `json:"publish_method"` // Publish method (PUBLISH_METHOD_RTMP or PUBLISH_METHOD_WS)
+	Publisher     uint64 `json:"publisher"`      // ID of the streaming server session (before the restart)
+	Encoder       uint64 `json:"encoder"`        // ID of the HLS encoder session (before the restart)
+
+	SourceURL       string `json:"source_url"`        // URL for the encoder to fetch the stream
+	Resolutions     string `json:"resolutions"`       // Encoded list of resolutions
+	Record          bool   `json:"record"`            // True if recording is enabled
+	Previews        string `json:"previews"`          // Encoded previews configuration
+	EncodeStartTime int64  `json:"encode_start_time"` // Unix timestamp (milliseconds) when the encoding started
+
+	Config StreamConfiguration `json:"config"` // Extra configuration of the stream
+
+	SourceInfo *StreamSourceInfo `json:"source_info,omitempty"` // Information of the source stream
+}
+
+// Updates the snapshot of a channel and saves the channels state
+// Must be called with the channel acquired
+// channelData - The channel
+func (coord *Streaming_Coordinator) UpdateChannelState(channelData *StreamingChannel) {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	if channelData.closed {
+		if coord.channelsState[channelData.id] == nil {
+			return
+		}
+
+		delete(coord.channelsState, channelData.id)
+	} else {
+		coord.channelsState[channelData.id] = &ChannelStateSnapshot{
+			Channel:         channelData.id,
+			StreamId:        channelData.streamId,
+			PublishMethod:   channelData.publishMethod,
+			Publisher:       channelData.publisher,
+			Encoder:         channelData.encoder,
+			SourceURL:       channelData.sourceURL,
+			Resolutions:     channelData.resolutions.Encode(),
+			Record:          channelData.record,
+			Previews:        channelData.previews.Encode(),
+			EncodeStartTime: channelData.encodeStartTime,
+			Config:          channelData.config,
+			SourceInfo:      channelData.sourceInfo,
+		}
+	}
+
+	coord.SaveChannelsState()
+}
+
+// Loads the channels state saved before the restart
+// Returns the list of channel snapshots
+func (coord *Streaming_Coordinator) LoadChannelsState() []*ChannelStateSnapshot {
+	result := make([]*ChannelStateSnapshot, 0)
+
+	content, err := coord.store.Load(CHANNELS_STATE_FILE)
+
+	if err != nil {
+		LogError(err)
+		return result
+	}
+
+	if content == nil {
+		return result
+	}
+
+	err = json.Unmarshal(content, &result)
+
+	if err != nil {
+		LogWarning("Ignored invalid " + CHANNELS_STATE_FILE + ": " + err.Error())
+		return make([]*ChannelStateSnapshot, 0)
+	}
+
+	return result
+}
+
+// Saves the current channels state to the state store
+// Must be called with the coordinator mutex locked
+func (coord *Streaming_Coordinator) SaveChannelsState() {
+	list := make([]*ChannelStateSnapshot, 0)
+
+	for _, snapshot := range coord.channelsState {
+		list = append(list, snapshot)
+	}
+
+	content, err := json.Marshal(list)
+
+	if err != nil {
+		LogError(err)
+		return
+	}
+
+	if coord.savingChannelsState {
+		coord.pendingSaveChannelsState = true
+		coord.pendingSaveChannelsStateContent = content
+	} else {
+		coord.savingChannelsState = true
+		go coord.SaveChannelsStateInternal(content)
+	}
+}
+
+// Internal method to save the channels state to the state store
+// content - Content to save
+func (coord *Streaming_Coordinator) SaveChannelsStateInternal(content []byte) {
+	done := false
+	toSave := content
+
+	for !done {
+		err := coord.store.Save(CHANNELS_STATE_FILE, toSave)
+
+		if err != nil {
+			LogError(err)
+		}
+
+		coord.mutex.Lock()
+
+		if coord.pendingSaveChannelsState {
+			toSave = coord.pendingSaveChannelsStateContent
+			coord.pendingSaveChannelsState = false
+			coord.pendingSaveChannelsStateContent = nil
+		} else {
+			coord.savingChannelsState = false
+			done = true
+		}
+
+		coord.mutex.Unlock()
+	}
+}
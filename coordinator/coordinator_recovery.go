@@ -0,0 +1,199 @@
+// Recovery of the streams after a coordinator restart
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const COORDINATOR_RECOVERY_DEFAULT_GRACE_PERIOD = 30 // Default time to wait for the servers to reconnect after a restart (seconds)
+
+// Publisher reported by a streaming server after connecting
+type ActivePublisherInfo struct {
+	channel  string // Channel ID
+	streamId string // Stream ID
+}
+
+// Parses the list of publishers sent by the streaming server in the ACTIVE-PUBLISHERS message
+// str - The list. Format: {CHANNEL}:{STREAM_ID}, split by commas
+// Returns the parsed list. Invalid entries are ignored
+func ParseActivePublishersList(str string) []ActivePublisherInfo {
+	result := make([]ActivePublisherInfo, 0)
+
+	if str == "" {
+		return result
+	}
+
+	entries := strings.Split(str, ",")
+
+	for i := 0; i < len(entries); i++ {
+		parts := strings.Split(strings.Trim(entries[i], " "), ":")
+
+		if len(parts) != 2 {
+			continue
+		}
+
+		result = append(result, ActivePublisherInfo{
+			channel:  parts[0],
+			streamId: parts[1],
+		})
+	}
+
+	return result
+}
+
+// Restores the channels that were open before the restart
+// They wait for their publisher and encoder to reconnect (COORDINATOR_RECOVERY_GRACE_SECONDS)
+func (coord *Streaming_Coordinator) RestoreChannelsState() {
+	snapshots := coord.LoadChannelsState()
+
+	for i := 0; i < len(snapshots); i++ {
+		snapshot := snapshots[i]
+
+		if !validateStreamIDString(snapshot.Channel) || snapshot.StreamId == "" {
+			continue
+		}
+
+		if coord.outbox.HasPending("stream-closed", snapshot.Channel, snapshot.StreamId) {
+			continue // Already closed
+		}
+
+		channelData := coord.AcquireChannel(snapshot.Channel)
+
+		if !channelData.closed {
+			coord.ReleaseChannel(channelData)
+			continue // Duplicated
+		}
+
+		channelData.closed = false
+		channelData.closeReason = StreamCloseReason{}
+		channelData.streamId = snapshot.StreamId
+		channelData.publishMethod = snapshot.PublishMethod
+		channelData.publisher = 0 // Until the streaming server reconnects
+		channelData.encoder = 0   // Until the encoder reconnects
+		channelData.sourceURL = snapshot.SourceURL
+		channelData.resolutions = DecodeResolutionsList(snapshot.Resolutions)
+		channelData.record = snapshot.Record
+		channelData.previews = DecodePreviewsConfiguration(snapshot.Previews, ",")
+		channelData.config = snapshot.Config
+		channelData.sourceInfo = snapshot.SourceInfo
+		channelData.encodeStartTime = snapshot.EncodeStartTime
+
+		coord.ReleaseChannel(channelData)
+
+		// The encoder can adopt the stream, the same way as if it reconnected
+		coord.AddLostEncoderStream(snapshot.Channel, snapshot.StreamId, snapshot.Encoder)
+
+		coord.mutex.Lock()
+		coord.channelsState[snapshot.Channel] = snapshot
+		coord.recoveringStreams[snapshot.Channel+":"+snapshot.StreamId] = snapshot
+		coord.mutex.Unlock()
+	}
+
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	if len(snapshots) > 0 {
+		coord.SaveChannelsState()
+	}
+}
+
+// Waits for the streaming servers and encoders to reconnect after a restart
+// Then, closes the streams that did not reappear
+func (server *Streaming_Coordinator_Server) RunRestartRecovery() {
+	server.coordinator.mutex.Lock()
+
+	recoveringCount := len(server.coordinator.recoveringStreams)
+
+	for _, snapshot := range server.coordinator.recoveringStreams {
+		elapsed := time.Duration(time.Now().UnixMilli()-snapshot.EncodeStartTime) * time.Millisecond
+		server.StartStreamDurationLimit(snapshot.Channel, snapshot.StreamId, snapshot.Config.MaxDuration, elapsed)
+	}
+
+	server.coordinator.mutex.Unlock()
+
+	if recoveringCount == 0 {
+		return
+	}
+
+	LogInfo("[RECOVERY] Waiting for the publishers and encoders of " + fmt.Sprint(recoveringCount) + " streams to reconnect")
+
+	if server.coordinator.recoveryGracePeriod > 0 {
+		time.Sleep(server.coordinator.recoveryGracePeriod)
+	}
+
+	server.coordinator.mutex.Lock()
+
+	recoveringStreams := server.coordinator.recoveringStreams
+	server.coordinator.recoveringStreams = make(map[string]*ChannelStateSnapshot)
+
+	server.coordinator.mutex.Unlock()
+
+	for _, snapshot := range recoveringStreams {
+		server.finishStreamRecovery(snapshot)
+	}
+}
+
+// Checks if the publisher and the encoder of a stream reappeared after a restart
+// If the publisher did not reappear, the stream is closed
+// If the encoder did not reappear, the stream is moved to another encoder (if failover is enabled) or closed
+// snapshot - State of the channel before the restart
+func (server *Streaming_Coordinator_Server) finishStreamRecovery(snapshot *ChannelStateSnapshot) {
+	channel := snapshot.Channel
+	streamId := snapshot.StreamId
+
+	encoderLost := server.coordinator.TakeLostEncoderStream(channel, streamId)
+
+	channelData := server.coordinator.AcquireChannel(channel)
+	defer server.coordinator.ReleaseChannel(channelData)
+
+	if channelData.closed || channelData.streamId != streamId {
+		// The publisher ended during the recovery
+		if encoderLost {
+			server.coordinator.OnActiveStreamClosed(channel, streamId, channelData.GetCloseReason(streamId))
+		}
+		return
+	}
+
+	if channelData.publisher == 0 {
+		LogInfo("[RECOVERY] The publisher of " + channel + "/" + streamId + " did not reappear. Closing the stream.")
+
+		channelData.closed = true
+		channelData.SetCloseReason(MakeStreamCloseReason(CLOSE_REASON_PUBLISHER_LOST, "The publisher did not reconnect after the coordinator restarted"))
+
+		if encoderLost {
+			server.coordinator.OnActiveStreamClosed(channel, streamId, channelData.GetCloseReason(streamId))
+		} else {
+			encoderSession := server.GetSession(channelData.encoder)
+
+			if encoderSession != nil {
+				encoderSession.SendEncodeStop(channel, streamId)
+				encoderSession.DisassociateChannel(channel)
+			}
+		}
+
+		// Cancel any stream-available events
+		for _, event := range channelData.pendingEvents {
+			event.cancelled = true
+		}
+
+		server.coordinator.UpdateChannelState(channelData)
+
+		return
+	}
+
+	if !encoderLost {
+		LogInfo("[RECOVERY] Recovered " + channel + "/" + streamId + " | PUBLISHER: #" + fmt.Sprint(channelData.publisher) + " | ENCODER: #" + fmt.Sprint(channelData.encoder))
+		return
+	}
+
+	LogInfo("[RECOVERY] The encoder of " + channel + "/" + streamId + " did not reappear.")
+
+	if server.coordinator.encoderFailover && server.failoverStream(channelData, snapshot.Encoder) {
+		return
+	}
+
+	server.closeStreamWithLostEncoder(channelData)
+}
@@ -0,0 +1,328 @@
+// Encoder assignment strategies
+
+package main
+
+import (
+	mathRand "math/rand/v2"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Encoder assignment strategies
+const (
+	ENCODER_ASSIGNMENT_LEAST_LOAD          = "least-load"          // Lowest load (number of streams)
+	ENCODER_ASSIGNMENT_LEAST_RELATIVE_LOAD = "least-relative-load" // Lowest load, relative to the capacity
+	ENCODER_ASSIGNMENT_WEIGHTED_RANDOM     = "weighted-random"     // Random, weighted by the free capacity
+	ENCODER_ASSIGNMENT_STICKY              = "sticky"              // The encoder that last served the channel, if available
+	ENCODER_ASSIGNMENT_BIN_PACKING         = "bin-packing"         // Highest relative load that still has room, so idle encoders can be scaled down
+)
+
+// Function to choose an encoder
+// candidates - List of available encoders (not full), sorted by ID
+// channel - The channel to assign
+// lastEncoder - ID of the encoder that last served the channel (0 if none)
+// Returns the chosen encoder
+type EncoderAssignmentStrategy func(candidates []*HLS_Encoder_Server, channel string, lastEncoder uint64) *HLS_Encoder_Server
+
+// Available encoder assignment strategies. Map: name -> strategy
+var ENCODER_ASSIGNMENT_STRATEGIES = map[string]EncoderAssignmentStrategy{
+	ENCODER_ASSIGNMENT_LEAST_LOAD:          AssignEncoderLeastLoad,
+	ENCODER_ASSIGNMENT_LEAST_RELATIVE_LOAD: AssignEncoderLeastRelativeLoad,
+	ENCODER_ASSIGNMENT_WEIGHTED_RANDOM:     AssignEncoderWeightedRandom,
+	ENCODER_ASSIGNMENT_STICKY:              AssignEncoderSticky,
+	ENCODER_ASSIGNMENT_BIN_PACKING:         AssignEncoderBinPacking,
+}
+
+// Default time (seconds) to remember the encoder that last served a closed channel
+const ENCODER_STICKY_DEFAULT_TTL = 3600
+
+// Encoder that last served a channel, for the sticky strategy
+type ChannelLastEncoder struct {
+	encoder    uint64 // ID of the encoder
+	releasedAt int64  // Unix milliseconds when the channel was closed. 0 = The channel is open
+}
+
+// Random number generator for the weighted-random strategy. Returns a number in [0, n)
+var encoderAssignmentRandomIntN = mathRand.IntN
+
+// Loads the encoder assignment strategy from the ENCODER_ASSIGNMENT_STRATEGY environment variable
+// Returns the name of the strategy
+func GetEncoderAssignmentStrategyName() string {
+	name := strings.ToLower(os.Getenv("ENCODER_ASSIGNMENT_STRATEGY"))
+
+	if name == "" {
+		return ENCODER_ASSIGNMENT_LEAST_LOAD
+	}
+
+	if ENCODER_ASSIGNMENT_STRATEGIES[name] == nil {
+		LogWarning("Unknown ENCODER_ASSIGNMENT_STRATEGY: " + name + ". Using " + ENCODER_ASSIGNMENT_LEAST_LOAD)
+		return ENCODER_ASSIGNMENT_LEAST_LOAD
+	}
+
+	return name
+}
+
+// Checks if an encoder has room for another stream
+// Returns true if the encoder is not full
+func (encoder *HLS_Encoder_Server) HasRoom() bool {
+	return encoder.capacity < 0 || encoder.load < encoder.capacity
+}
+
+// Computes the load of the encoder, relative to its capacity
+// Returns a number between 0 (idle or infinite capacity) and 1 (full)
+func (encoder *HLS_Encoder_Server) RelativeLoad() float64 {
+	if encoder.capacity < 0 {
+		return 0
+	}
+
+	if encoder.capacity == 0 {
+		return 1
+	}
+
+	return float64(encoder.load) / float64(encoder.capacity)
+}
+
+// Chooses the encoder with the lowest load
+func AssignEncoderLeastLoad(candidates []*HLS_Encoder_Server, channel string, lastEncoder uint64) *HLS_Encoder_Server {
+	var selected *HLS_Encoder_Server = nil
+
+	for i := 0; i < len(candidates); i++ {
+		if selected == nil || candidates[i].load < selected.load {
+			selected = candidates[i]
+		}
+	}
+
+	return selected
+}
+
+// Chooses the encoder with the lowest load, relative to its capacity
+func AssignEncoderLeastRelativeLoad(candidates []*HLS_Encoder_Server, channel string, lastEncoder uint64) *HLS_Encoder_Server {
+	var selected *HLS_Encoder_Server = nil
+
+	for i := 0; i < len(candidates); i++ {
+		if selected == nil || candidates[i].RelativeLoad() < selected.RelativeLoad() {
+			selected = candidates[i]
+		}
+	}
+
+	return selected
+}
+
+// Chooses a random encoder, with probability proportional to its free capacity
+// Encoders with infinite capacity weight as much as the encoder with the most free capacity
+func AssignEncoderWeightedRandom(candidates []*HLS_Encoder_Server, channel string, lastEncoder uint64) *HLS_Encoder_Server {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	maxFree := 1
+
+	for i := 0; i < len(candidates); i++ {
+		if candidates[i].capacity >= 0 && candidates[i].capacity-candidates[i].load > maxFree {
+			maxFree = candidates[i].capacity - candidates[i].load
+		}
+	}
+
+	weights := make([]int, len(candidates))
+	totalWeight := 0
+
+	for i := 0; i < len(candidates); i++ {
+		if candidates[i].capacity < 0 {
+			weights[i] = maxFree
+		} else {
+			weights[i] = candidates[i].capacity - candidates[i].load
+		}
+
+		totalWeight += weights[i]
+	}
+
+	if totalWeight == 0 {
+		return nil // No free capacity
+	}
+
+	r := encoderAssignmentRandomIntN(totalWeight)
+
+	for i := 0; i < len(candidates); i++ {
+		if r < weights[i] {
+			return candidates[i]
+		}
+
+		r -= weights[i]
+	}
+
+	return candidates[len(candidates)-1]
+}
+
+// Chooses the encoder that last served the channel, if available
+// Otherwise, chooses the encoder with the lowest relative load
+func AssignEncoderSticky(candidates []*HLS_Encoder_Server, channel string, lastEncoder uint64) *HLS_Encoder_Server {
+	if lastEncoder != 0 {
+		for i := 0; i < len(candidates); i++ {
+			if candidates[i].id == lastEncoder {
+				return candidates[i]
+			}
+		}
+	}
+
+	return AssignEncoderLeastRelativeLoad(candidates, channel, lastEncoder)
+}
+
+// Chooses the encoder with the highest relative load that still has room
+// This fills the encoders one by one, so the idle ones can be scaled down
+// Encoders with infinite capacity are used last
+func AssignEncoderBinPacking(candidates []*HLS_Encoder_Server, channel string, lastEncoder uint64) *HLS_Encoder_Server {
+	var selected *HLS_Encoder_Server = nil
+
+	for i := 0; i < len(candidates); i++ {
+		if selected == nil {
+			selected = candidates[i]
+		} else if selected.capacity < 0 && candidates[i].capacity >= 0 {
+			selected = candidates[i]
+		} else if candidates[i].capacity >= 0 && candidates[i].RelativeLoad() > selected.RelativeLoad() {
+			selected = candidates[i]
+		}
+	}
+
+	return selected
+}
+
+// Parses the list of tags sent by the encoder in the REGISTER message
+// str - The list, split by commas
+// Returns the list of tags (lower case)
+func ParseEncoderTagsList(str string) []string {
+	result := make([]string, 0)
+
+	if str == "" {
+		return result
+	}
+
+	parts := strings.Split(str, ",")
+
+	for i := 0; i < len(parts); i++ {
+		tag := strings.ToLower(strings.Trim(parts[i], " "))
+
+		if tag != "" {
+			result = append(result, tag)
+		}
+	}
+
+	return result
+}
+
+// Checks if an encoder has all the required tags
+// requiredTags - The required tags
+// Returns true if the encoder has every tag
+func (encoder *HLS_Encoder_Server) HasTags(requiredTags []string) bool {
+	for i := 0; i < len(requiredTags); i++ {
+		found := false
+
+		for j := 0; j < len(encoder.tags); j++ {
+			if encoder.tags[j] == requiredTags[i] {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Gets the list of encoders with room for another stream
+// Encoders overloaded according to their stats are excluded,
+// unless every encoder with room is overloaded
+// Must be called with the coordinator mutex locked
+// requiredTags - Tags the encoders must have
+// Returns the list, sorted by ID
+func (coord *Streaming_Coordinator) getAvailableEncoders(requiredTags []string) []*HLS_Encoder_Server {
+	candidates := make([]*HLS_Encoder_Server, 0)
+	overloaded := make([]*HLS_Encoder_Server, 0)
+
+	now := time.Now().UnixMilli()
+
+	for _, encoder := range coord.hlsEncoders {
+		if encoder.draining || !encoder.HasRoom() || !encoder.HasTags(requiredTags) {
+			continue
+		}
+
+		if encoder.PassesAssignmentFilter(&coord.encoderAssignmentFilter, now) {
+			candidates = append(candidates, encoder)
+		} else {
+			overloaded = append(overloaded, encoder)
+		}
+	}
+
+	if len(candidates) == 0 && len(overloaded) > 0 {
+		LogWarning("All the available HLS encoders are overloaded")
+		candidates = overloaded
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].id < candidates[j].id
+	})
+
+	return candidates
+}
+
+// Gets the encoder that last served a channel
+// Must be called with the coordinator mutex locked
+// channel - The channel
+// now - Current Unix milliseconds
+// Returns the encoder ID, or 0 if none or the entry expired
+func (coord *Streaming_Coordinator) getChannelLastEncoder(channel string, now int64) uint64 {
+	entry := coord.channelLastEncoder[channel]
+
+	if entry == nil || (entry.releasedAt > 0 && now-entry.releasedAt > coord.encoderStickyTTL.Milliseconds()) {
+		return 0
+	}
+
+	return entry.encoder
+}
+
+// Sets the encoder serving a channel
+// Must be called with the coordinator mutex locked
+// channel - The channel
+// encoderId - ID of the encoder
+func (coord *Streaming_Coordinator) setChannelLastEncoder(channel string, encoderId uint64) {
+	coord.channelLastEncoder[channel] = &ChannelLastEncoder{
+		encoder:    encoderId,
+		releasedAt: 0,
+	}
+}
+
+// Marks the last encoder of a channel as released, after the channel was closed
+// The entry is kept for ENCODER_STICKY_TTL_SECONDS, so the channel can return to the same encoder
+// Expired entries are pruned, at most once per TTL
+// Must be called with the coordinator mutex locked
+// channel - The channel
+// now - Current Unix milliseconds
+func (coord *Streaming_Coordinator) releaseChannelLastEncoder(channel string, now int64) {
+	entry := coord.channelLastEncoder[channel]
+
+	if entry != nil {
+		if coord.encoderStickyTTL <= 0 {
+			delete(coord.channelLastEncoder, channel)
+		} else {
+			entry.releasedAt = now
+		}
+	}
+
+	ttl := coord.encoderStickyTTL.Milliseconds()
+
+	if now-coord.channelLastEncoderPruneTime < ttl {
+		return
+	}
+
+	coord.channelLastEncoderPruneTime = now
+
+	for c, e := range coord.channelLastEncoder {
+		if e.releasedAt > 0 && now-e.releasedAt > ttl {
+			delete(coord.channelLastEncoder, c)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+// Tests for the encoder assignment strategies
+
+package main
+
+import (
+	mathRand "math/rand/v2"
+	"testing"
+	"time"
+)
+
+// Creates an encoder for the tests
+func makeTestEncoder(id uint64, capacity int, load int) *HLS_Encoder_Server {
+	return &HLS_Encoder_Server{
+		id:       id,
+		capacity: capacity,
+		load:     load,
+		tags:     make([]string, 0),
+	}
+}
+
+// Gets the ID of the selected encoder (0 if none)
+func selectedEncoderId(encoder *HLS_Encoder_Server) uint64 {
+	if encoder == nil {
+		return 0
+	}
+
+	return encoder.id
+}
+
+func TestAssignEncoderLeastLoad(t *testing.T) {
+	cases := []struct {
+		name       string
+		candidates []*HLS_Encoder_Server
+		expected   uint64
+	}{
+		{"empty", []*HLS_Encoder_Server{}, 0},
+		{"single", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 3)}, 1},
+		{"lowest load", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 3), makeTestEncoder(2, 10, 1), makeTestEncoder(3, 2, 1)}, 2},
+		{"unlimited capacity", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 2), makeTestEncoder(2, -1, 0)}, 2},
+		{"tie keeps the lowest ID", []*HLS_Encoder_Server{makeTestEncoder(1, -1, 1), makeTestEncoder(2, 4, 1)}, 1},
+	}
+
+	for _, c := range cases {
+		result := selectedEncoderId(AssignEncoderLeastLoad(c.candidates, "channel", 0))
+
+		if result != c.expected {
+			t.Errorf("%s: expected encoder #%d, got #%d", c.name, c.expected, result)
+		}
+	}
+}
+
+func TestAssignEncoderLeastRelativeLoad(t *testing.T) {
+	cases := []struct {
+		name       string
+		candidates []*HLS_Encoder_Server
+		expected   uint64
+	}{
+		{"empty", []*HLS_Encoder_Server{}, 0},
+		{"lowest relative load", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 1), makeTestEncoder(2, 10, 2), makeTestEncoder(3, 2, 1)}, 2},
+		{"more streams, but more capacity", []*HLS_Encoder_Server{makeTestEncoder(1, 2, 1), makeTestEncoder(2, 20, 4)}, 2},
+		{"unlimited capacity", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 1), makeTestEncoder(2, -1, 7)}, 2},
+		{"tie keeps the lowest ID", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 2), makeTestEncoder(2, 8, 4)}, 1},
+	}
+
+	for _, c := range cases {
+		result := selectedEncoderId(AssignEncoderLeastRelativeLoad(c.candidates, "channel", 0))
+
+		if result != c.expected {
+			t.Errorf("%s: expected encoder #%d, got #%d", c.name, c.expected, result)
+		}
+	}
+}
+
+func TestAssignEncoderWeightedRandom(t *testing.T) {
+	defaultRandomIntN := encoderAssignmentRandomIntN
+	defer func() {
+		encoderAssignmentRandomIntN = defaultRandomIntN
+	}()
+
+	encoderAssignmentRandomIntN = mathRand.New(mathRand.NewPCG(1, 2)).IntN
+
+	cases := []struct {
+		name       string
+		candidates []*HLS_Encoder_Server
+		allowed    []uint64
+	}{
+		{"empty", []*HLS_Encoder_Server{}, []uint64{0}},
+		{"no free capacity", []*HLS_Encoder_Server{makeTestEncoder(1, 2, 2), makeTestEncoder(2, 0, 0)}, []uint64{0}},
+		{"skips full encoders", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 4), makeTestEncoder(2, 4, 1), makeTestEncoder(3, 0, 0)}, []uint64{2}},
+		{"mixed capacities", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 4), makeTestEncoder(2, 4, 1), makeTestEncoder(3, -1, 5), makeTestEncoder(4, 10, 2)}, []uint64{2, 3, 4}},
+	}
+
+	for _, c := range cases {
+		for i := 0; i < 1000; i++ {
+			result := selectedEncoderId(AssignEncoderWeightedRandom(c.candidates, "channel", 0))
+
+			allowed := false
+
+			for j := 0; j < len(c.allowed); j++ {
+				if c.allowed[j] == result {
+					allowed = true
+					break
+				}
+			}
+
+			if !allowed {
+				t.Errorf("%s: encoder #%d should not be chosen", c.name, result)
+				break
+			}
+		}
+	}
+}
+
+func TestAssignEncoderWeightedRandomDistribution(t *testing.T) {
+	defaultRandomIntN := encoderAssignmentRandomIntN
+	defer func() {
+		encoderAssignmentRandomIntN = defaultRandomIntN
+	}()
+
+	encoderAssignmentRandomIntN = mathRand.New(mathRand.NewPCG(3, 4)).IntN
+
+	// Free capacity: #1 = 1, #2 = 3, #3 = 3 (unlimited, weights as the encoder with the most free capacity)
+	candidates := []*HLS_Encoder_Server{makeTestEncoder(1, 4, 3), makeTestEncoder(2, 4, 1), makeTestEncoder(3, -1, 10)}
+
+	counts := make(map[uint64]int)
+
+	for i := 0; i < 7000; i++ {
+		counts[selectedEncoderId(AssignEncoderWeightedRandom(candidates, "channel", 0))]++
+	}
+
+	if counts[1] < 800 || counts[1] > 1200 {
+		t.Errorf("expected encoder #1 to be chosen about 1000 times, got %d", counts[1])
+	}
+
+	if counts[2] < 2700 || counts[2] > 3300 {
+		t.Errorf("expected encoder #2 to be chosen about 3000 times, got %d", counts[2])
+	}
+
+	if counts[3] < 2700 || counts[3] > 3300 {
+		t.Errorf("expected encoder #3 to be chosen about 3000 times, got %d", counts[3])
+	}
+}
+
+func TestAssignEncoderSticky(t *testing.T) {
+	// Encoder #1 is full, so it is never a candidate
+	candidates := []*HLS_Encoder_Server{makeTestEncoder(2, 4, 3), makeTestEncoder(3, 10, 2), makeTestEncoder(4, -1, 6)}
+
+	cases := []struct {
+		name        string
+		candidates  []*HLS_Encoder_Server
+		lastEncoder uint64
+		expected    uint64
+	}{
+		{"empty", []*HLS_Encoder_Server{}, 2, 0},
+		{"last encoder available", candidates, 2, 2},
+		{"last encoder with unlimited capacity", candidates, 4, 4},
+		{"last encoder full", candidates, 1, 4},
+		{"last encoder missing", candidates, 99, 4},
+		{"no last encoder", candidates, 0, 4},
+		{"fallback to least relative load", candidates[:2], 1, 3},
+	}
+
+	for _, c := range cases {
+		result := selectedEncoderId(AssignEncoderSticky(c.candidates, "channel", c.lastEncoder))
+
+		if result != c.expected {
+			t.Errorf("%s: expected encoder #%d, got #%d", c.name, c.expected, result)
+		}
+	}
+}
+
+func TestAssignEncoderBinPacking(t *testing.T) {
+	cases := []struct {
+		name       string
+		candidates []*HLS_Encoder_Server
+		expected   uint64
+	}{
+		{"empty", []*HLS_Encoder_Server{}, 0},
+		{"highest relative load", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 1), makeTestEncoder(2, 4, 3), makeTestEncoder(3, 10, 5)}, 2},
+		{"finite capacity first", []*HLS_Encoder_Server{makeTestEncoder(1, -1, 8), makeTestEncoder(2, 10, 0)}, 2},
+		{"finite capacity after unlimited", []*HLS_Encoder_Server{makeTestEncoder(1, 10, 0), makeTestEncoder(2, -1, 8)}, 1},
+		{"only unlimited", []*HLS_Encoder_Server{makeTestEncoder(1, -1, 8), makeTestEncoder(2, -1, 0)}, 1},
+		{"tie keeps the lowest ID", []*HLS_Encoder_Server{makeTestEncoder(1, 4, 2), makeTestEncoder(2, 8, 4)}, 1},
+	}
+
+	for _, c := range cases {
+		result := selectedEncoderId(AssignEncoderBinPacking(c.candidates, "channel", 0))
+
+		if result != c.expected {
+			t.Errorf("%s: expected encoder #%d, got #%d", c.name, c.expected, result)
+		}
+	}
+}
+
+func TestGetAvailableEncoders(t *testing.T) {
+	now := time.Now().UnixMilli()
+
+	overloadedStats := &EncoderStats{cpuUsage: 95, load: -1, timestamp: now}
+	idleStats := &EncoderStats{cpuUsage: 10, load: -1, timestamp: now}
+	oldStats := &EncoderStats{cpuUsage: 95, load: -1, timestamp: now - 120000}
+
+	draining := makeTestEncoder(1, 4, 0)
+	draining.draining = true
+
+	full := makeTestEncoder(2, 4, 4)
+
+	tagged := makeTestEncoder(3, 4, 0)
+	tagged.tags = []string{"gpu"}
+
+	overloaded := makeTestEncoder(4, 4, 0)
+	overloaded.stats = overloadedStats
+
+	available := makeTestEncoder(5, 4, 1)
+	available.stats = idleStats
+
+	unlimited := makeTestEncoder(6, -1, 20)
+	unlimited.stats = oldStats
+
+	filter := EncoderAssignmentFilter{
+		maxCpuUsage: 90,
+		maxAge:      60000,
+	}
+
+	cases := []struct {
+		name         string
+		encoders     []*HLS_Encoder_Server
+		requiredTags []string
+		expected     []uint64
+	}{
+		{"no encoders", []*HLS_Encoder_Server{}, []string{}, []uint64{}},
+		{"excludes draining, full and overloaded", []*HLS_Encoder_Server{draining, full, tagged, overloaded, available, unlimited}, []string{}, []uint64{3, 5, 6}},
+		{"excludes tag mismatch", []*HLS_Encoder_Server{draining, full, tagged, overloaded, available, unlimited}, []string{"gpu"}, []uint64{3}},
+		{"no encoder with the tags", []*HLS_Encoder_Server{available, unlimited}, []string{"gpu"}, []uint64{}},
+		{"falls back to overloaded", []*HLS_Encoder_Server{draining, full, overloaded}, []string{}, []uint64{4}},
+		{"only draining and full", []*HLS_Encoder_Server{draining, full}, []string{}, []uint64{}},
+	}
+
+	for _, c := range cases {
+		coord := &Streaming_Coordinator{
+			hlsEncoders:             make(map[uint64]*HLS_Encoder_Server),
+			encoderAssignmentFilter: filter,
+		}
+
+		for i := 0; i < len(c.encoders); i++ {
+			coord.hlsEncoders[c.encoders[i].id] = c.encoders[i]
+		}
+
+		result := coord.getAvailableEncoders(c.requiredTags)
+
+		if len(result) != len(c.expected) {
+			t.Errorf("%s: expected %d encoders, got %d", c.name, len(c.expected), len(result))
+			continue
+		}
+
+		for i := 0; i < len(result); i++ {
+			if result[i].id != c.expected[i] {
+				t.Errorf("%s: expected encoder #%d at position %d, got #%d", c.name, c.expected[i], i, result[i].id)
+			}
+		}
+	}
+}
+
+func TestChannelLastEncoderExpiry(t *testing.T) {
+	coord := &Streaming_Coordinator{
+		encoderStickyTTL:   time.Minute,
+		channelLastEncoder: make(map[string]*ChannelLastEncoder),
+	}
+
+	now := time.Now().UnixMilli()
+
+	coord.setChannelLastEncoder("open", 1)
+	coord.setChannelLastEncoder("closed", 2)
+	coord.setChannelLastEncoder("expired", 3)
+
+	coord.releaseChannelLastEncoder("expired", now)
+	coord.releaseChannelLastEncoder("closed", now+30000)
+
+	cases := []struct {
+		name     string
+		channel  string
+		now      int64
+		expected uint64
+	}{
+		{"open channel", "open", now + 120000, 1},
+		{"closed within the TTL", "closed", now + 60000, 2},
+		{"closed after the TTL", "expired", now + 60001, 0},
+		{"unknown channel", "unknown", now, 0},
+	}
+
+	for _, c := range cases {
+		result := coord.getChannelLastEncoder(c.channel, c.now)
+
+		if result != c.expected {
+			t.Errorf("%s: expected encoder #%d, got #%d", c.name, c.expected, result)
+		}
+	}
+
+	// The expired entries are pruned once the TTL passed since the last pruning
+
+	coord.releaseChannelLastEncoder("unknown", now+60001)
+
+	if coord.channelLastEncoder["expired"] != nil {
+		t.Errorf("expected the expired entry to be pruned")
+	}
+
+	if len(coord.channelLastEncoder) != 2 {
+		t.Errorf("expected 2 entries after pruning, got %d", len(coord.channelLastEncoder))
+	}
+
+	// With no TTL, the entry is dropped as soon as the channel is closed
+
+	coord.encoderStickyTTL = 0
+
+	coord.releaseChannelLastEncoder("open", now)
+
+	if coord.channelLastEncoder["open"] != nil {
+		t.Errorf("expected the entry to be dropped when the channel is closed")
+	}
+}
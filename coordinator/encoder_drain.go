@@ -0,0 +1,201 @@
+// Encoder drain mode
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Sets the drain mode of an encoder
+// A draining encoder is not assigned new streams, but keeps its current ones until they end
+// id - Encoder ID
+// draining - True to drain the encoder, false to cancel the drain
+// deadline - Unix timestamp (milliseconds) to move the remaining streams to other encoders. 0 = No deadline
+// Returns true if the encoder was found
+func (coord *Streaming_Coordinator) SetEncoderDrain(id uint64, draining bool, deadline int64) bool {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	encoder := coord.hlsEncoders[id]
+
+	if encoder == nil {
+		return false
+	}
+
+	encoder.draining = draining
+
+	if draining {
+		encoder.drainDeadline = deadline
+	} else {
+		encoder.drainDeadline = 0
+	}
+
+	eventType := LIVE_EVENT_ENCODER_DRAIN
+
+	if !draining {
+		eventType = LIVE_EVENT_ENCODER_DRAIN_CANCEL
+	}
+
+	coord.liveEvents.Publish(&LiveEvent{
+		EventType: eventType,
+		EncoderId: id,
+	})
+
+	return true
+}
+
+// Checks if the drain deadline of an encoder was reached
+// id - Encoder ID
+// deadline - The deadline set when the timer was started
+// Returns true only if the encoder is still draining with the same deadline
+func (coord *Streaming_Coordinator) isEncoderDrainDeadline(id uint64, deadline int64) bool {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	encoder := coord.hlsEncoders[id]
+
+	return encoder != nil && encoder.draining && encoder.drainDeadline == deadline
+}
+
+// Starts draining an encoder
+// id - Encoder ID
+// deadlineSeconds - Seconds to wait before moving the remaining streams to other encoders. 0 = No deadline
+// Returns true if the encoder was found
+func (server *Streaming_Coordinator_Server) DrainEncoder(id uint64, deadlineSeconds int) bool {
+	deadline := int64(0)
+
+	if deadlineSeconds > 0 {
+		deadline = time.Now().UnixMilli() + int64(deadlineSeconds)*1000
+	}
+
+	if !server.coordinator.SetEncoderDrain(id, true, deadline) {
+		return false
+	}
+
+	if deadline > 0 {
+		LogInfo("[DRAIN] Draining encoder #" + fmt.Sprint(id) + ". Remaining streams will be moved in " + fmt.Sprint(deadlineSeconds) + " seconds")
+
+		time.AfterFunc(time.Duration(deadlineSeconds)*time.Second, func() {
+			server.onEncoderDrainDeadline(id, deadline)
+		})
+	} else {
+		LogInfo("[DRAIN] Draining encoder #" + fmt.Sprint(id))
+	}
+
+	return true
+}
+
+// Called when the drain deadline of an encoder is reached
+// Moves the streams still handled by the encoder to other encoders
+// id - Encoder ID
+// deadline - The deadline set when the timer was started
+func (server *Streaming_Coordinator_Server) onEncoderDrainDeadline(id uint64, deadline int64) {
+	if !server.coordinator.isEncoderDrainDeadline(id, deadline) {
+		return // Cancelled, or the encoder disconnected
+	}
+
+	encoderSession := server.GetSession(id)
+
+	if encoderSession == nil {
+		return
+	}
+
+	associatedChannels := encoderSession.GetAssociatedChannels()
+
+	LogInfo("[DRAIN] Drain deadline reached for encoder #" + fmt.Sprint(id) + ". Moving " + fmt.Sprint(len(associatedChannels)) + " streams")
+
+	for i := 0; i < len(associatedChannels); i++ {
+		channelData := server.coordinator.AcquireChannel(associatedChannels[i])
+
+		if channelData.closed || channelData.encoder != id {
+			server.coordinator.ReleaseChannel(channelData)
+			continue
+		}
+
+		streamId := channelData.streamId
+
+		if server.failoverStream(channelData, id) {
+			encoderSession.SendEncodeStop(channelData.id, streamId)
+			encoderSession.DisassociateChannel(channelData.id)
+		} else {
+			LogWarning("[DRAIN] Could not move " + channelData.id + "/" + streamId + ". It will stay in the draining encoder #" + fmt.Sprint(id))
+		}
+
+		server.coordinator.ReleaseChannel(channelData)
+	}
+}
+
+// Handles ENCODER-DRAIN message
+// deadlineStr - Seconds until the encoder stops. Empty or 0 = No deadline
+func (session *ControlSession) HandleEncoderDrain(deadlineStr string) {
+	if session.sessionType != SESSION_TYPE_HLS || !session.encoderRegistered {
+		return
+	}
+
+	deadline, err := strconv.Atoi(deadlineStr)
+
+	if err != nil || deadline < 0 {
+		deadline = 0
+	}
+
+	session.log("ENCODER-DRAIN / DEADLINE: " + fmt.Sprint(deadline))
+
+	session.server.DrainEncoder(session.id, deadline)
+}
+
+// Runs the encoder drain command
+// w - Writer to send the response
+// req - Client request
+func (server *Streaming_Coordinator_Server) RunEncoderDrainCommand(w http.ResponseWriter, req *http.Request) {
+	authentication := req.Header.Get("Authorization")
+
+	if !CheckCommandAuthentication(authentication) {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Invalid authorization header.")
+		return
+	}
+
+	id, err := strconv.ParseUint(req.Header.Get("x-encoder-id"), 10, 64)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Invalid x-encoder-id header.")
+		return
+	}
+
+	deadline := 0
+
+	if req.Header.Get("x-drain-deadline") != "" {
+		deadline, err = strconv.Atoi(req.Header.Get("x-drain-deadline"))
+
+		if err != nil || deadline < 0 {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "Invalid x-drain-deadline header.")
+			return
+		}
+	}
+
+	var found bool
+
+	if req.Header.Get("x-drain-cancel") == "true" {
+		found = server.coordinator.SetEncoderDrain(id, false, 0)
+
+		if found {
+			LogInfo("[DRAIN] Cancelled drain of encoder #" + fmt.Sprint(id))
+		}
+	} else {
+		found = server.DrainEncoder(id, deadline)
+	}
+
+	if !found {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Encoder not found.")
+		return
+	}
+
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "SUCCESS")
+}
@@ -2,41 +2,37 @@
 
 package main
 
+import (
+	"fmt"
+	"time"
+)
+
 // Search in the list of available HLS encoders and assigns the stream to one
+// The encoder is chosen with the configured strategy (ENCODER_ASSIGNMENT_STRATEGY)
+// channel - The channel to assign
+// requiredTags - Tags the encoder must have (placement constraints of the stream)
 // Returns the control session, or nil, if none available
-func (server *Streaming_Coordinator_Server) AssignAvailableEncoder() *ControlSession {
-	selectedEncoder := uint64(0)
-	currentLoad := 0
-	encoderIsAvailable := false
-
+func (server *Streaming_Coordinator_Server) AssignAvailableEncoder(channel string, requiredTags []string) *ControlSession {
 	server.coordinator.mutex.Lock()
 
-	for id, encoder := range server.coordinator.hlsEncoders {
-		if encoder.capacity >= 0 && encoder.load >= encoder.capacity {
-			continue
-		}
-
-		if !encoderIsAvailable {
-			encoderIsAvailable = true
-			selectedEncoder = id
-			currentLoad = encoder.load
-		} else if encoder.load < currentLoad {
-			selectedEncoder = id
-			currentLoad = encoder.load
-		}
-	}
+	candidates := server.coordinator.getAvailableEncoders(requiredTags)
 
-	if encoderIsAvailable {
-		server.coordinator.hlsEncoders[selectedEncoder].load++
-	}
+	strategy := ENCODER_ASSIGNMENT_STRATEGIES[server.coordinator.encoderAssignmentStrategy]
 
-	server.coordinator.mutex.Unlock()
+	selectedEncoder := strategy(candidates, channel, server.coordinator.getChannelLastEncoder(channel, time.Now().UnixMilli()))
 
-	if !encoderIsAvailable {
+	if selectedEncoder == nil {
+		server.coordinator.mutex.Unlock()
 		return nil
 	}
 
-	return server.GetSession(selectedEncoder)
+	selectedEncoder.load++
+
+	server.coordinator.setChannelLastEncoder(channel, selectedEncoder.id)
+
+	server.coordinator.mutex.Unlock()
+
+	return server.GetSession(selectedEncoder.id)
 }
 
 // Releases an encoder server, reducing the load by one
@@ -44,10 +40,86 @@ func (server *Streaming_Coordinator_Server) AssignAvailableEncoder() *ControlSes
 func (server *Streaming_Coordinator_Server) ReleaseEncoder(encoderId uint64) {
 	server.coordinator.mutex.Lock()
 
-	if server.coordinator.hlsEncoders[encoderId] != nil {
+	if server.coordinator.hlsEncoders[encoderId] != nil && server.coordinator.hlsEncoders[encoderId].load > 0 {
 		server.coordinator.hlsEncoders[encoderId].load--
 	}
 
 	server.coordinator.mutex.Unlock()
 
+	// The released slot may be assigned to a queued publish request
+	go server.DispatchPublishQueue()
+}
+
+// Moves a stream to another encoder, after its encoder disconnected
+// The stream keeps the same ID, and the new encoder continues the fragment numbering
+// Must be called with the channel acquired
+// channelData - The channel
+// failedEncoderId - ID of the disconnected encoder
+// Returns true if the stream was moved, false if there are no encoders available
+func (server *Streaming_Coordinator_Server) failoverStream(channelData *StreamingChannel, failedEncoderId uint64) bool {
+	channel := channelData.id
+	streamId := channelData.streamId
+
+	encoderServer := server.AssignAvailableEncoder(channel, channelData.config.EncoderTags)
+
+	if encoderServer == nil {
+		LogWarning("[FAILOVER] No encoders available for " + channel + "/" + streamId + ". Closing the stream.")
+		METRICS.OnEncoderFailover(false)
+		return false
+	}
+
+	encoderServer.AssociateChannel(channel)
+	channelData.encoder = encoderServer.id
+
+	server.coordinator.UpdateChannelState(channelData)
+
+	// The encoding restarts from the current source, so any pending publisher replacement is complete
+	server.coordinator.TakeMigratingStream(channel, streamId)
+
+	resumeTime := float64(time.Now().UnixMilli()-channelData.encodeStartTime) / 1000
+
+	encoderServer.SendEncodeStart(channel, streamId, channelData.publishMethod, channelData.sourceURL, channelData.resolutions, channelData.record, channelData.previews, channelData.config, resumeTime)
+
+	LogInfo("[FAILOVER] Moved " + channel + "/" + streamId + " from encoder #" + fmt.Sprint(failedEncoderId) + " to encoder #" + fmt.Sprint(encoderServer.id))
+
+	METRICS.OnEncoderFailover(true)
+
+	server.coordinator.OnActiveStreamInterrupted(channel, streamId, encoderServer.id)
+
+	server.coordinator.liveEvents.Publish(&LiveEvent{
+		EventType: LIVE_EVENT_ENCODE_START,
+		Channel:   channel,
+		StreamId:  streamId,
+		EncoderId: encoderServer.id,
+	})
+
+	return true
+}
+
+// Closes a stream after its encoder disconnected, killing the publisher
+// Must be called with the channel acquired
+// channelData - The channel
+func (server *Streaming_Coordinator_Server) closeStreamWithLostEncoder(channelData *StreamingChannel) {
+	channelData.SetCloseReason(MakeStreamCloseReason(CLOSE_REASON_ENCODER_LOST, "The encoder disconnected, and the stream could not be moved to another encoder"))
+
+	closeReason := channelData.GetCloseReason(channelData.streamId)
+
+	if closeReason.IsFailure() {
+		server.coordinator.OnStreamFailed(channelData.id, channelData.streamId, closeReason)
+	}
+
+	server.coordinator.OnActiveStreamClosed(channelData.id, channelData.streamId, closeReason)
+
+	pubSession := server.GetSession(channelData.publisher)
+
+	if pubSession != nil {
+		pubSession.SendStreamKill(channelData.id, channelData.streamId)
+	}
+
+	server.releaseBackupPublisher(channelData, server.GetSession(channelData.backupPublisher))
+
+	// Cancel any stream-available events
+	for _, event := range channelData.pendingEvents {
+		event.cancelled = true
+	}
 }
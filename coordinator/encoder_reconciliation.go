@@ -0,0 +1,175 @@
+// Encoder reconciliation (encoders reconnecting with active tasks)
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const ENCODER_RECONNECT_DEFAULT_GRACE_PERIOD = 30 // Default time to wait for a disconnected encoder to reconnect (seconds)
+
+// Encoding task reported by an encoder when registering
+type EncoderTaskInfo struct {
+	channel  string // Channel ID
+	streamId string // Stream ID
+
+	sourceType string // Source type (RTMP or WS)
+	sourceURI  string // Source URI
+}
+
+// Parses the list of tasks sent by the encoder in the REGISTER message
+// str - The list. Format: {CHANNEL}:{STREAM_ID}:{SOURCE_TYPE}:{SOURCE_URI}, split by commas. The source URI is URL encoded
+// Returns the parsed list. Invalid entries are ignored
+func ParseEncoderTaskInfoList(str string) []EncoderTaskInfo {
+	result := make([]EncoderTaskInfo, 0)
+
+	if str == "" {
+		return result
+	}
+
+	entries := strings.Split(str, ",")
+
+	for i := 0; i < len(entries); i++ {
+		parts := strings.SplitN(strings.Trim(entries[i], " "), ":", 4)
+
+		if len(parts) != 4 {
+			continue
+		}
+
+		sourceURI, err := url.QueryUnescape(parts[3])
+
+		if err != nil {
+			continue
+		}
+
+		result = append(result, EncoderTaskInfo{
+			channel:    parts[0],
+			streamId:   parts[1],
+			sourceType: parts[2],
+			sourceURI:  sourceURI,
+		})
+	}
+
+	return result
+}
+
+// Registers a stream whose encoder disconnected, so the encoder can adopt it again if it reconnects
+// channel - The channel
+// streamId - The stream ID
+// encoderId - ID of the disconnected encoder
+func (coord *Streaming_Coordinator) AddLostEncoderStream(channel string, streamId string, encoderId uint64) {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	coord.lostEncoderStreams[channel+":"+streamId] = encoderId
+}
+
+// Removes a stream from the list of streams whose encoder disconnected
+// channel - The channel
+// streamId - The stream ID
+// Returns true if the stream was in the list
+func (coord *Streaming_Coordinator) TakeLostEncoderStream(channel string, streamId string) bool {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	id := channel + ":" + streamId
+
+	_, found := coord.lostEncoderStreams[id]
+
+	delete(coord.lostEncoderStreams, id)
+
+	return found
+}
+
+// Increases the load of an encoder by one
+// id - Encoder ID
+func (coord *Streaming_Coordinator) AddEncoderLoad(id uint64) {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	if coord.hlsEncoders[id] != nil {
+		coord.hlsEncoders[id].load++
+	}
+}
+
+// Call when the encoder of an active stream disconnects
+// Waits for the encoder to reconnect (ENCODER_RECONNECT_GRACE_SECONDS)
+// If the stream is not adopted by then, it is moved to another encoder (if failover is enabled) or closed
+// channel - The channel
+// streamId - The stream ID
+// encoderId - ID of the disconnected encoder
+func (server *Streaming_Coordinator_Server) OnStreamEncoderLost(channel string, streamId string, encoderId uint64) {
+	server.coordinator.AddLostEncoderStream(channel, streamId, encoderId)
+
+	if server.coordinator.encoderReconnectGracePeriod > 0 {
+		time.Sleep(server.coordinator.encoderReconnectGracePeriod)
+	}
+
+	if !server.coordinator.TakeLostEncoderStream(channel, streamId) {
+		return // Adopted by the encoder after reconnecting
+	}
+
+	channelData := server.coordinator.AcquireChannel(channel)
+	defer server.coordinator.ReleaseChannel(channelData)
+
+	if channelData.closed || channelData.streamId != streamId || channelData.encoder != encoderId {
+		// The publisher ended while waiting for the encoder
+		server.coordinator.OnActiveStreamClosed(channel, streamId, channelData.GetCloseReason(streamId))
+
+		if channelData.closed && channelData.streamId == streamId {
+			server.releaseBackupPublisher(channelData, server.GetSession(channelData.backupPublisher))
+		}
+
+		return
+	}
+
+	if server.coordinator.encoderFailover && server.failoverStream(channelData, encoderId) {
+		return
+	}
+
+	server.closeStreamWithLostEncoder(channelData)
+}
+
+// Reconciles the tasks reported by an encoder after registering
+// Tasks matching a stream whose encoder disconnected are adopted, and the rest are stopped
+// session - The encoder session
+// tasks - The tasks reported by the encoder
+func (server *Streaming_Coordinator_Server) ReconcileEncoderTasks(session *ControlSession, tasks []EncoderTaskInfo) {
+	for i := 0; i < len(tasks); i++ {
+		task := tasks[i]
+
+		// Every task counts as load until the encoder reports it as closed
+		server.coordinator.AddEncoderLoad(session.id)
+
+		if !server.coordinator.TakeLostEncoderStream(task.channel, task.streamId) {
+			session.log("ORPHANED TASK: " + task.channel + "/" + task.streamId + ". Stopping it.")
+			session.SendEncodeStop(task.channel, task.streamId)
+			continue
+		}
+
+		channelData := server.coordinator.AcquireChannel(task.channel)
+
+		if channelData.closed || channelData.streamId != task.streamId || channelData.sourceURL != task.sourceURI {
+			// The publisher ended while waiting for the encoder
+			server.coordinator.ReleaseChannel(channelData)
+
+			session.log("TASK OF ENDED STREAM: " + task.channel + "/" + task.streamId + ". Stopping it.")
+			session.SendEncodeStop(task.channel, task.streamId)
+			continue
+		}
+
+		previousEncoder := channelData.encoder
+
+		channelData.encoder = session.id
+		session.AssociateChannel(task.channel)
+
+		server.coordinator.UpdateChannelState(channelData)
+
+		server.coordinator.ReleaseChannel(channelData)
+
+		session.log("ADOPTED TASK: " + task.channel + "/" + task.streamId + " | PREVIOUS ENCODER: #" + fmt.Sprint(previousEncoder))
+	}
+}
@@ -0,0 +1,167 @@
+// Encoder resource usage stats
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ENCODER_STATS_DEFAULT_MAX_AGE = 60 // Default max age of the encoder stats to be taken into account (seconds)
+
+// Resource usage stats reported by a HLS encoder
+type EncoderStats struct {
+	cpuUsage    float64 // CPU usage (0 to 100). -1 if unknown
+	memoryUsed  uint64  // Used memory (bytes). 0 if unknown
+	memoryTotal uint64  // Total memory (bytes). 0 if unknown
+
+	load int // Number of encoding tasks reported by the encoder. -1 if unknown
+
+	tasks []EncoderTaskStats // Stats of the encoding tasks
+
+	timestamp int64 // Unix timestamp (milliseconds) when the stats were received
+}
+
+// Stats of an encoding task
+type EncoderTaskStats struct {
+	channel  string // Channel ID
+	streamId string // Stream ID
+
+	speed         float64 // Encoding speed, relative to real time (1 = real time)
+	fps           float64 // Encoding frames per second
+	droppedFrames uint64  // Number of dropped frames
+
+	renditions       int   // Number of renditions being encoded
+	fragments        int   // Number of HLS fragments produced
+	lastFragmentTime int64 // Unix timestamp (milliseconds) of the last fragment. 0 = None yet
+}
+
+// Configuration to filter the encoders by their stats when assigning streams
+type EncoderAssignmentFilter struct {
+	maxCpuUsage float64 // Max CPU usage (0 = disabled)
+	minSpeed    float64 // Min encoding speed of the tasks (0 = disabled)
+	maxAge      int64   // Max age of the stats (milliseconds)
+}
+
+// Loads the encoder assignment filter from the environment variables
+// Returns the configuration
+func GetEncoderAssignmentFilter() EncoderAssignmentFilter {
+	return EncoderAssignmentFilter{
+		maxCpuUsage: getEnvFloat("ENCODER_ASSIGNMENT_MAX_CPU", 0),
+		minSpeed:    getEnvFloat("ENCODER_ASSIGNMENT_MIN_SPEED", 0),
+		maxAge:      int64(getEnvInt("ENCODER_STATS_MAX_AGE_SECONDS", ENCODER_STATS_DEFAULT_MAX_AGE)) * 1000,
+	}
+}
+
+// Parses the list of task stats sent by the encoder
+// str - The list. Format: {CHANNEL}:{STREAM_ID}:{SPEED}:{FPS}:{DROPPED_FRAMES}[:{RENDITIONS}:{FRAGMENTS}:{LAST_FRAGMENT_TIME}], split by commas
+// Returns the parsed list. Invalid entries are ignored
+func ParseEncoderTaskStats(str string) []EncoderTaskStats {
+	result := make([]EncoderTaskStats, 0)
+
+	if str == "" {
+		return result
+	}
+
+	entries := strings.Split(str, ",")
+
+	for i := 0; i < len(entries); i++ {
+		parts := strings.Split(strings.Trim(entries[i], " "), ":")
+
+		if len(parts) != 5 && len(parts) != 8 {
+			continue
+		}
+
+		speed, err := strconv.ParseFloat(parts[2], 64)
+
+		if err != nil {
+			continue
+		}
+
+		fps, _ := strconv.ParseFloat(parts[3], 64)
+		droppedFrames, _ := strconv.ParseUint(parts[4], 10, 64)
+
+		taskStats := EncoderTaskStats{
+			channel:       parts[0],
+			streamId:      parts[1],
+			speed:         speed,
+			fps:           fps,
+			droppedFrames: droppedFrames,
+		}
+
+		if len(parts) == 8 {
+			// Output stats (sent by newer encoders)
+			taskStats.renditions, _ = strconv.Atoi(parts[5])
+			taskStats.fragments, _ = strconv.Atoi(parts[6])
+			taskStats.lastFragmentTime, _ = strconv.ParseInt(parts[7], 10, 64)
+		}
+
+		result = append(result, taskStats)
+	}
+
+	return result
+}
+
+// Gets the lowest encoding speed of the tasks
+// Returns the speed, or -1 if there are no tasks
+func (stats *EncoderStats) MinSpeed() float64 {
+	minSpeed := float64(-1)
+
+	for i := 0; i < len(stats.tasks); i++ {
+		if minSpeed < 0 || stats.tasks[i].speed < minSpeed {
+			minSpeed = stats.tasks[i].speed
+		}
+	}
+
+	return minSpeed
+}
+
+// Checks if the stats of the encoder allow assigning it another stream
+// Encoders without recent stats are always allowed
+// filter - The assignment filter
+// now - Current unix timestamp (milliseconds)
+// Returns true if the encoder can be assigned
+func (encoder *HLS_Encoder_Server) PassesAssignmentFilter(filter *EncoderAssignmentFilter, now int64) bool {
+	if encoder.stats == nil || now-encoder.stats.timestamp > filter.maxAge {
+		return true
+	}
+
+	if filter.maxCpuUsage > 0 && encoder.stats.cpuUsage >= filter.maxCpuUsage {
+		return false
+	}
+
+	if filter.minSpeed > 0 {
+		minSpeed := encoder.stats.MinSpeed()
+
+		if minSpeed >= 0 && minSpeed < filter.minSpeed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Updates the stats of an encoder
+// id - Encoder ID
+// stats - The stats
+func (coord *Streaming_Coordinator) UpdateEncoderStats(id uint64, stats *EncoderStats) {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	encoder := coord.hlsEncoders[id]
+
+	if encoder == nil {
+		return
+	}
+
+	stats.timestamp = time.Now().UnixMilli()
+
+	// A stream may be assigned while the stats are being sent, so the mismatch is only reported if it persists
+	if stats.load >= 0 && stats.load != encoder.load && encoder.stats != nil && encoder.stats.load == stats.load {
+		LogWarning("HLS encoder #" + fmt.Sprint(id) + " reported a load of " + fmt.Sprint(stats.load) + ", but the coordinator assigned " + fmt.Sprint(encoder.load) + " streams to it")
+	}
+
+	encoder.stats = stats
+}
@@ -0,0 +1,449 @@
+// Durable outbox for event callbacks
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const EVENTS_OUTBOX_FILE = "events_outbox.log"
+const EVENTS_OUTBOX_TMP_FILE = "events_outbox.tmp"
+
+const EVENTS_OUTBOX_COMPACT_THRESHOLD = 256 // Number of delivered events before compacting the file
+
+const EVENTS_DEAD_LETTER_DEFAULT_LIMIT = 10000 // Default max number of events in the dead letter store
+
+const (
+	OUTBOX_RECORD_ADD  = "ADD"
+	OUTBOX_RECORD_DONE = "DONE"
+	OUTBOX_RECORD_DEAD = "DEAD"
+)
+
+// Record of the outbox file (one per line)
+type EventOutboxRecord struct {
+	Action    string         `json:"action"`              // Action: ADD, DONE or DEAD
+	Id        string         `json:"id"`                  // Delivery ID
+	Delivery  *EventDelivery `json:"delivery,omitempty"`  // Delivery data (only for ADD and DEAD)
+	Timestamp int64          `json:"timestamp,omitempty"` // Unix timestamp (milliseconds) when the delivery was moved to the dead letter store (only for DEAD)
+}
+
+// Event delivery that could not be completed
+type EventDeadLetter struct {
+	Id           string         `json:"id"`           // Delivery ID
+	Subscription string         `json:"subscription"` // Subscription ID
+	Event        *CallbackEvent `json:"event"`        // The event
+	Timestamp    int64          `json:"timestamp"`    // Unix timestamp (milliseconds) when the delivery was moved to the dead letter store
+}
+
+// Append-only file storing the event deliveries pending of being completed
+type EventOutbox struct {
+	path string   // Path to the directory of the outbox file
+	file *os.File // Outbox file, opened for appending
+
+	mutex *sync.Mutex // Mutex to access the data
+
+	pending      map[string]*EventDelivery // Pending deliveries. Map: ID -> Delivery
+	pendingOrder []string                  // IDs of the pending deliveries, in order
+
+	deadLetters      map[string]*EventDeadLetter // Deliveries that could not be completed. Map: ID -> Dead letter
+	deadLettersOrder []string                    // IDs of the dead letters, in order
+	deadLettersLimit int                         // Max number of dead letters to keep
+
+	doneCount int // Number of DONE records since the last compaction
+}
+
+// Loads the outbox from the file and opens it for appending
+// path - Path to the directory of the outbox file
+// Returns a reference to the outbox
+func LoadEventOutbox(path string) *EventOutbox {
+	outbox := &EventOutbox{
+		path:         path,
+		file:         nil,
+		mutex:        &sync.Mutex{},
+		pending:      make(map[string]*EventDelivery),
+		pendingOrder: make([]string, 0),
+
+		deadLetters:      make(map[string]*EventDeadLetter),
+		deadLettersOrder: make([]string, 0),
+		deadLettersLimit: getEnvInt("EVENT_DEAD_LETTER_LIMIT", EVENTS_DEAD_LETTER_DEFAULT_LIMIT),
+
+		doneCount: 0,
+	}
+
+	content, err := os.ReadFile(filepath.Join(outbox.path, EVENTS_OUTBOX_FILE))
+
+	if err == nil {
+		lines := strings.Split(string(content), "\n")
+
+		for i := 0; i < len(lines); i++ {
+			if strings.TrimSpace(lines[i]) == "" {
+				continue
+			}
+
+			record := EventOutboxRecord{}
+
+			err = json.Unmarshal([]byte(lines[i]), &record)
+
+			if err != nil {
+				LogWarning("Ignored invalid line in " + EVENTS_OUTBOX_FILE + ": " + err.Error())
+				continue
+			}
+
+			switch record.Action {
+			case OUTBOX_RECORD_ADD:
+				if record.Delivery != nil && record.Delivery.Event != nil && outbox.pending[record.Id] == nil {
+					outbox.removeDeadLetter(record.Id)
+					outbox.pending[record.Id] = record.Delivery
+					outbox.pendingOrder = append(outbox.pendingOrder, record.Id)
+				}
+			case OUTBOX_RECORD_DONE:
+				outbox.removePending(record.Id)
+			case OUTBOX_RECORD_DEAD:
+				outbox.removePending(record.Id)
+
+				if record.Delivery != nil && record.Delivery.Event != nil {
+					outbox.addDeadLetter(record.Delivery, record.Timestamp)
+				}
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		LogError(err)
+	}
+
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	outbox.compact()
+
+	return outbox
+}
+
+// Removes a delivery from the pending list
+// Does not write to the file
+// id - Delivery ID
+func (outbox *EventOutbox) removePending(id string) {
+	if outbox.pending[id] == nil {
+		return
+	}
+
+	delete(outbox.pending, id)
+
+	for i := 0; i < len(outbox.pendingOrder); i++ {
+		if outbox.pendingOrder[i] == id {
+			outbox.pendingOrder = append(outbox.pendingOrder[:i], outbox.pendingOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// Removes a delivery from the dead letter store
+// Does not write to the file
+// id - Delivery ID
+func (outbox *EventOutbox) removeDeadLetter(id string) {
+	if outbox.deadLetters[id] == nil {
+		return
+	}
+
+	delete(outbox.deadLetters, id)
+
+	for i := 0; i < len(outbox.deadLettersOrder); i++ {
+		if outbox.deadLettersOrder[i] == id {
+			outbox.deadLettersOrder = append(outbox.deadLettersOrder[:i], outbox.deadLettersOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// Adds a delivery to the dead letter store
+// If the store is full, the oldest dead letters are removed
+// Does not write to the file
+// delivery - The delivery
+// timestamp - Unix timestamp (milliseconds) when the delivery was moved to the dead letter store
+func (outbox *EventOutbox) addDeadLetter(delivery *EventDelivery, timestamp int64) {
+	outbox.removeDeadLetter(delivery.Id)
+
+	outbox.deadLetters[delivery.Id] = &EventDeadLetter{
+		Id:           delivery.Id,
+		Subscription: delivery.Subscription,
+		Event:        delivery.Event,
+		Timestamp:    timestamp,
+	}
+	outbox.deadLettersOrder = append(outbox.deadLettersOrder, delivery.Id)
+
+	for outbox.deadLettersLimit > 0 && len(outbox.deadLettersOrder) > outbox.deadLettersLimit {
+		delete(outbox.deadLetters, outbox.deadLettersOrder[0])
+		outbox.deadLettersOrder = outbox.deadLettersOrder[1:]
+	}
+}
+
+// Appends a record to the outbox file
+// record - The record
+func (outbox *EventOutbox) appendRecord(record *EventOutboxRecord) {
+	if outbox.file == nil {
+		return
+	}
+
+	line, err := json.Marshal(record)
+
+	if err != nil {
+		LogError(err)
+		return
+	}
+
+	_, err = outbox.file.Write(append(line, '\n'))
+
+	if err != nil {
+		LogError(err)
+		return
+	}
+
+	err = outbox.file.Sync()
+
+	if err != nil {
+		LogError(err)
+	}
+}
+
+// Rewrites the outbox file, keeping only the pending deliveries and the dead letters
+// Must be called with the mutex locked
+func (outbox *EventOutbox) compact() {
+	if outbox.file != nil {
+		outbox.file.Close()
+		outbox.file = nil
+	}
+
+	content := make([]byte, 0)
+
+	for i := 0; i < len(outbox.pendingOrder); i++ {
+		id := outbox.pendingOrder[i]
+
+		line, err := json.Marshal(&EventOutboxRecord{
+			Action:   OUTBOX_RECORD_ADD,
+			Id:       id,
+			Delivery: outbox.pending[id],
+		})
+
+		if err != nil {
+			LogError(err)
+			continue
+		}
+
+		content = append(content, line...)
+		content = append(content, '\n')
+	}
+
+	for i := 0; i < len(outbox.deadLettersOrder); i++ {
+		deadLetter := outbox.deadLetters[outbox.deadLettersOrder[i]]
+
+		line, err := json.Marshal(&EventOutboxRecord{
+			Action:    OUTBOX_RECORD_DEAD,
+			Id:        deadLetter.Id,
+			Delivery:  deadLetter.Delivery(),
+			Timestamp: deadLetter.Timestamp,
+		})
+
+		if err != nil {
+			LogError(err)
+			continue
+		}
+
+		content = append(content, line...)
+		content = append(content, '\n')
+	}
+
+	outboxFile := filepath.Join(outbox.path, EVENTS_OUTBOX_FILE)
+	tmpFile := filepath.Join(outbox.path, EVENTS_OUTBOX_TMP_FILE)
+
+	err := os.WriteFile(tmpFile, content, FILE_PERMISSION)
+
+	if err != nil {
+		LogError(err)
+	} else {
+		err = os.Rename(tmpFile, outboxFile)
+
+		if err != nil {
+			LogError(err)
+		}
+	}
+
+	file, err := os.OpenFile(outboxFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, FILE_PERMISSION)
+
+	if err != nil {
+		LogError(err)
+		LogWarning("Could not open " + EVENTS_OUTBOX_FILE + ". Events will not be persisted.")
+	} else {
+		outbox.file = file
+	}
+
+	outbox.doneCount = 0
+}
+
+// Adds a delivery to the outbox
+// delivery - The delivery
+func (outbox *EventOutbox) Add(delivery *EventDelivery) {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	if outbox.pending[delivery.Id] != nil {
+		return
+	}
+
+	outbox.pending[delivery.Id] = delivery
+	outbox.pendingOrder = append(outbox.pendingOrder, delivery.Id)
+
+	outbox.appendRecord(&EventOutboxRecord{
+		Action:   OUTBOX_RECORD_ADD,
+		Id:       delivery.Id,
+		Delivery: delivery,
+	})
+}
+
+// Marks a delivery as done (delivered or discarded)
+// id - Delivery ID
+func (outbox *EventOutbox) MarkDone(id string) {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	if outbox.pending[id] == nil {
+		return
+	}
+
+	outbox.removePending(id)
+
+	outbox.appendRecord(&EventOutboxRecord{
+		Action: OUTBOX_RECORD_DONE,
+		Id:     id,
+	})
+
+	outbox.doneCount++
+
+	if outbox.doneCount >= EVENTS_OUTBOX_COMPACT_THRESHOLD {
+		outbox.compact()
+	}
+}
+
+// Moves a delivery to the dead letter store
+// id - Delivery ID
+func (outbox *EventOutbox) MarkDeadLetter(id string) {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	delivery := outbox.pending[id]
+
+	if delivery == nil {
+		return
+	}
+
+	outbox.removePending(id)
+
+	timestamp := time.Now().UnixMilli()
+
+	outbox.addDeadLetter(delivery, timestamp)
+
+	outbox.appendRecord(&EventOutboxRecord{
+		Action:    OUTBOX_RECORD_DEAD,
+		Id:        id,
+		Delivery:  delivery,
+		Timestamp: timestamp,
+	})
+
+	outbox.doneCount++
+
+	if outbox.doneCount >= EVENTS_OUTBOX_COMPACT_THRESHOLD {
+		outbox.compact()
+	}
+}
+
+// Moves a delivery from the dead letter store back to the pending list
+// id - Delivery ID
+// Returns the delivery, or nil if not found
+func (outbox *EventOutbox) ReplayDeadLetter(id string) *EventDelivery {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	deadLetter := outbox.deadLetters[id]
+
+	if deadLetter == nil {
+		return nil
+	}
+
+	outbox.removeDeadLetter(id)
+
+	delivery := deadLetter.Delivery()
+
+	outbox.pending[id] = delivery
+	outbox.pendingOrder = append(outbox.pendingOrder, id)
+
+	outbox.appendRecord(&EventOutboxRecord{
+		Action:   OUTBOX_RECORD_ADD,
+		Id:       id,
+		Delivery: delivery,
+	})
+
+	return delivery
+}
+
+// Gets the delivery stored in a dead letter
+// Returns the delivery
+func (deadLetter *EventDeadLetter) Delivery() *EventDelivery {
+	return &EventDelivery{
+		Id:           deadLetter.Id,
+		Subscription: deadLetter.Subscription,
+		Event:        deadLetter.Event,
+	}
+}
+
+// Gets the list of dead letters, in order
+// Returns the list
+func (outbox *EventOutbox) GetDeadLetters() []EventDeadLetter {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	result := make([]EventDeadLetter, 0)
+
+	for i := 0; i < len(outbox.deadLettersOrder); i++ {
+		result = append(result, *outbox.deadLetters[outbox.deadLettersOrder[i]])
+	}
+
+	return result
+}
+
+// Gets the list of pending deliveries, in order
+// Returns the list of deliveries
+func (outbox *EventOutbox) GetPending() []*EventDelivery {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	result := make([]*EventDelivery, 0)
+
+	for i := 0; i < len(outbox.pendingOrder); i++ {
+		result = append(result, outbox.pending[outbox.pendingOrder[i]])
+	}
+
+	return result
+}
+
+// Checks if there is a pending event
+// eventType - Event type
+// channel - Channel ID
+// streamId - Stream ID
+// Returns true if there is a pending event matching the parameters
+func (outbox *EventOutbox) HasPending(eventType string, channel string, streamId string) bool {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	for _, delivery := range outbox.pending {
+		event := delivery.Event
+
+		if event.EventType == eventType && event.Channel == channel && event.StreamId == streamId {
+			return true
+		}
+	}
+
+	return false
+}
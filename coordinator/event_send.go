@@ -3,165 +3,440 @@
 package main
 
 import (
-	"encoding/base64"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	mathRand "math/rand/v2"
 	"net/http"
-	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	EVENT_SEND_RETRY_DELAY = 10 * time.Second
+	EVENT_SEND_RETRY_DELAY     = 10 * time.Second // Default base delay for retries
+	EVENT_SEND_RETRY_MAX_DELAY = 10 * time.Minute // Default max delay for retries
+	EVENT_SEND_TIMEOUT         = 30 * time.Second // Default timeout for each request
 )
 
-// Sends an stream-available event
-// Retries until success
-// channel - Reference to the channel
-// event - Reference to the event
-func SendStreamAvailableEvent(channel *StreamingChannel, event *PendingStreamAvailableEvent) {
-	sent := false
+const (
+	EVENT_SEND_RESULT_SENT      = 1 // The event was delivered
+	EVENT_SEND_RESULT_CANCELLED = 2 // The event was cancelled
+	EVENT_SEND_RESULT_EXPIRED   = 3 // The event reached the max number of attempts or max age
+)
+
+// Configuration for retrying event callbacks
+type EventRetryConfiguration struct {
+	timeout time.Duration // Timeout for each request
 
-	eventURL := os.Getenv("EVENT_CALLBACK_URL")
+	retryBaseDelay time.Duration // Delay before the first retry
+	retryMaxDelay  time.Duration // Max delay between retries
+
+	maxAttempts int           // Max number of attempts (0 = unlimited)
+	maxAge      time.Duration // Max time retrying to send the event (0 = unlimited)
+}
 
-	if eventURL == "" {
-		LogWarning("No EVENT_CALLBACK_URL set. Ignoring stream-available event.")
-		sent = true
+// Loads the event retry configuration from the environment variables
+// Returns the configuration
+func GetEventRetryConfiguration() EventRetryConfiguration {
+	return EventRetryConfiguration{
+		timeout:        time.Duration(getEnvInt("EVENT_CALLBACK_TIMEOUT_SECONDS", int(EVENT_SEND_TIMEOUT/time.Second))) * time.Second,
+		retryBaseDelay: time.Duration(getEnvInt("EVENT_CALLBACK_RETRY_DELAY_SECONDS", int(EVENT_SEND_RETRY_DELAY/time.Second))) * time.Second,
+		retryMaxDelay:  time.Duration(getEnvInt("EVENT_CALLBACK_RETRY_MAX_DELAY_SECONDS", int(EVENT_SEND_RETRY_MAX_DELAY/time.Second))) * time.Second,
+		maxAttempts:    getEnvInt("EVENT_CALLBACK_MAX_ATTEMPTS", 0),
+		maxAge:         time.Duration(getEnvInt("EVENT_CALLBACK_MAX_AGE_SECONDS", 0)) * time.Second,
 	}
+}
+
+// Event to be sent to the application
+type CallbackEvent struct {
+	Id        string `json:"id"`        // Unique event ID
+	Timestamp int64  `json:"timestamp"` // Unix timestamp (milliseconds) when the event was created
+	EventType string `json:"eventType"` // Event type
+
+	Channel  string `json:"channel"`  // Channel ID
+	StreamId string `json:"streamId"` // Stream ID
+
+	StreamType string `json:"streamType,omitempty"` // Stream type: HLS-LIVE, HLS-VOD, IMG-PREVIEW
+	Resolution string `json:"resolution,omitempty"` // Resolution: {WIDTH}x{HEIGHT}-{FPS}
+	IndexFile  string `json:"indexFile,omitempty"`  // The index file path
+	StartTime  string `json:"startTime,omitempty"`  // Start time (seconds)
+
+	UserIP        string `json:"userIp,omitempty"`        // IP of the publisher (for publish-started and publish-denied)
+	PublishMethod string `json:"publishMethod,omitempty"` // Publish method: RTMP or WS (for publish-started and publish-denied)
+	DenyReason    string `json:"denyReason,omitempty"`    // Reason why the publish request was denied (for publish-denied)
+
+	CloseReason   string `json:"closeReason,omitempty"`   // Reason why the stream was closed (for stream-closed and stream-failed)
+	CloseMessage  string `json:"closeMessage,omitempty"`  // Message explaining why the stream was closed (for stream-closed and stream-failed)
+	RemainingTime int64  `json:"remainingTime,omitempty"` // Remaining time until the stream is closed, in seconds (for stream-duration-warning)
+
+	SourceInfo *StreamSourceInfo `json:"sourceInfo,omitempty"` // Information of the source stream (for stream-info)
+}
 
-	authorization := ""
+// Delivery of an event to a subscription
+type EventDelivery struct {
+	Id           string         `json:"id"`           // Delivery ID ({EVENT_ID}/{SUBSCRIPTION_ID})
+	Subscription string         `json:"subscription"` // Subscription ID
+	Event        *CallbackEvent `json:"event"`        // The event
+}
+
+// Generates an unique ID for an event
+func GenerateEventId() string {
+	idBytes := make([]byte, 16)
 
-	authMethod := strings.ToUpper(os.Getenv("EVENT_CALLBACK_AUTH"))
+	_, err := rand.Read(idBytes)
 
-	switch authMethod {
-	case "BASIC":
-		user := os.Getenv("EVENT_CALLBACK_AUTH_USER")
-		password := os.Getenv("EVENT_CALLBACK_PASSWORD")
-		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
-	case "BEARER":
-		token := os.Getenv("EVENT_CALLBACK_AUTH_TOKEN")
-		authorization = "Bearer " + token
-	case "CUSTOM":
-		authorization = os.Getenv("EVENT_CALLBACK_AUTH_CUSTOM")
+	if err != nil {
+		LogError(err)
 	}
 
-	for !sent && !event.cancelled {
-		client := &http.Client{}
+	return strings.ToLower(hex.EncodeToString(idBytes))
+}
 
-		req, e := http.NewRequest("POST", eventURL, nil)
+// Computes the signature of an event body
+// secret - Signature secret
+// timestamp - Timestamp sent in the x-event-timestamp header
+// body - Request body
+// Returns the signature, hex encoded
+func SignEventBody(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
 
-		if e != nil {
-			LogError(e)
-			time.Sleep(EVENT_SEND_RETRY_DELAY)
-			continue
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Creates the HTTP request to send an event
+// subscription - The subscription
+// event - The event
+// Returns the request
+func MakeCallbackEventRequest(subscription *EventSubscription, event *CallbackEvent) (*http.Request, error) {
+	var body io.Reader = nil
+	var bodyBytes []byte = nil
+
+	if subscription.jsonBody {
+		b, err := json.Marshal(event)
+
+		if err != nil {
+			return nil, err
+		}
+
+		bodyBytes = b
+		body = bytes.NewReader(b)
+	}
+
+	req, e := http.NewRequest("POST", subscription.url, body)
+
+	if e != nil {
+		return nil, e
+	}
+
+	req.Header.Set("x-event-id", event.Id)
+
+	if subscription.jsonBody {
+		timestamp := fmt.Sprint(time.Now().UnixMilli())
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-event-timestamp", timestamp)
+
+		if subscription.signatureSecret != "" {
+			req.Header.Set("x-event-signature", "sha256="+SignEventBody(subscription.signatureSecret, timestamp, bodyBytes))
 		}
+	} else {
+		req.Header.Set("x-streaming-channel", event.Channel)
+		req.Header.Set("x-streaming-id", event.StreamId)
+		req.Header.Set("x-event-type", event.EventType)
 
-		req.Header.Set("x-streaming-channel", event.channel)
-		req.Header.Set("x-streaming-id", event.streamId)
-		req.Header.Set("x-event-type", "stream-available")
-		req.Header.Set("x-stream-type", event.streamType)
-		req.Header.Set("x-resolution", event.resolution)
-		req.Header.Set("x-index-file", event.indexFile)
+		if event.StreamType != "" {
+			req.Header.Set("x-stream-type", event.StreamType)
+		}
 
-		if event.startTime != "" {
-			req.Header.Set("x-start-time", event.startTime)
+		if event.Resolution != "" {
+			req.Header.Set("x-resolution", event.Resolution)
 		}
 
-		if authorization != "" {
-			req.Header.Set("Authorization", authorization)
+		if event.IndexFile != "" {
+			req.Header.Set("x-index-file", event.IndexFile)
 		}
 
-		LogDebug("Sending stream-available for: " + event.channel + ":" + event.streamId + " / POST: " + eventURL)
+		if event.StartTime != "" {
+			req.Header.Set("x-start-time", event.StartTime)
+		}
 
-		res, e := client.Do(req)
+		if event.UserIP != "" {
+			req.Header.Set("x-user-ip", event.UserIP)
+		}
 
-		if e != nil {
-			LogError(e)
-			time.Sleep(EVENT_SEND_RETRY_DELAY)
-			continue
+		if event.PublishMethod != "" {
+			req.Header.Set("x-publish-method", event.PublishMethod)
 		}
 
-		if res.StatusCode == 200 {
-			sent = true
-		} else {
-			LogDebug("[" + event.channel + ":" + event.streamId + "] [Stream-available] [Error] Could not send event. Status code: " + fmt.Sprint(res.StatusCode))
-			time.Sleep(EVENT_SEND_RETRY_DELAY)
+		if event.DenyReason != "" {
+			req.Header.Set("x-deny-reason", event.DenyReason)
+		}
+
+		if event.CloseReason != "" {
+			req.Header.Set("x-close-reason", event.CloseReason)
+		}
+
+		if event.CloseMessage != "" {
+			req.Header.Set("x-close-message", event.CloseMessage)
+		}
+
+		if event.RemainingTime > 0 {
+			req.Header.Set("x-remaining-time", fmt.Sprint(event.RemainingTime))
+		}
+
+		if event.SourceInfo != nil {
+			event.SourceInfo.SetHeaders(req.Header)
 		}
 	}
 
-	// Remove event from the list
+	if subscription.authorization != "" {
+		req.Header.Set("Authorization", subscription.authorization)
+	}
 
-	channel.mutex.Lock()
-	delete(channel.pendingEvents, event.id)
-	channel.mutex.Unlock()
+	return req, nil
 }
 
-// Sends an stream-closed event
-// Retries until success
-// coordinator - Reference to the coordinator
-// event - Reference to the event
-func SendStreamClosedEvent(coordinator *Streaming_Coordinator, event *PendingStreamClosedEvent) {
-	sent := false
+// Computes the delay before retrying to send an event
+// Exponential backoff, with jitter
+// config - Event retry configuration
+// attempt - Number of failed attempts
+// Returns the delay
+func GetEventRetryDelay(config *EventRetryConfiguration, attempt int) time.Duration {
+	delay := config.retryBaseDelay
 
-	eventURL := os.Getenv("EVENT_CALLBACK_URL")
+	for i := 1; i < attempt && delay < config.retryMaxDelay; i++ {
+		delay = delay * 2
+	}
 
-	if eventURL == "" {
-		LogWarning("No EVENT_CALLBACK_URL set. Ignoring stream-available event.")
-		sent = true
+	if delay > config.retryMaxDelay {
+		delay = config.retryMaxDelay
 	}
 
-	authorization := ""
+	if delay <= 0 {
+		return 0
+	}
 
-	authMethod := strings.ToUpper(os.Getenv("EVENT_CALLBACK_AUTH"))
+	// Random delay between 50% and 100% of the computed delay
+	return delay/2 + time.Duration(mathRand.Int64N(int64(delay/2)+1))
+}
 
-	switch authMethod {
-	case "BASIC":
-		user := os.Getenv("EVENT_CALLBACK_AUTH_USER")
-		password := os.Getenv("EVENT_CALLBACK_PASSWORD")
-		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
-	case "BEARER":
-		token := os.Getenv("EVENT_CALLBACK_AUTH_TOKEN")
-		authorization = "Bearer " + token
-	case "CUSTOM":
-		authorization = os.Getenv("EVENT_CALLBACK_AUTH_CUSTOM")
+// Sends an event to a subscription
+// Retries until success, until cancelled, or until the retry budget is exhausted
+// subscription - The subscription
+// config - Event retry configuration
+// event - The event
+// isCancelled - Function to check if the event got cancelled
+// Returns the result (EVENT_SEND_RESULT_SENT, EVENT_SEND_RESULT_CANCELLED or EVENT_SEND_RESULT_EXPIRED)
+func SendCallbackEvent(subscription *EventSubscription, config *EventRetryConfiguration, event *CallbackEvent, isCancelled func() bool) int {
+	client := &http.Client{
+		Timeout: config.timeout,
 	}
 
-	for !sent && !event.cancelled {
-		client := &http.Client{}
+	attempts := 0
+	startTime := time.Now()
 
-		req, e := http.NewRequest("POST", eventURL, nil)
+	for !isCancelled() {
+		if attempts > 0 {
+			if config.maxAttempts > 0 && attempts >= config.maxAttempts {
+				return EVENT_SEND_RESULT_EXPIRED
+			}
 
-		if e != nil {
-			LogError(e)
-			time.Sleep(EVENT_SEND_RETRY_DELAY)
-			continue
+			if config.maxAge > 0 && time.Since(startTime) > config.maxAge {
+				return EVENT_SEND_RESULT_EXPIRED
+			}
+
+			time.Sleep(GetEventRetryDelay(config, attempts))
+
+			if isCancelled() {
+				break
+			}
 		}
 
-		req.Header.Set("x-streaming-channel", event.channel)
-		req.Header.Set("x-streaming-id", event.streamId)
-		req.Header.Set("x-event-type", "stream-closed")
+		attempts++
+
+		req, e := MakeCallbackEventRequest(subscription, event)
 
-		if authorization != "" {
-			req.Header.Set("Authorization", authorization)
+		if e != nil {
+			LogError(e)
+			continue
 		}
 
-		LogDebug("Sending stream-closed for: " + event.channel + ":" + event.streamId + " / POST: " + eventURL)
+		LogDebug("Sending " + event.EventType + " for: " + event.Channel + ":" + event.StreamId + " / Subscription: " + subscription.id + " / POST: " + subscription.url)
 
 		res, e := client.Do(req)
 
 		if e != nil {
 			LogError(e)
-			time.Sleep(EVENT_SEND_RETRY_DELAY)
+			METRICS.OnEventCallbackRequest(subscription.id, false)
 			continue
 		}
 
+		res.Body.Close()
+
 		if res.StatusCode == 200 {
-			sent = true
-		} else {
-			LogDebug("[" + event.channel + ":" + event.streamId + "] [Stream-closed] [Error] Could not send event. Status code: " + fmt.Sprint(res.StatusCode))
-			time.Sleep(EVENT_SEND_RETRY_DELAY)
+			METRICS.OnEventCallbackRequest(subscription.id, true)
+			return EVENT_SEND_RESULT_SENT
+		}
+
+		METRICS.OnEventCallbackRequest(subscription.id, false)
+
+		LogDebug("[" + event.Channel + ":" + event.StreamId + "] [" + event.EventType + "] [" + subscription.id + "] [Error] Could not send event. Status code: " + fmt.Sprint(res.StatusCode))
+	}
+
+	return EVENT_SEND_RESULT_CANCELLED
+}
+
+// Adds an event to the outbox, creating a delivery for each subscription that wants to receive it
+// event - The event
+// Returns the list of deliveries
+func (coord *Streaming_Coordinator) EnqueueEvent(event *CallbackEvent) []*EventDelivery {
+	deliveries := make([]*EventDelivery, 0)
+
+	for i := 0; i < len(coord.eventSubscriptions); i++ {
+		subscription := coord.eventSubscriptions[i]
+
+		if !subscription.Matches(event) {
+			continue
 		}
+
+		delivery := &EventDelivery{
+			Id:           event.Id + "/" + subscription.id,
+			Subscription: subscription.id,
+			Event:        event,
+		}
+
+		coord.outbox.Add(delivery)
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries
+}
+
+// Sends an event delivery
+// After that, the delivery is removed from the outbox
+// If the delivery expired, it is moved to the dead letter store
+// delivery - The delivery
+// isCancelled - Function to check if the event got cancelled
+func (coord *Streaming_Coordinator) SendEventDelivery(delivery *EventDelivery, isCancelled func() bool) {
+	event := delivery.Event
+	subscription := FindEventSubscription(coord.eventSubscriptions, delivery.Subscription)
+
+	if subscription == nil {
+		LogWarning("[" + event.Channel + ":" + event.StreamId + "] [" + event.EventType + "] Subscription not found: " + delivery.Subscription + ". Moved to the dead letter store. Delivery ID: " + delivery.Id)
+		coord.outbox.MarkDeadLetter(delivery.Id)
+		METRICS.OnEventDeadLetter(delivery.Subscription)
+		return
+	}
+
+	result := SendCallbackEvent(subscription, &coord.eventRetryConfig, event, isCancelled)
+
+	if result == EVENT_SEND_RESULT_EXPIRED {
+		LogWarning("[" + event.Channel + ":" + event.StreamId + "] [" + event.EventType + "] Could not send event. Moved to the dead letter store. Delivery ID: " + delivery.Id)
+		coord.outbox.MarkDeadLetter(delivery.Id)
+		METRICS.OnEventDeadLetter(delivery.Subscription)
+	} else {
+		coord.outbox.MarkDone(delivery.Id)
+	}
+}
+
+// Sends a list of event deliveries in parallel
+// Waits for all of them to finish
+// deliveries - List of deliveries
+// isCancelled - Function to check if the event got cancelled
+func (coord *Streaming_Coordinator) SendEventDeliveries(deliveries []*EventDelivery, isCancelled func() bool) {
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < len(deliveries); i++ {
+		wg.Add(1)
+
+		go func(delivery *EventDelivery) {
+			defer wg.Done()
+			coord.SendEventDelivery(delivery, isCancelled)
+		}(deliveries[i])
+	}
+
+	wg.Wait()
+}
+
+// Sends an event delivery loaded from the outbox
+// It is not associated with any channel, so it cannot be cancelled
+// delivery - The delivery
+func (coord *Streaming_Coordinator) SendOutboxDelivery(delivery *EventDelivery) {
+	coord.SendEventDelivery(delivery, func() bool {
+		return false
+	})
+
+	if delivery.Event.EventType == "stream-closed" {
+		coord.RemoveActiveStream(delivery.Event.Channel, delivery.Event.StreamId)
+	}
+}
+
+// Gets the callback event to send
+func (event *PendingStreamAvailableEvent) CallbackEvent() *CallbackEvent {
+	return &CallbackEvent{
+		Id:         event.eventId,
+		Timestamp:  event.timestamp,
+		EventType:  "stream-available",
+		Channel:    event.channel,
+		StreamId:   event.streamId,
+		StreamType: event.streamType,
+		Resolution: event.resolution,
+		IndexFile:  event.indexFile,
+		StartTime:  event.startTime,
 	}
+}
+
+// Gets the callback event to send
+func (event *PendingStreamClosedEvent) CallbackEvent() *CallbackEvent {
+	return &CallbackEvent{
+		Id:           event.eventId,
+		Timestamp:    event.timestamp,
+		EventType:    "stream-closed",
+		Channel:      event.channel,
+		StreamId:     event.streamId,
+		CloseReason:  event.closeReason.code,
+		CloseMessage: event.closeReason.message,
+	}
+}
+
+// Sends an stream-available event
+// Retries until success or until the retry budget is exhausted
+// coordinator - Reference to the coordinator
+// channel - Reference to the channel
+// event - Reference to the event
+// deliveries - Deliveries of the event (returned by EnqueueEvent)
+func SendStreamAvailableEvent(coordinator *Streaming_Coordinator, channel *StreamingChannel, event *PendingStreamAvailableEvent, deliveries []*EventDelivery) {
+	coordinator.SendEventDeliveries(deliveries, func() bool {
+		return event.cancelled
+	})
+
+	// Remove event from the list
+
+	channel.mutex.Lock()
+	delete(channel.pendingEvents, event.id)
+	channel.mutex.Unlock()
+}
+
+// Sends an stream-closed event
+// Retries until success or until the retry budget is exhausted
+// coordinator - Reference to the coordinator
+// event - Reference to the event
+// deliveries - Deliveries of the event (returned by EnqueueEvent)
+func SendStreamClosedEvent(coordinator *Streaming_Coordinator, event *PendingStreamClosedEvent, deliveries []*EventDelivery) {
+	coordinator.SendEventDeliveries(deliveries, func() bool {
+		return event.cancelled
+	})
 
 	// Call coordinator method to indicate the event being sent
 	coordinator.RemoveActiveStream(event.channel, event.streamId)
@@ -0,0 +1,203 @@
+// Event subscriptions
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+const DEFAULT_EVENT_SUBSCRIPTION_ID = "default"
+
+// Subscription to receive event callbacks
+type EventSubscription struct {
+	id string // Subscription ID
+
+	url string // Callback URL
+
+	authorization string // Value for the Authorization header
+
+	jsonBody bool // True to send the event as a JSON body
+
+	signatureSecret string // Secret to sign the JSON body (HMAC-SHA256)
+
+	eventTypes  []string // List of event types to receive (empty = all)
+	streamTypes []string // List of stream types to receive (empty = all)
+}
+
+// Subscription, as configured in the EVENT_SUBSCRIPTIONS_FILE
+type EventSubscriptionConfig struct {
+	Id  string `json:"id"`  // Subscription ID
+	Url string `json:"url"` // Callback URL
+
+	Auth         string `json:"auth"`         // Auth method: Basic, Bearer or Custom
+	AuthUser     string `json:"authUser"`     // User (Basic)
+	AuthPassword string `json:"authPassword"` // Password (Basic)
+	AuthToken    string `json:"authToken"`    // Token (Bearer)
+	AuthCustom   string `json:"authCustom"`   // Authorization header (Custom)
+
+	Body            string `json:"body"`            // Set to JSON to send the event as a JSON body
+	SignatureSecret string `json:"signatureSecret"` // Secret to sign the JSON body
+
+	EventTypes  []string `json:"eventTypes"`  // List of event types to receive (empty = all)
+	StreamTypes []string `json:"streamTypes"` // List of stream types to receive (empty = all)
+}
+
+// Computes the value of the Authorization header
+// authMethod - Auth method: Basic, Bearer or Custom
+// user - User (Basic)
+// password - Password (Basic)
+// token - Token (Bearer)
+// custom - Authorization header (Custom)
+// Returns the value for the Authorization header
+func makeEventCallbackAuthorization(authMethod string, user string, password string, token string, custom string) string {
+	switch strings.ToUpper(authMethod) {
+	case "BASIC":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	case "BEARER":
+		return "Bearer " + token
+	case "CUSTOM":
+		return custom
+	default:
+		return ""
+	}
+}
+
+// Loads the list of event subscriptions
+// The default subscription is loaded from the EVENT_CALLBACK_* environment variables
+// The rest are loaded from the file set in EVENT_SUBSCRIPTIONS_FILE
+// Returns the list of subscriptions
+func LoadEventSubscriptions() []*EventSubscription {
+	subscriptions := make([]*EventSubscription, 0)
+
+	defaultURL := os.Getenv("EVENT_CALLBACK_URL")
+
+	if defaultURL != "" {
+		subscriptions = append(subscriptions, &EventSubscription{
+			id:              DEFAULT_EVENT_SUBSCRIPTION_ID,
+			url:             defaultURL,
+			authorization:   makeEventCallbackAuthorization(os.Getenv("EVENT_CALLBACK_AUTH"), os.Getenv("EVENT_CALLBACK_AUTH_USER"), os.Getenv("EVENT_CALLBACK_PASSWORD"), os.Getenv("EVENT_CALLBACK_AUTH_TOKEN"), os.Getenv("EVENT_CALLBACK_AUTH_CUSTOM")),
+			jsonBody:        strings.ToUpper(os.Getenv("EVENT_CALLBACK_BODY")) == "JSON",
+			signatureSecret: os.Getenv("EVENT_CALLBACK_SIGNATURE_SECRET"),
+			eventTypes:      make([]string, 0),
+			streamTypes:     make([]string, 0),
+		})
+	}
+
+	subscriptionsFile := os.Getenv("EVENT_SUBSCRIPTIONS_FILE")
+
+	if subscriptionsFile != "" {
+		content, err := os.ReadFile(subscriptionsFile)
+
+		if err != nil {
+			LogError(err)
+			LogWarning("Could not load EVENT_SUBSCRIPTIONS_FILE: " + subscriptionsFile)
+			return subscriptions
+		}
+
+		configList := make([]EventSubscriptionConfig, 0)
+
+		err = json.Unmarshal(content, &configList)
+
+		if err != nil {
+			LogError(err)
+			LogWarning("Could not load EVENT_SUBSCRIPTIONS_FILE: " + subscriptionsFile)
+			return subscriptions
+		}
+
+		for i := 0; i < len(configList); i++ {
+			config := configList[i]
+
+			if config.Id == "" || config.Url == "" {
+				LogWarning("Ignored event subscription without id or url in " + subscriptionsFile)
+				continue
+			}
+
+			if FindEventSubscription(subscriptions, config.Id) != nil {
+				LogWarning("Ignored duplicated event subscription in " + subscriptionsFile + ": " + config.Id)
+				continue
+			}
+
+			eventTypes := config.EventTypes
+
+			if eventTypes == nil {
+				eventTypes = make([]string, 0)
+			}
+
+			streamTypes := config.StreamTypes
+
+			if streamTypes == nil {
+				streamTypes = make([]string, 0)
+			}
+
+			subscriptions = append(subscriptions, &EventSubscription{
+				id:              config.Id,
+				url:             config.Url,
+				authorization:   makeEventCallbackAuthorization(config.Auth, config.AuthUser, config.AuthPassword, config.AuthToken, config.AuthCustom),
+				jsonBody:        strings.ToUpper(config.Body) == "JSON",
+				signatureSecret: config.SignatureSecret,
+				eventTypes:      eventTypes,
+				streamTypes:     streamTypes,
+			})
+		}
+	}
+
+	if len(subscriptions) == 0 {
+		LogWarning("No EVENT_CALLBACK_URL or EVENT_SUBSCRIPTIONS_FILE set. Events will be ignored.")
+	}
+
+	return subscriptions
+}
+
+// Finds a subscription by its ID
+// subscriptions - List of subscriptions
+// id - Subscription ID
+// Returns the subscription, or nil if not found
+func FindEventSubscription(subscriptions []*EventSubscription, id string) *EventSubscription {
+	for i := 0; i < len(subscriptions); i++ {
+		if subscriptions[i].id == id {
+			return subscriptions[i]
+		}
+	}
+
+	return nil
+}
+
+// Checks if the subscription wants to receive an event
+// event - The event
+// Returns true if the event matches the filters of the subscription
+func (subscription *EventSubscription) Matches(event *CallbackEvent) bool {
+	if len(subscription.eventTypes) > 0 {
+		found := false
+
+		for i := 0; i < len(subscription.eventTypes); i++ {
+			if strings.EqualFold(subscription.eventTypes[i], event.EventType) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if len(subscription.streamTypes) > 0 {
+		found := false
+
+		for i := 0; i < len(subscription.streamTypes); i++ {
+			if strings.EqualFold(subscription.streamTypes[i], event.StreamType) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
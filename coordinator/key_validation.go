@@ -4,12 +4,23 @@ package main
 
 import (
 	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
+const KEY_VERIFICATION_DEFAULT_TIMEOUT = 10 // Default timeout for the key verification requests (seconds)
+
+const KEY_VERIFICATION_MAX_BODY_SIZE = 1024 * 1024 // Max size of the key verification response body (bytes)
+
 // Validates a stream key
+// The result is taken from the cache if available
+// If the verification API fails, the key is considered valid only if KEY_VERIFICATION_FAIL_OPEN is set to YES
 // channel - The channel
 // key - The stream key
 // userIP - IP of the publisher
@@ -19,12 +30,44 @@ import (
 //	resolutionList - List of allowed resolutions
 //	record - True if recording is enabled
 //	previewsConfig - Previews configuration
-func ValidateStreamKey(channel string, key string, userIP string) (valid bool, resolutionList ResolutionList, record bool, previewsConfig PreviewsConfiguration) {
+//	config - Extra configuration of the stream
+func (coord *Streaming_Coordinator) ValidateStreamKey(channel string, key string, userIP string) (valid bool, resolutionList ResolutionList, record bool, previewsConfig PreviewsConfiguration, config StreamConfiguration) {
+	cachedResult, found := coord.keyValidationCache.Get(channel, key)
+
+	if found {
+		METRICS.OnKeyVerificationCacheHit(cachedResult.valid)
+		return cachedResult.valid, cachedResult.resolutionList, cachedResult.record, cachedResult.previewsConfig, cachedResult.config
+	}
+
+	result, err := RequestStreamKeyValidation(channel, key, userIP)
+
+	if err != nil {
+		LogError(err)
+
+		if os.Getenv("KEY_VERIFICATION_FAIL_OPEN") == "YES" {
+			LogWarning("Key was considered valid for channel " + channel + ", since the verification failed and KEY_VERIFICATION_FAIL_OPEN is set")
+			return true, ResolutionList{hasOriginal: true, resolutions: make([]Resolution, 0)}, false, PreviewsConfiguration{enabled: false}, StreamConfiguration{}
+		}
+
+		return false, ResolutionList{}, false, PreviewsConfiguration{}, StreamConfiguration{}
+	}
+
+	coord.keyValidationCache.Set(channel, key, result)
+
+	return result.valid, result.resolutionList, result.record, result.previewsConfig, result.config
+}
+
+// Sends a request to the key verification API (KEY_VERIFICATION_URL)
+// channel - The channel
+// key - The stream key
+// userIP - IP of the publisher
+// Returns the validation result, or an error if the API could not be reached or failed (status 5xx)
+func RequestStreamKeyValidation(channel string, key string, userIP string) (KeyValidationResult, error) {
 	verificationURL := os.Getenv("KEY_VERIFICATION_URL")
 
 	if verificationURL == "" {
 		LogWarning("Key was considered valid by default, since KEY_VERIFICATION_URL is missing")
-		return true, ResolutionList{hasOriginal: true, resolutions: make([]Resolution, 0)}, false, PreviewsConfiguration{enabled: false}
+		return KeyValidationResult{valid: true, resolutionList: ResolutionList{hasOriginal: true, resolutions: make([]Resolution, 0)}, record: false, previewsConfig: PreviewsConfiguration{enabled: false}}, nil
 	}
 
 	authorization := ""
@@ -43,13 +86,14 @@ func ValidateStreamKey(channel string, key string, userIP string) (valid bool, r
 		authorization = os.Getenv("KEY_VERIFICATION_AUTH_CUSTOM")
 	}
 
-	client := &http.Client{}
+	client := &http.Client{
+		Timeout: time.Duration(getEnvInt("KEY_VERIFICATION_TIMEOUT_SECONDS", KEY_VERIFICATION_DEFAULT_TIMEOUT)) * time.Second,
+	}
 
 	req, e := http.NewRequest("POST", verificationURL, nil)
 
 	if e != nil {
-		LogError(e)
-		return false, ResolutionList{}, false, PreviewsConfiguration{}
+		return KeyValidationResult{}, e
 	}
 
 	req.Header.Set("x-streaming-channel", channel)
@@ -62,16 +106,77 @@ func ValidateStreamKey(channel string, key string, userIP string) (valid bool, r
 
 	LogDebug("Validating stream key for channel: " + channel + " / POST: " + verificationURL)
 
+	startTime := time.Now()
+
 	res, e := client.Do(req)
 
 	if e != nil {
-		LogError(e)
-		return false, ResolutionList{}, false, PreviewsConfiguration{}
+		METRICS.OnKeyVerification("error", time.Since(startTime))
+		return KeyValidationResult{}, e
 	}
 
+	defer res.Body.Close()
+
 	if res.StatusCode == 200 {
-		return true, DecodeResolutionsList(res.Header.Get("x-resolutions")), strings.ToLower(res.Header.Get("x-record")) == "true", DecodePreviewsConfiguration(res.Header.Get("x-previews"), ",")
+		METRICS.OnKeyVerification("valid", time.Since(startTime))
+		return ParseKeyVerificationResponse(channel, res), nil
+	} else if res.StatusCode >= 500 {
+		METRICS.OnKeyVerification("error", time.Since(startTime))
+		return KeyValidationResult{}, errors.New("key verification API responded with status " + fmt.Sprint(res.StatusCode))
 	} else {
-		return false, ResolutionList{}, false, PreviewsConfiguration{}
+		METRICS.OnKeyVerification("invalid", time.Since(startTime))
+		return KeyValidationResult{valid: false}, nil
+	}
+}
+
+// Parses a successful response of the key verification API
+// The capabilities are taken from the x-resolutions, x-record and x-previews headers
+// If the response has a JSON body, its fields override the headers
+// channel - The channel
+// res - The response
+// Returns the validation result
+func ParseKeyVerificationResponse(channel string, res *http.Response) KeyValidationResult {
+	result := KeyValidationResult{
+		valid:          true,
+		resolutionList: DecodeResolutionsList(res.Header.Get("x-resolutions")),
+		record:         strings.ToLower(res.Header.Get("x-record")) == "true",
+		previewsConfig: DecodePreviewsConfiguration(res.Header.Get("x-previews"), ","),
+	}
+
+	if !strings.HasPrefix(strings.ToLower(res.Header.Get("Content-Type")), "application/json") {
+		return result
+	}
+
+	content, err := io.ReadAll(io.LimitReader(res.Body, KEY_VERIFICATION_MAX_BODY_SIZE))
+
+	if err != nil {
+		LogError(err)
+		return result
+	}
+
+	body := KeyVerificationResponseBody{}
+
+	err = json.Unmarshal(content, &body)
+
+	if err != nil {
+		LogWarning("Ignored invalid key verification response body for channel " + channel + ": " + err.Error())
+		return result
+	}
+
+	if body.Resolutions != nil {
+		result.resolutionList = DecodeResolutionsList(*body.Resolutions)
+	}
+
+	if body.Record != nil {
+		result.record = *body.Record
+	}
+
+	if body.Previews != nil {
+		result.previewsConfig = DecodePreviewsConfiguration(*body.Previews, ",")
 	}
+
+	result.config = body.StreamConfiguration
+	result.config.Sanitize(channel)
+
+	return result
 }
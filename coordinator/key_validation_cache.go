@@ -0,0 +1,148 @@
+// Cache for the key validation results
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const KEY_VALIDATION_CACHE_DEFAULT_MAX_SIZE = 10000 // Default max number of cached results
+
+// Result of a key validation
+type KeyValidationResult struct {
+	valid          bool                  // True only if the key is valid
+	resolutionList ResolutionList        // List of allowed resolutions
+	record         bool                  // True if recording is enabled
+	previewsConfig PreviewsConfiguration // Previews configuration
+	config         StreamConfiguration   // Extra configuration of the stream
+}
+
+// Cached key validation result
+type KeyValidationCacheEntry struct {
+	result  KeyValidationResult // The result
+	expires int64               // Unix timestamp (milliseconds) when the entry expires
+}
+
+// Cache for the key validation results, to prevent calling the verification API on every publish request
+type KeyValidationCache struct {
+	mutex *sync.Mutex // Mutex to access the data
+
+	entries map[string]*KeyValidationCacheEntry // Cached results. Map: channel:key -> Entry
+
+	ttl         time.Duration // Time to keep the valid results (0 = disabled)
+	negativeTTL time.Duration // Time to keep the invalid results (0 = disabled)
+	maxSize     int           // Max number of cached results
+}
+
+// Creates the key validation cache, loading the configuration from the environment variables
+// Returns a reference to the cache
+func CreateKeyValidationCache() *KeyValidationCache {
+	return &KeyValidationCache{
+		mutex:       &sync.Mutex{},
+		entries:     make(map[string]*KeyValidationCacheEntry),
+		ttl:         time.Duration(getEnvInt("KEY_VERIFICATION_CACHE_TTL_SECONDS", 0)) * time.Second,
+		negativeTTL: time.Duration(getEnvInt("KEY_VERIFICATION_CACHE_NEGATIVE_TTL_SECONDS", 0)) * time.Second,
+		maxSize:     getEnvInt("KEY_VERIFICATION_CACHE_MAX_SIZE", KEY_VALIDATION_CACHE_DEFAULT_MAX_SIZE),
+	}
+}
+
+// Gets a cached result
+// channel - The channel
+// key - The stream key
+// Returns:
+//
+//	result - The cached result
+//	found - True if there was a result in the cache, and it did not expire
+func (cache *KeyValidationCache) Get(channel string, key string) (result KeyValidationResult, found bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	id := channel + ":" + key
+
+	entry := cache.entries[id]
+
+	if entry == nil {
+		return KeyValidationResult{}, false
+	}
+
+	if entry.expires <= time.Now().UnixMilli() {
+		delete(cache.entries, id)
+		return KeyValidationResult{}, false
+	}
+
+	return entry.result, true
+}
+
+// Adds a result to the cache
+// channel - The channel
+// key - The stream key
+// result - The result
+func (cache *KeyValidationCache) Set(channel string, key string, result KeyValidationResult) {
+	ttl := cache.ttl
+
+	if !result.valid {
+		ttl = cache.negativeTTL
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	now := time.Now().UnixMilli()
+
+	if len(cache.entries) >= cache.maxSize {
+		// Remove the expired entries to make room
+		for id, entry := range cache.entries {
+			if entry.expires <= now {
+				delete(cache.entries, id)
+			}
+		}
+
+		if len(cache.entries) >= cache.maxSize {
+			return
+		}
+	}
+
+	cache.entries[channel+":"+key] = &KeyValidationCacheEntry{
+		result:  result,
+		expires: now + ttl.Milliseconds(),
+	}
+}
+
+// Removes cached results
+// channel - The channel
+// key - The stream key. If empty, the results for every key of the channel are removed
+// Returns the number of removed results
+func (cache *KeyValidationCache) Invalidate(channel string, key string) int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if key != "" {
+		id := channel + ":" + key
+
+		if cache.entries[id] == nil {
+			return 0
+		}
+
+		delete(cache.entries, id)
+
+		return 1
+	}
+
+	removed := 0
+	prefix := channel + ":"
+
+	for id := range cache.entries {
+		if strings.HasPrefix(id, prefix) {
+			delete(cache.entries, id)
+			removed++
+		}
+	}
+
+	return removed
+}
@@ -0,0 +1,118 @@
+// Leader election (active/standby coordinator nodes)
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const LEADER_LEASE_DEFAULT_DURATION = 15 // Default duration of the leadership lease (seconds)
+
+// Gets the ID of the coordinator node from the NODE_ID environment variable
+// By default, the host name is used, with a random suffix
+// Returns the node ID
+func GetNodeId() string {
+	nodeId := os.Getenv("NODE_ID")
+
+	if nodeId != "" {
+		return nodeId
+	}
+
+	hostname, err := os.Hostname()
+
+	if err != nil {
+		hostname = "coordinator"
+	}
+
+	suffix := make([]byte, 4)
+
+	_, err = rand.Read(suffix)
+
+	if err != nil {
+		LogError(err)
+	}
+
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// Checks if this node is the leader
+// Returns true if the node is the leader (handles the sessions)
+func (server *Streaming_Coordinator_Server) IsLeader() bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return server.leader
+}
+
+// Call when this node becomes the leader
+// Loads the state from the store and starts handling the sessions
+func (server *Streaming_Coordinator_Server) BecomeLeader() {
+	server.coordinator.Initialize(server.store)
+
+	server.mutex.Lock()
+	server.leader = true
+	server.mutex.Unlock()
+
+	go server.RunRestartRecovery()
+	go server.RunAutoscaler()
+}
+
+// Periodically tries to acquire the leadership, or renew it
+// If the leadership is lost, the process exits, so the sessions reconnect to the new leader
+func (server *Streaming_Coordinator_Server) RunLeaderElection() {
+	go server.releaseLeadershipOnExit()
+
+	lastRenewal := time.Time{}
+
+	for {
+		acquired, err := server.store.AcquireLeadership(server.nodeId, server.leaderLease)
+
+		if err != nil {
+			LogError(err)
+		}
+
+		if server.IsLeader() {
+			if acquired {
+				lastRenewal = time.Now()
+			} else if time.Since(lastRenewal) >= server.leaderLease*2/3 {
+				// Exit before the lease expires, so there are never two leaders
+				LogErrorMessage("[HA] Could not renew the leadership. Exiting, so the sessions reconnect to the new leader.")
+				os.Exit(1)
+			}
+		} else if acquired {
+			lastRenewal = time.Now()
+
+			LogInfo("[HA] Node " + server.nodeId + " is now the leader")
+
+			server.BecomeLeader()
+		}
+
+		time.Sleep(server.leaderLease / 3)
+	}
+}
+
+// Waits for the process to be stopped, releasing the leadership
+// This way, a standby node can take over without waiting for the lease to expire
+func (server *Streaming_Coordinator_Server) releaseLeadershipOnExit() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	<-signals
+
+	if server.IsLeader() {
+		err := server.store.ReleaseLeadership(server.nodeId)
+
+		if err != nil {
+			LogError(err)
+		} else {
+			LogInfo("[HA] Released the leadership")
+		}
+	}
+
+	os.Exit(0)
+}
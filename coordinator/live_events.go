@@ -0,0 +1,190 @@
+// Live events (real time stream of the cluster events)
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const LIVE_EVENTS_DEFAULT_BUFFER_SIZE = 1000 // Default number of events kept to allow resuming
+
+const LIVE_EVENTS_SUBSCRIBER_QUEUE_SIZE = 256 // Max number of events queued for each subscriber
+
+// Live event types
+const (
+	LIVE_EVENT_PUBLISH_REQUEST         = "publish-request"
+	LIVE_EVENT_PUBLISH_ACCEPTED        = "publish-accepted"
+	LIVE_EVENT_PUBLISH_DENIED          = "publish-denied"
+	LIVE_EVENT_PUBLISH_QUEUED          = "publish-queued"
+	LIVE_EVENT_ENCODE_START            = "encode-start"
+	LIVE_EVENT_STREAM_INFO             = "stream-info"
+	LIVE_EVENT_STREAM_AVAILABLE        = "stream-available"
+	LIVE_EVENT_STREAM_CLOSED           = "stream-closed"
+	LIVE_EVENT_STREAM_FAILED           = "stream-failed"
+	LIVE_EVENT_STREAM_INTERRUPTED      = "stream-interrupted"
+	LIVE_EVENT_STREAM_DURATION_WARNING = "stream-duration-warning"
+	LIVE_EVENT_BACKUP_SWITCH           = "backup-switch"
+	LIVE_EVENT_ENCODER_REGISTER        = "encoder-register"
+	LIVE_EVENT_ENCODER_DEREGISTER      = "encoder-deregister"
+	LIVE_EVENT_ENCODER_DRAIN           = "encoder-drain"
+	LIVE_EVENT_ENCODER_DRAIN_CANCEL    = "encoder-drain-cancel"
+	LIVE_EVENT_SERVER_REGISTER         = "server-register"
+	LIVE_EVENT_SERVER_DEREGISTER       = "server-deregister"
+	LIVE_EVENT_SERVER_DRAIN            = "server-drain"
+	LIVE_EVENT_SERVER_DRAIN_CANCEL     = "server-drain-cancel"
+	LIVE_EVENT_EVENTS_LOST             = "events-lost"
+)
+
+// Event of the live events stream
+type LiveEvent struct {
+	Sequence  uint64 `json:"seq"`       // Sequence number
+	Timestamp int64  `json:"timestamp"` // Unix timestamp (milliseconds)
+	EventType string `json:"eventType"` // Event type
+
+	Channel  string `json:"channel,omitempty"`  // Channel ID
+	StreamId string `json:"streamId,omitempty"` // Stream ID
+
+	ServerId   uint64 `json:"serverId,omitempty"`   // ID of the streaming server
+	ServerType string `json:"serverType,omitempty"` // Type of streaming server (RTMP or WS)
+	EncoderId  uint64 `json:"encoderId,omitempty"`  // ID of the HLS encoder
+
+	IP       string `json:"ip,omitempty"`       // IP address (publisher IP or server IP)
+	Port     int    `json:"port,omitempty"`     // Server port
+	Capacity int    `json:"capacity,omitempty"` // Encoder capacity
+
+	StreamType string `json:"streamType,omitempty"` // Stream type: HLS-LIVE, HLS-VOD, IMG-PREVIEW
+	Resolution string `json:"resolution,omitempty"` // Resolution
+	IndexFile  string `json:"indexFile,omitempty"`  // The index file path
+	StartTime  string `json:"startTime,omitempty"`  // Start time (seconds)
+
+	Reason  string `json:"reason,omitempty"`  // Reason (for publish-denied, stream-closed, stream-failed and backup-switch)
+	Message string `json:"message,omitempty"` // Message explaining the reason (for stream-closed and stream-failed)
+
+	RemainingTime int64 `json:"remainingTime,omitempty"` // Remaining time until the stream is closed, in seconds (for stream-duration-warning)
+
+	SourceInfo *StreamSourceInfo `json:"sourceInfo,omitempty"` // Information of the source stream (for stream-info)
+}
+
+// Subscriber of the live events
+type LiveEventsSubscriber struct {
+	id uint64 // Subscriber ID
+
+	events chan *LiveEvent // Channel to receive the events. Closed if the subscriber is too slow
+}
+
+// Broadcasts the live events, keeping the most recent ones to allow resuming
+type LiveEventsBus struct {
+	mutex *sync.Mutex // Mutex to access the data
+
+	nextSequence uint64 // Sequence number for the next event
+
+	buffer     []*LiveEvent // Most recent events, in order
+	bufferSize int          // Max number of events to keep
+
+	nextSubscriberId uint64                           // ID for the next subscriber
+	subscribers      map[uint64]*LiveEventsSubscriber // Subscribers
+}
+
+// Creates the live events bus
+// Returns a reference to the bus
+func CreateLiveEventsBus() *LiveEventsBus {
+	return &LiveEventsBus{
+		mutex:            &sync.Mutex{},
+		nextSequence:     1,
+		buffer:           make([]*LiveEvent, 0),
+		bufferSize:       getEnvInt("LIVE_EVENTS_BUFFER_SIZE", LIVE_EVENTS_DEFAULT_BUFFER_SIZE),
+		nextSubscriberId: 0,
+		subscribers:      make(map[uint64]*LiveEventsSubscriber),
+	}
+}
+
+// Publishes an event
+// The sequence number and the timestamp are set by this method
+// event - The event
+func (bus *LiveEventsBus) Publish(event *LiveEvent) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	event.Sequence = bus.nextSequence
+	event.Timestamp = time.Now().UnixMilli()
+
+	bus.nextSequence++
+
+	if bus.bufferSize > 0 {
+		bus.buffer = append(bus.buffer, event)
+
+		if len(bus.buffer) > bus.bufferSize {
+			bus.buffer = bus.buffer[len(bus.buffer)-bus.bufferSize:]
+		}
+	}
+
+	for id, subscriber := range bus.subscribers {
+		select {
+		case subscriber.events <- event:
+		default:
+			// Too slow, drop it. It can resume later.
+			close(subscriber.events)
+			delete(bus.subscribers, id)
+		}
+	}
+}
+
+// Subscribes to the live events
+// lastSequence - Sequence number of the last event received by the client (0 to receive only new events)
+// Returns:
+//
+//	subscriber - The subscriber
+//	missed - Events after lastSequence, kept in the buffer
+//	lost - True if some events after lastSequence are no longer in the buffer
+func (bus *LiveEventsBus) Subscribe(lastSequence uint64) (subscriber *LiveEventsSubscriber, missed []*LiveEvent, lost bool) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	missed = make([]*LiveEvent, 0)
+	lost = false
+
+	if lastSequence >= bus.nextSequence {
+		// Sequence number from a previous run of the coordinator
+		lost = true
+
+		for i := 0; i < len(bus.buffer); i++ {
+			missed = append(missed, bus.buffer[i])
+		}
+	} else if lastSequence > 0 && lastSequence+1 < bus.nextSequence {
+		if len(bus.buffer) == 0 || bus.buffer[0].Sequence > lastSequence+1 {
+			lost = true
+		}
+
+		for i := 0; i < len(bus.buffer); i++ {
+			if bus.buffer[i].Sequence > lastSequence {
+				missed = append(missed, bus.buffer[i])
+			}
+		}
+	}
+
+	bus.nextSubscriberId++
+
+	subscriber = &LiveEventsSubscriber{
+		id:     bus.nextSubscriberId,
+		events: make(chan *LiveEvent, LIVE_EVENTS_SUBSCRIBER_QUEUE_SIZE),
+	}
+
+	bus.subscribers[subscriber.id] = subscriber
+
+	return subscriber, missed, lost
+}
+
+// Removes a subscriber
+// subscriber - The subscriber
+func (bus *LiveEventsBus) Unsubscribe(subscriber *LiveEventsSubscriber) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	if bus.subscribers[subscriber.id] == nil {
+		return // Already removed
+	}
+
+	close(subscriber.events)
+	delete(bus.subscribers, subscriber.id)
+}
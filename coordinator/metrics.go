@@ -0,0 +1,338 @@
+// Metrics (Prometheus text format)
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Buckets for the key verification latency histogram (seconds)
+var KEY_VERIFICATION_LATENCY_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram metric
+type MetricsHistogram struct {
+	buckets []uint64 // Count for each bucket (same index as the bounds)
+	sum     float64  // Sum of the observed values
+	count   uint64   // Number of observed values
+}
+
+// Coordinator metrics
+type Coordinator_Metrics struct {
+	mutex *sync.Mutex // Mutex to access the data
+
+	publishRequests map[string]uint64 // Publish requests. Map: result + reason -> count
+
+	keyVerifications map[string]*MetricsHistogram // Key verification latency. Map: result -> histogram
+	keyCacheHits     map[string]uint64            // Key validations resolved from the cache. Map: result -> count
+
+	eventCallbackRequests map[string]uint64 // Event callback requests. Map: subscription + result -> count
+	eventDeadLetters      map[string]uint64 // Event deliveries moved to the dead letter store. Map: subscription -> count
+
+	sessionConnects    map[string]uint64 // Control session connections. Map: type -> count
+	sessionDisconnects map[string]uint64 // Control session disconnections. Map: type -> count
+
+	encoderFailovers map[string]uint64 // Streams moved to another encoder. Map: result -> count
+}
+
+// Global metrics
+var METRICS = CreateCoordinatorMetrics()
+
+// Creates the metrics
+// Returns a reference to the metrics
+func CreateCoordinatorMetrics() *Coordinator_Metrics {
+	return &Coordinator_Metrics{
+		mutex:                 &sync.Mutex{},
+		publishRequests:       make(map[string]uint64),
+		keyVerifications:      make(map[string]*MetricsHistogram),
+		keyCacheHits:          make(map[string]uint64),
+		eventCallbackRequests: make(map[string]uint64),
+		eventDeadLetters:      make(map[string]uint64),
+		sessionConnects:       make(map[string]uint64),
+		sessionDisconnects:    make(map[string]uint64),
+		encoderFailovers:      make(map[string]uint64),
+	}
+}
+
+// Encodes labels for the metrics text format
+// labels - List of label names and values (name1, value1, name2, value2, ...)
+// Returns the encoded labels, including the braces
+func encodeMetricLabels(labels ...string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0)
+
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.ReplaceAll(labels[i+1], "\\", "\\\\")
+		value = strings.ReplaceAll(value, "\"", "\\\"")
+		value = strings.ReplaceAll(value, "\n", "\\n")
+
+		parts = append(parts, labels[i]+"=\""+value+"\"")
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Gets the keys of a map, sorted
+// m - The map
+// Returns the sorted keys
+func sortedMetricKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Registers a publish request
+// accepted - True if the request was accepted
+// reason - Reason to deny the request (if not accepted)
+func (metrics *Coordinator_Metrics) OnPublishRequest(accepted bool, reason string) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	if accepted {
+		metrics.publishRequests[encodeMetricLabels("result", "accepted")]++
+	} else {
+		metrics.publishRequests[encodeMetricLabels("result", "denied", "reason", reason)]++
+	}
+}
+
+// Registers a key verification request
+// result - Result of the verification (valid, invalid or error)
+// duration - Time it took
+func (metrics *Coordinator_Metrics) OnKeyVerification(result string, duration time.Duration) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	histogram := metrics.keyVerifications[result]
+
+	if histogram == nil {
+		histogram = &MetricsHistogram{
+			buckets: make([]uint64, len(KEY_VERIFICATION_LATENCY_BUCKETS)),
+		}
+		metrics.keyVerifications[result] = histogram
+	}
+
+	seconds := duration.Seconds()
+
+	for i := 0; i < len(KEY_VERIFICATION_LATENCY_BUCKETS); i++ {
+		if seconds <= KEY_VERIFICATION_LATENCY_BUCKETS[i] {
+			histogram.buckets[i]++
+		}
+	}
+
+	histogram.sum += seconds
+	histogram.count++
+}
+
+// Registers a key validation resolved from the cache
+// valid - True if the cached result was valid
+func (metrics *Coordinator_Metrics) OnKeyVerificationCacheHit(valid bool) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	if valid {
+		metrics.keyCacheHits[encodeMetricLabels("result", "valid")]++
+	} else {
+		metrics.keyCacheHits[encodeMetricLabels("result", "invalid")]++
+	}
+}
+
+// Registers an event callback request
+// subscription - Subscription ID
+// success - True if the application accepted the event
+func (metrics *Coordinator_Metrics) OnEventCallbackRequest(subscription string, success bool) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	if success {
+		metrics.eventCallbackRequests[encodeMetricLabels("subscription", subscription, "result", "success")]++
+	} else {
+		metrics.eventCallbackRequests[encodeMetricLabels("subscription", subscription, "result", "failure")]++
+	}
+}
+
+// Registers an event delivery moved to the dead letter store
+// subscription - Subscription ID
+func (metrics *Coordinator_Metrics) OnEventDeadLetter(subscription string) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.eventDeadLetters[encodeMetricLabels("subscription", subscription)]++
+}
+
+// Registers a control session connection
+// sessionType - Type of control session
+func (metrics *Coordinator_Metrics) OnSessionConnect(sessionType int) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.sessionConnects[encodeMetricLabels("type", GetSessionTypeName(sessionType))]++
+}
+
+// Registers a control session disconnection
+// sessionType - Type of control session
+func (metrics *Coordinator_Metrics) OnSessionDisconnect(sessionType int) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.sessionDisconnects[encodeMetricLabels("type", GetSessionTypeName(sessionType))]++
+}
+
+// Registers an attempt to move a stream to another encoder
+// success - True if the stream was moved, false if it had to be closed
+func (metrics *Coordinator_Metrics) OnEncoderFailover(success bool) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	if success {
+		metrics.encoderFailovers[encodeMetricLabels("result", "success")]++
+	} else {
+		metrics.encoderFailovers[encodeMetricLabels("result", "failure")]++
+	}
+}
+
+// Writes a metric header
+// sb - String builder
+// name - Metric name
+// metricType - Metric type (counter, gauge, histogram)
+// help - Metric description
+func writeMetricHeader(sb *strings.Builder, name string, metricType string, help string) {
+	sb.WriteString("# HELP " + name + " " + help + "\n")
+	sb.WriteString("# TYPE " + name + " " + metricType + "\n")
+}
+
+// Writes a metric value
+// sb - String builder
+// name - Metric name
+// labels - Encoded labels
+// value - Metric value
+func writeMetricValue(sb *strings.Builder, name string, labels string, value any) {
+	sb.WriteString(name + labels + " " + fmt.Sprint(value) + "\n")
+}
+
+// Writes a counter metric, with one value per label set
+// sb - String builder
+// name - Metric name
+// help - Metric description
+// values - Map: encoded labels -> value
+func writeMetricCounter(sb *strings.Builder, name string, help string, values map[string]uint64) {
+	writeMetricHeader(sb, name, "counter", help)
+
+	keys := sortedMetricKeys(values)
+
+	for i := 0; i < len(keys); i++ {
+		writeMetricValue(sb, name, keys[i], values[keys[i]])
+	}
+}
+
+// Serializes the metrics collected by the coordinator
+// sb - String builder
+func (metrics *Coordinator_Metrics) Serialize(sb *strings.Builder) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	writeMetricCounter(sb, "coordinator_publish_requests_total", "Number of publish requests, by result and reason.", metrics.publishRequests)
+
+	writeMetricHeader(sb, "coordinator_key_verification_duration_seconds", "histogram", "Latency of the key verification requests, by result.")
+
+	results := sortedMetricKeys(metrics.keyVerifications)
+
+	for i := 0; i < len(results); i++ {
+		histogram := metrics.keyVerifications[results[i]]
+
+		for j := 0; j < len(KEY_VERIFICATION_LATENCY_BUCKETS); j++ {
+			writeMetricValue(sb, "coordinator_key_verification_duration_seconds_bucket", encodeMetricLabels("result", results[i], "le", fmt.Sprint(KEY_VERIFICATION_LATENCY_BUCKETS[j])), histogram.buckets[j])
+		}
+
+		writeMetricValue(sb, "coordinator_key_verification_duration_seconds_bucket", encodeMetricLabels("result", results[i], "le", "+Inf"), histogram.count)
+		writeMetricValue(sb, "coordinator_key_verification_duration_seconds_sum", encodeMetricLabels("result", results[i]), histogram.sum)
+		writeMetricValue(sb, "coordinator_key_verification_duration_seconds_count", encodeMetricLabels("result", results[i]), histogram.count)
+	}
+
+	writeMetricCounter(sb, "coordinator_key_verification_cache_hits_total", "Number of key validations resolved from the cache, by result.", metrics.keyCacheHits)
+
+	writeMetricCounter(sb, "coordinator_event_callback_requests_total", "Number of event callback requests, by subscription and result.", metrics.eventCallbackRequests)
+	writeMetricCounter(sb, "coordinator_event_dead_letters_total", "Number of event deliveries moved to the dead letter store, by subscription.", metrics.eventDeadLetters)
+
+	writeMetricCounter(sb, "coordinator_control_session_connects_total", "Number of control session connections, by type.", metrics.sessionConnects)
+	writeMetricCounter(sb, "coordinator_control_session_disconnects_total", "Number of control session disconnections, by type.", metrics.sessionDisconnects)
+
+	writeMetricCounter(sb, "coordinator_encoder_failovers_total", "Number of streams moved to another encoder after their encoder disconnected, by result.", metrics.encoderFailovers)
+}
+
+// Serializes the current status of the coordinator as metrics
+// sb - String builder
+func (coord *Streaming_Coordinator) SerializeMetrics(sb *strings.Builder) {
+	coord.mutex.Lock()
+
+	encoderIds := make([]uint64, 0, len(coord.hlsEncoders))
+
+	for id := range coord.hlsEncoders {
+		encoderIds = append(encoderIds, id)
+	}
+
+	sort.Slice(encoderIds, func(i, j int) bool {
+		return encoderIds[i] < encoderIds[j]
+	})
+
+	writeMetricHeader(sb, "coordinator_encoders", "gauge", "Number of registered HLS encoders.")
+	writeMetricValue(sb, "coordinator_encoders", "", len(coord.hlsEncoders))
+
+	writeMetricHeader(sb, "coordinator_encoder_capacity", "gauge", "Capacity of each HLS encoder (-1 means infinite).")
+
+	for i := 0; i < len(encoderIds); i++ {
+		writeMetricValue(sb, "coordinator_encoder_capacity", encodeMetricLabels("encoder", fmt.Sprint(encoderIds[i])), coord.hlsEncoders[encoderIds[i]].capacity)
+	}
+
+	writeMetricHeader(sb, "coordinator_encoder_load", "gauge", "Number of streams being handled by each HLS encoder.")
+
+	for i := 0; i < len(encoderIds); i++ {
+		writeMetricValue(sb, "coordinator_encoder_load", encodeMetricLabels("encoder", fmt.Sprint(encoderIds[i])), coord.hlsEncoders[encoderIds[i]].load)
+	}
+
+	writeMetricHeader(sb, "coordinator_encoder_cpu_usage", "gauge", "CPU usage (percentage) reported by each HLS encoder.")
+
+	for i := 0; i < len(encoderIds); i++ {
+		stats := coord.hlsEncoders[encoderIds[i]].stats
+
+		if stats != nil && stats.cpuUsage >= 0 {
+			writeMetricValue(sb, "coordinator_encoder_cpu_usage", encodeMetricLabels("encoder", fmt.Sprint(encoderIds[i])), stats.cpuUsage)
+		}
+	}
+
+	writeMetricHeader(sb, "coordinator_encoder_memory_used_bytes", "gauge", "Used memory reported by each HLS encoder.")
+
+	for i := 0; i < len(encoderIds); i++ {
+		stats := coord.hlsEncoders[encoderIds[i]].stats
+
+		if stats != nil && stats.memoryTotal > 0 {
+			writeMetricValue(sb, "coordinator_encoder_memory_used_bytes", encodeMetricLabels("encoder", fmt.Sprint(encoderIds[i])), stats.memoryUsed)
+		}
+	}
+
+	writeMetricHeader(sb, "coordinator_streaming_servers", "gauge", "Number of connected streaming servers, by type.")
+	writeMetricValue(sb, "coordinator_streaming_servers", encodeMetricLabels("type", "RTMP"), len(coord.rtmpServers))
+	writeMetricValue(sb, "coordinator_streaming_servers", encodeMetricLabels("type", "WS"), len(coord.wssServers))
+
+	writeMetricHeader(sb, "coordinator_active_streams", "gauge", "Number of active streams.")
+	writeMetricValue(sb, "coordinator_active_streams", "", len(coord.activeStreams))
+
+	coord.mutex.Unlock()
+
+	writeMetricHeader(sb, "coordinator_pending_event_deliveries", "gauge", "Number of event deliveries pending of being completed.")
+	writeMetricValue(sb, "coordinator_pending_event_deliveries", "", len(coord.outbox.GetPending()))
+
+	writeMetricHeader(sb, "coordinator_dead_letters", "gauge", "Number of event deliveries in the dead letter store.")
+	writeMetricValue(sb, "coordinator_dead_letters", "", len(coord.outbox.GetDeadLetters()))
+}
@@ -0,0 +1,255 @@
+// Queue for publish requests waiting for encoder capacity
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const PUBLISH_QUEUE_DEFAULT_TIMEOUT = 0 // Default time a publish request can wait for encoder capacity (seconds). 0 = Queue disabled
+
+const PUBLISH_QUEUE_DEFAULT_REQUEST_TIMEOUT = 20 // Default time the streaming servers wait for the response to a publish request (seconds)
+
+const PUBLISH_QUEUE_TIMEOUT_MARGIN = 5 // Time reserved to validate the key and respond before the streaming server gives up on the request (seconds)
+
+// Publish request waiting for encoder capacity
+type QueuedPublishRequest struct {
+	id uint64 // Queue entry ID
+
+	session *ControlSession // Session of the streaming server
+
+	requestId string // Request ID
+	channel   string // Channel ID
+	key       string // Streaming key
+	ip        string // User IP
+
+	resolutionList ResolutionList        // List of resolutions to encode
+	record         bool                  // True if recording is enabled
+	previewsConfig PreviewsConfiguration // Configuration for the image previews
+	streamConfig   StreamConfiguration   // Extra configuration of the stream
+
+	queueTime int64 // Unix timestamp (milliseconds) when the request was queued
+}
+
+// Queue of publish requests waiting for encoder capacity
+type PublishQueue struct {
+	timeout       time.Duration // Max time a request can wait. 0 = Queue disabled
+	priorityOrder bool          // True to sort the requests by priority (queuePriority), false for FIFO
+
+	nextId  uint64                  // ID for the next entry
+	entries []*QueuedPublishRequest // Waiting requests, in order
+
+	mutex *sync.Mutex // Mutex to access the queue
+}
+
+// Creates the publish queue, reading the configuration from the environment
+// The timeout is limited, so the requests are not assigned after the streaming server gave up on them
+// Returns the queue
+func NewPublishQueue() *PublishQueue {
+	timeout := getEnvInt("PUBLISH_QUEUE_TIMEOUT_SECONDS", PUBLISH_QUEUE_DEFAULT_TIMEOUT)
+	maxTimeout := max(0, getEnvInt("PUBLISH_REQUEST_TIMEOUT_SECONDS", PUBLISH_QUEUE_DEFAULT_REQUEST_TIMEOUT)-PUBLISH_QUEUE_TIMEOUT_MARGIN)
+
+	if timeout > maxTimeout {
+		LogWarning("PUBLISH_QUEUE_TIMEOUT_SECONDS is too close to the publish request timeout of the streaming servers (PUBLISH_REQUEST_TIMEOUT_SECONDS). Using " + fmt.Sprint(maxTimeout) + " seconds")
+		timeout = maxTimeout
+	}
+
+	return &PublishQueue{
+		timeout:       time.Duration(timeout) * time.Second,
+		priorityOrder: strings.ToUpper(os.Getenv("PUBLISH_QUEUE_ORDER")) == "PRIORITY",
+		nextId:        0,
+		entries:       make([]*QueuedPublishRequest, 0),
+		mutex:         &sync.Mutex{},
+	}
+}
+
+// Checks if the queue is enabled
+// Returns true if publish requests can wait for encoder capacity
+func (queue *PublishQueue) IsEnabled() bool {
+	return queue.timeout > 0
+}
+
+// Adds a publish request to the queue
+// req - The request
+// Returns false if there is already a request queued for the same channel
+func (queue *PublishQueue) Add(req *QueuedPublishRequest) bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for i := 0; i < len(queue.entries); i++ {
+		if queue.entries[i].channel == req.channel {
+			return false
+		}
+	}
+
+	queue.nextId++
+	req.id = queue.nextId
+	req.queueTime = time.Now().UnixMilli()
+
+	queue.entries = append(queue.entries, req)
+
+	if queue.priorityOrder {
+		// Stable, so requests with the same priority keep the FIFO order
+		sort.SliceStable(queue.entries, func(i, j int) bool {
+			return queue.entries[i].streamConfig.QueuePriority > queue.entries[j].streamConfig.QueuePriority
+		})
+	}
+
+	return true
+}
+
+// Removes a publish request from the queue
+// id - Queue entry ID
+// Returns the removed request, or nil if it was no longer in the queue
+func (queue *PublishQueue) Remove(id uint64) *QueuedPublishRequest {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for i := 0; i < len(queue.entries); i++ {
+		if queue.entries[i].id == id {
+			req := queue.entries[i]
+			queue.entries = append(queue.entries[:i], queue.entries[i+1:]...)
+			return req
+		}
+	}
+
+	return nil
+}
+
+// Removes the publish requests of a streaming server from the queue
+// Call it when the streaming server disconnects
+// sessionId - ID of the streaming server session
+// Returns the number of removed requests
+func (queue *PublishQueue) RemoveSession(sessionId uint64) int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	entries := make([]*QueuedPublishRequest, 0, len(queue.entries))
+
+	for i := 0; i < len(queue.entries); i++ {
+		if queue.entries[i].session.id != sessionId {
+			entries = append(entries, queue.entries[i])
+		}
+	}
+
+	removed := len(queue.entries) - len(entries)
+
+	queue.entries = entries
+
+	return removed
+}
+
+// Gets the number of requests in the queue
+// Returns the queue length
+func (queue *PublishQueue) Length() int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return len(queue.entries)
+}
+
+// Generates the report of the queue
+// Returns the list of queued requests, in order
+func (queue *PublishQueue) GetReport() []ReportAPIResponse_QueuedPublisher {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	result := make([]ReportAPIResponse_QueuedPublisher, len(queue.entries))
+
+	for i := 0; i < len(queue.entries); i++ {
+		result[i] = ReportAPIResponse_QueuedPublisher{
+			Channel:      queue.entries[i].channel,
+			StreamServer: queue.entries[i].session.id,
+			Priority:     queue.entries[i].streamConfig.QueuePriority,
+			QueueTime:    queue.entries[i].queueTime,
+		}
+	}
+
+	return result
+}
+
+// Finds the first request in the queue that can be assigned to an available encoder, and assigns it
+// Returns the request (removed from the queue) and the assigned encoder, or nil if no request can be assigned
+func (server *Streaming_Coordinator_Server) assignNextQueuedPublish() (*QueuedPublishRequest, *ControlSession) {
+	queue := server.coordinator.publishQueue
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for i := 0; i < len(queue.entries); i++ {
+		req := queue.entries[i]
+
+		// Requests with encoder tags may only fit some encoders, so the next requests are also checked
+		encoderServer := server.AssignAvailableEncoder(req.channel, req.streamConfig.EncoderTags)
+
+		if encoderServer != nil {
+			queue.entries = append(queue.entries[:i], queue.entries[i+1:]...)
+			return req, encoderServer
+		}
+	}
+
+	return nil, nil
+}
+
+// Assigns the available encoder capacity to the queued publish requests
+// Call it when an encoder is registered or released
+func (server *Streaming_Coordinator_Server) DispatchPublishQueue() {
+	if server.coordinator.publishQueue.Length() == 0 {
+		return
+	}
+
+	for {
+		req, encoderServer := server.assignNextQueuedPublish()
+
+		if req == nil {
+			return
+		}
+
+		LogInfo("[QUEUE] Assigned encoder #" + fmt.Sprint(encoderServer.id) + " to the queued publish request for channel " + req.channel + " after " + fmt.Sprint(time.Now().UnixMilli()-req.queueTime) + " ms")
+
+		req.session.startPublish(req.requestId, req.channel, req.key, req.ip, req.resolutionList, req.record, req.previewsConfig, req.streamConfig, encoderServer)
+	}
+}
+
+// Queues a publish request until there is encoder capacity (PUBLISH_QUEUE_TIMEOUT_SECONDS)
+// If the timeout is reached, the request is denied
+// req - The request
+// Returns false if the request could not be queued
+func (session *ControlSession) QueuePublishRequest(req *QueuedPublishRequest) bool {
+	queue := session.server.coordinator.publishQueue
+
+	if !queue.Add(req) {
+		return false
+	}
+
+	session.log("PUBLISH QUEUED: " + req.channel + " | PRIORITY: " + fmt.Sprint(req.streamConfig.QueuePriority) + " | QUEUE LENGTH: " + fmt.Sprint(queue.Length()))
+
+	session.server.coordinator.liveEvents.Publish(&LiveEvent{
+		EventType:  LIVE_EVENT_PUBLISH_QUEUED,
+		Channel:    req.channel,
+		ServerId:   session.id,
+		ServerType: GetStreamingServerTypeName(session.sessionType),
+	})
+
+	id := req.id
+
+	time.AfterFunc(queue.timeout, func() {
+		if queue.Remove(id) == nil {
+			return // Already assigned
+		}
+
+		session.log("PUBLISH QUEUE TIMEOUT: " + req.channel)
+
+		session.DenyPublish(req.requestId, req.channel, req.ip, PUBLISH_DENY_REASON_NO_ENCODER)
+	})
+
+	// An encoder may have been released while queuing the request
+	go session.server.DispatchPublishQueue()
+
+	return true
+}
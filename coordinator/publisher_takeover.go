@@ -0,0 +1,110 @@
+// Publisher takeover (replacing the publisher of an active stream)
+
+package main
+
+import "fmt"
+
+// Lets a new publisher take over a channel that is already being published (publisherTakeover policy)
+// The previous publisher is killed, and the stream continues with the same ID
+// Must be called with the channel acquired. The channel is released
+// requestId - Request ID
+// channelData - The channel
+// key - The streaming key
+// ip - User IP
+func (session *ControlSession) TakeOverStream(requestId string, channelData *StreamingChannel, key string, ip string) {
+	channel := channelData.id
+	streamId := channelData.streamId
+	previousPublisher := channelData.publisher
+
+	// The encoding is restarted from the new source when the current task ends
+	session.server.coordinator.AddMigratingStream(channel, streamId, 0)
+
+	if session.ReplaceStreamPublisher(requestId, channelData, key, ip) {
+		session.log("PUBLISHER TAKEOVER: " + channel + "/" + streamId + " | PREVIOUS SERVER: #" + fmt.Sprint(previousPublisher))
+	} else {
+		session.server.coordinator.TakeMigratingStream(channel, streamId)
+	}
+}
+
+// Replaces the publisher of an active stream (publisher migration or takeover)
+// The stream keeps the same ID, and the encoding is restarted from the new source
+// The stream must be registered as migrating (AddMigratingStream), so the encoding is restarted when the current task ends
+// Must be called with the channel acquired. The channel is released
+// requestId - Request ID
+// channelData - The channel
+// key - The streaming key
+// ip - User IP
+// Returns true if the publisher was accepted
+func (session *ControlSession) ReplaceStreamPublisher(requestId string, channelData *StreamingChannel, key string, ip string) bool {
+	channel := channelData.id
+	streamId := channelData.streamId
+
+	var encoderServer *ControlSession = nil
+
+	if channelData.encoder == 0 {
+		encoderServer = session.server.AssignAvailableEncoder(channel, channelData.config.EncoderTags)
+
+		if encoderServer == nil {
+			session.server.coordinator.ReleaseChannel(channelData)
+			session.DenyPublish(requestId, channel, ip, PUBLISH_DENY_REASON_NO_ENCODER)
+			return false
+		}
+	}
+
+	// Kill the previous publisher, if still connected to another server
+	// If it is connected to this server, the server replaces it
+	if channelData.publisher != 0 && channelData.publisher != session.id {
+		pubSession := session.server.GetSession(channelData.publisher)
+
+		if pubSession != nil {
+			pubSession.SendStreamKill(channel, streamId)
+			pubSession.DisassociateChannel(channel)
+		}
+	}
+
+	channelData.publisher = session.id
+	if session.sessionType == SESSION_TYPE_RTMP {
+		channelData.publishMethod = PUBLISH_METHOD_RTMP
+	} else {
+		channelData.publishMethod = PUBLISH_METHOD_WS
+	}
+	channelData.sourceURL = session.GeneratePublishSourceURL(channel, key)
+	channelData.sourceBitrate = 0
+	channelData.sourceStalledSince = 0
+	session.AssociateChannel(channel)
+
+	if channelData.backupPublisher == session.id {
+		// The streaming server replaces the backup publisher with the new one
+		channelData.backupPublisher = 0
+		channelData.backupSourceURL = ""
+	}
+
+	if encoderServer != nil {
+		session.server.restartMigratedEncoding(channelData, encoderServer)
+	} else {
+		// The current encoding task is still running. The encoding is restarted when it ends
+		session.server.coordinator.UpdateChannelState(channelData)
+
+		encoderSession := session.server.GetSession(channelData.encoder)
+
+		if encoderSession != nil {
+			encoderSession.SendEncodeStop(channel, streamId)
+		}
+	}
+
+	session.server.coordinator.ReleaseChannel(channelData)
+
+	session.SendPublishAccept(requestId, channel, streamId)
+
+	METRICS.OnPublishRequest(true, "")
+
+	session.server.coordinator.liveEvents.Publish(&LiveEvent{
+		EventType:  LIVE_EVENT_PUBLISH_ACCEPTED,
+		Channel:    channel,
+		StreamId:   streamId,
+		ServerId:   session.id,
+		ServerType: GetStreamingServerTypeName(session.sessionType),
+	})
+
+	return true
+}
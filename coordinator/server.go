@@ -28,6 +28,12 @@ type Streaming_Coordinator_Server struct {
 	mutex *sync.Mutex // Mutex to control the access to the status data (sessions)
 
 	coordinator *Streaming_Coordinator
+
+	store StateStore // Store for the coordinator state
+
+	nodeId      string        // ID of this coordinator node
+	leaderLease time.Duration // Duration of the leadership lease
+	leader      bool          // True if this node is the leader (handles the sessions)
 }
 
 // Initializes the server
@@ -41,8 +47,29 @@ func (server *Streaming_Coordinator_Server) Initialize() {
 	server.mutex = &sync.Mutex{}
 	server.sessions = make(map[uint64]*ControlSession)
 
+	store, err := CreateStateStore()
+
+	if err != nil {
+		LogError(err)
+		LogErrorMessage("Could not create the state store")
+		os.Exit(1)
+	}
+
+	server.store = store
 	server.coordinator = &Streaming_Coordinator{}
-	server.coordinator.Initialize()
+
+	if os.Getenv("LEADER_ELECTION") == "YES" {
+		// Active/standby mode, only the leader handles the sessions
+		server.nodeId = GetNodeId()
+		server.leaderLease = time.Duration(getEnvInt("LEADER_LEASE_SECONDS", LEADER_LEASE_DEFAULT_DURATION)) * time.Second
+		server.leader = false
+
+		LogInfo("[HA] Node " + server.nodeId + " waiting for the leadership")
+
+		go server.RunLeaderElection()
+	} else {
+		server.BecomeLeader()
+	}
 }
 
 // Generates unique ID for each request
@@ -192,6 +219,10 @@ func (server *Streaming_Coordinator_Server) ServeHTTP(w http.ResponseWriter, req
 	if req.RequestURI == "/" {
 		w.WriteHeader(200)
 		fmt.Fprintf(w, "Coordinator streaming server - Version "+VERSION)
+	} else if !server.IsLeader() {
+		// Standby node, the clients must connect to the leader
+		w.WriteHeader(503)
+		fmt.Fprintf(w, "This coordinator node is not the leader.")
 	} else if req.RequestURI == "/ws/control/rtmp" {
 		authToken := req.Header.Get("x-control-auth-token")
 		if !ValidateAuthenticationToken(authToken, RTMP_AUTH_SUBJECT) {
@@ -311,10 +342,26 @@ func (server *Streaming_Coordinator_Server) ServeHTTP(w http.ResponseWriter, req
 		go session.Run()
 	} else if req.Method == "POST" && req.RequestURI == "/commands/close" {
 		server.RunStreamCloseCommand(w, req)
+	} else if req.Method == "POST" && req.RequestURI == "/commands/encoder/drain" {
+		server.RunEncoderDrainCommand(w, req)
+	} else if req.Method == "POST" && req.RequestURI == "/commands/server/drain" {
+		server.RunStreamingServerDrainCommand(w, req)
+	} else if req.Method == "POST" && req.RequestURI == "/commands/invalidate-key" {
+		server.RunInvalidateKeyCommand(w, req)
 	} else if req.Method == "GET" && req.RequestURI == "/commands/capacity" {
 		server.RunGetCapacityCommand(w, req)
 	} else if req.Method == "GET" && req.RequestURI == "/commands/report" {
 		server.RunReportCommand(w, req)
+	} else if req.Method == "GET" && req.RequestURI == "/commands/stream" {
+		server.RunStreamReportCommand(w, req)
+	} else if req.Method == "GET" && req.RequestURI == "/metrics" {
+		server.RunMetricsCommand(w, req)
+	} else if req.Method == "GET" && req.RequestURI == "/commands/events" {
+		server.RunLiveEventsCommand(w, req)
+	} else if req.Method == "GET" && req.RequestURI == "/commands/dead-letters" {
+		server.RunDeadLettersListCommand(w, req)
+	} else if req.Method == "POST" && req.RequestURI == "/commands/dead-letters/replay" {
+		server.RunDeadLettersReplayCommand(w, req)
 	} else {
 		w.WriteHeader(404)
 		fmt.Fprintf(w, "Not found.")
@@ -328,17 +375,28 @@ func (server *Streaming_Coordinator_Server) AddSession(s *ControlSession) {
 	defer server.mutex.Unlock()
 
 	server.sessions[s.id] = s
+
+	METRICS.OnSessionConnect(s.sessionType)
 }
 
 // Removes a session from the list
 // id - Session ID
 func (server *Streaming_Coordinator_Server) RemoveSession(id uint64) {
+	// The queue must be locked before the server, since the queue is locked while assigning encoders
+	removedQueued := server.coordinator.publishQueue.RemoveSession(id)
+
+	if removedQueued > 0 {
+		LogInfo("[QUEUE] Removed " + fmt.Sprint(removedQueued) + " queued publish requests of the disconnected session #" + fmt.Sprint(id))
+	}
+
 	server.mutex.Lock()
 	defer server.mutex.Unlock()
 
 	session := server.sessions[id]
 
 	if session != nil {
+		METRICS.OnSessionDisconnect(session.sessionType)
+
 		switch session.sessionType {
 		case SESSION_TYPE_RTMP, SESSION_TYPE_WSS:
 			server.coordinator.DeregisterStreamingServer(session.sessionType, id)
@@ -348,8 +406,29 @@ func (server *Streaming_Coordinator_Server) RemoveSession(id uint64) {
 			for i := 0; i < len(associatedChannels); i++ {
 				channelData := server.coordinator.AcquireChannel(associatedChannels[i])
 
+				if !channelData.closed && channelData.backupPublisher == session.id {
+					// The backup publisher is gone
+					server.releaseBackupPublisher(channelData, nil)
+				}
+
 				if !channelData.closed && channelData.publisher == session.id {
+					if _, migrating := server.coordinator.GetMigratingStream(channelData.id, channelData.streamId); migrating {
+						// Wait for the publisher to reconnect to another server
+						server.detachMigratingPublisher(channelData, server.sessions[channelData.encoder])
+						server.coordinator.ReleaseChannel(channelData)
+						continue
+					}
+
+					if channelData.backupPublisher != 0 {
+						// Continue the stream with the backup publisher
+						server.switchToBackupPublisher(channelData, server.sessions[channelData.encoder], BACKUP_SWITCH_REASON_LOST)
+						server.coordinator.ReleaseChannel(channelData)
+						continue
+					}
+
 					channelData.closed = true
+					channelData.SetCloseReason(MakeStreamCloseReason(CLOSE_REASON_PUBLISHER_LOST, "The streaming server of the publisher disconnected"))
+					server.coordinator.UpdateChannelState(channelData)
 
 					// Find encoder and notice it
 					encoderId := channelData.encoder
@@ -371,17 +450,18 @@ func (server *Streaming_Coordinator_Server) RemoveSession(id uint64) {
 			for i := 0; i < len(associatedChannels); i++ {
 				channelData := server.coordinator.AcquireChannel(associatedChannels[i])
 
-				// Close active stream
-				session.server.coordinator.OnActiveStreamClosed(channelData.id, channelData.streamId)
-
 				if !channelData.closed && channelData.encoder == session.id {
-					// Find publisher and kill the stream
-					publisherId := channelData.publisher
-					pubSession := server.sessions[publisherId]
+					// Wait for the encoder to reconnect, then move the stream to another encoder or close it
+					go server.OnStreamEncoderLost(channelData.id, channelData.streamId, session.id)
+					server.coordinator.ReleaseChannel(channelData)
+					continue
+				}
 
-					if pubSession != nil {
-						pubSession.SendStreamKill(channelData.id, channelData.streamId)
-					}
+				// Close active stream
+				session.server.coordinator.OnActiveStreamClosed(channelData.id, channelData.streamId, channelData.GetCloseReason(channelData.streamId))
+
+				if channelData.closed {
+					server.releaseBackupPublisher(channelData, server.sessions[channelData.backupPublisher])
 				}
 
 				// Cancel any stream-available events
@@ -75,6 +75,22 @@ func CreateSession(server *Streaming_Coordinator_Server, conn *websocket.Conn, i
 	return &session
 }
 
+// Gets the name of a control session type
+// sessionType - Type of control session
+// Returns the name (rtmp, wss or hls)
+func GetSessionTypeName(sessionType int) string {
+	switch sessionType {
+	case SESSION_TYPE_RTMP:
+		return "rtmp"
+	case SESSION_TYPE_WSS:
+		return "wss"
+	case SESSION_TYPE_HLS:
+		return "hls"
+	default:
+		return "unknown"
+	}
+}
+
 // Logs a message for this connection
 // str - message to log
 func (session *ControlSession) log(str string) {
@@ -190,7 +206,11 @@ func (session *ControlSession) HandleMessage(msg messages.RPCMessage) {
 	case "PUBLISH-REQUEST":
 		session.HandlePublishRequest(msg.GetParam("Request-ID"), msg.GetParam("Stream-Channel"), msg.GetParam("Stream-Key"), msg.GetParam("User-IP"))
 	case "PUBLISH-END":
-		session.HandlePublishEnd(msg.GetParam("Stream-Channel"), msg.GetParam("Stream-ID"))
+		session.HandlePublishEnd(msg.GetParam("Stream-Channel"), msg.GetParam("Stream-ID"), MakeStreamCloseReason(msg.GetParam("Close-Reason"), msg.GetParam("Close-Message")))
+	case "PUBLISHER-STATS":
+		session.HandlePublisherStats(ParsePublisherStatsList(msg.GetParam("Publishers")))
+	case "ACTIVE-PUBLISHERS":
+		session.HandleActivePublishers(ParseActivePublishersList(msg.GetParam("Publishers")))
 	case "REGISTER":
 		capacity, err := strconv.ParseInt(msg.GetParam("Capacity"), 10, 32)
 
@@ -199,11 +219,17 @@ func (session *ControlSession) HandleMessage(msg messages.RPCMessage) {
 			return
 		}
 
-		session.HandleEncoderRegister(int(capacity))
+		session.HandleEncoderRegister(int(capacity), ParseEncoderTagsList(msg.GetParam("Tags")), ParseEncoderTaskInfoList(msg.GetParam("Tasks")))
 	case "STREAM-AVAILABLE":
 		session.HandleStreamAvailable(msg.GetParam("Stream-Channel"), msg.GetParam("Stream-ID"), msg.GetParam("Stream-Type"), msg.GetParam("Resolution"), msg.GetParam("Start-Time"), msg.GetParam("Index-file"))
+	case "STREAM-INFO":
+		session.HandleStreamInfo(msg.GetParam("Stream-Channel"), msg.GetParam("Stream-ID"), ParseStreamSourceInfo(&msg))
 	case "STREAM-CLOSED":
-		session.HandleStreamClosed(msg.GetParam("Stream-Channel"), msg.GetParam("Stream-ID"))
+		session.HandleStreamClosed(msg.GetParam("Stream-Channel"), msg.GetParam("Stream-ID"), MakeStreamCloseReason(msg.GetParam("Close-Reason"), msg.GetParam("Close-Message")))
+	case "ENCODER-DRAIN":
+		session.HandleEncoderDrain(msg.GetParam("Deadline"))
+	case "ENCODER-STATS":
+		session.HandleEncoderStats(msg.GetParam("CPU-Usage"), msg.GetParam("Memory-Used"), msg.GetParam("Memory-Total"), msg.GetParam("Load"), msg.GetParam("Tasks"))
 	}
 }
 
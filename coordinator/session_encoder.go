@@ -4,22 +4,67 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	messages "github.com/AgustinSRG/go-simple-rpc-message"
 )
 
 // Handles REGISTER message
 // capacity - Encoder capacity
-func (session *ControlSession) HandleEncoderRegister(capacity int) {
+// tags - Encoder tags
+// tasks - Active encoding tasks of the encoder
+func (session *ControlSession) HandleEncoderRegister(capacity int, tags []string, tasks []EncoderTaskInfo) {
 	if session.sessionType != SESSION_TYPE_HLS {
 		return
 	}
 
-	session.server.coordinator.RegisterEncoder(session.id, capacity)
+	session.server.coordinator.RegisterEncoder(session.id, capacity, tags)
 
-	session.log("REGISTERED ENCODER / CAPACITY: " + fmt.Sprint(capacity))
+	session.log("REGISTERED ENCODER / CAPACITY: " + fmt.Sprint(capacity) + " / TAGS: " + strings.Join(tags, ",") + " / TASKS: " + fmt.Sprint(len(tasks)))
 
 	session.encoderRegistered = true
+
+	session.server.ReconcileEncoderTasks(session, tasks)
+
+	// The new encoder may have capacity for the queued publish requests
+	go session.server.DispatchPublishQueue()
+}
+
+// Handles ENCODER-STATS message
+// cpuUsageStr - CPU usage (0 to 100). Empty if unknown
+// memoryUsedStr - Used memory (bytes). Empty if unknown
+// memoryTotalStr - Total memory (bytes). Empty if unknown
+// loadStr - Number of encoding tasks. Empty if unknown
+// tasksStr - List of task stats. Format: {CHANNEL}:{STREAM_ID}:{SPEED}:{FPS}:{DROPPED_FRAMES}, split by commas
+func (session *ControlSession) HandleEncoderStats(cpuUsageStr string, memoryUsedStr string, memoryTotalStr string, loadStr string, tasksStr string) {
+	if session.sessionType != SESSION_TYPE_HLS || !session.encoderRegistered {
+		return
+	}
+
+	cpuUsage, err := strconv.ParseFloat(cpuUsageStr, 64)
+
+	if err != nil {
+		cpuUsage = -1
+	}
+
+	memoryUsed, _ := strconv.ParseUint(memoryUsedStr, 10, 64)
+	memoryTotal, _ := strconv.ParseUint(memoryTotalStr, 10, 64)
+
+	load, err := strconv.Atoi(loadStr)
+
+	if err != nil {
+		load = -1
+	}
+
+	session.server.coordinator.UpdateEncoderStats(session.id, &EncoderStats{
+		cpuUsage:    cpuUsage,
+		memoryUsed:  memoryUsed,
+		memoryTotal: memoryTotal,
+		load:        load,
+		tasks:       ParseEncoderTaskStats(tasksStr),
+	})
 }
 
 // Handles STREAM-AVAILABLE message
@@ -48,6 +93,8 @@ func (session *ControlSession) HandleStreamAvailable(channel string, streamId st
 
 	event := &PendingStreamAvailableEvent{
 		id:         eventId,
+		eventId:    GenerateEventId(),
+		timestamp:  time.Now().UnixMilli(),
 		channel:    channel,
 		streamId:   streamId,
 		streamType: streamType,
@@ -59,7 +106,20 @@ func (session *ControlSession) HandleStreamAvailable(channel string, streamId st
 
 	channelData.pendingEvents[channelData.nextEventId] = event
 
-	go SendStreamAvailableEvent(channelData, event)
+	deliveries := session.server.coordinator.EnqueueEvent(event.CallbackEvent())
+
+	go SendStreamAvailableEvent(session.server.coordinator, channelData, event, deliveries)
+
+	session.server.coordinator.liveEvents.Publish(&LiveEvent{
+		EventType:  LIVE_EVENT_STREAM_AVAILABLE,
+		Channel:    channel,
+		StreamId:   streamId,
+		EncoderId:  session.id,
+		StreamType: streamType,
+		Resolution: resolution,
+		IndexFile:  indexFile,
+		StartTime:  startTimeStr,
+	})
 
 	startTimeStrDisplay := startTimeStr
 
@@ -73,16 +133,46 @@ func (session *ControlSession) HandleStreamAvailable(channel string, streamId st
 // Handles STREAM-CLOSED message
 // channel - The channel
 // streamId - The stream ID
-func (session *ControlSession) HandleStreamClosed(channel string, streamId string) {
+// closeReason - Reason reported by the encoder
+func (session *ControlSession) HandleStreamClosed(channel string, streamId string, closeReason StreamCloseReason) {
 	if session.sessionType != SESSION_TYPE_HLS {
 		return
 	}
 
-	session.server.coordinator.OnActiveStreamClosed(channel, streamId)
+	// The encoder is no longer handling the stream
+	session.server.ReleaseEncoder(session.id)
 
 	channelData := session.server.coordinator.AcquireChannel(channel)
 	defer session.server.coordinator.ReleaseChannel(channelData)
 
+	if drainingServer, migrating := session.server.coordinator.GetMigratingStream(channel, streamId); migrating && !channelData.closed && channelData.streamId == streamId && channelData.encoder == session.id {
+		// The publisher is migrating to another streaming server, so the stream continues
+		session.onMigratingStreamEncodingEnded(channelData, drainingServer)
+		return
+	}
+
+	if !channelData.closed && channelData.streamId == streamId && channelData.encoder != session.id {
+		// The stream was moved to another encoder (drain or publisher migration), so it continues
+		session.log("STREAM-CLOSED: " + channel + "/" + streamId + " | MOVED TO ENCODER: #" + fmt.Sprint(channelData.encoder))
+		return
+	}
+
+	if channelData.streamId == streamId {
+		// If the stream was closed by the coordinator or the publisher, keep that reason
+		channelData.SetCloseReason(closeReason)
+		closeReason = channelData.closeReason
+	}
+
+	if closeReason.IsFailure() {
+		session.server.coordinator.OnStreamFailed(channel, streamId, closeReason)
+	}
+
+	session.server.coordinator.OnActiveStreamClosed(channel, streamId, closeReason)
+
+	if channelData.streamId == streamId {
+		session.server.releaseBackupPublisher(channelData, session.server.GetSession(channelData.backupPublisher))
+	}
+
 	if !channelData.closed && channelData.encoder == session.id {
 		// Find publisher and kill the stream session
 		publisherId := channelData.publisher
@@ -98,7 +188,7 @@ func (session *ControlSession) HandleStreamClosed(channel string, streamId strin
 		event.cancelled = true
 	}
 
-	session.log("STREAM-CLOSED: " + channel + "/" + streamId)
+	session.log("STREAM-CLOSED: " + channel + "/" + streamId + " | REASON: " + closeReason.code + " | MESSAGE: " + closeReason.message)
 }
 
 // Sends ENCODE-START message
@@ -109,7 +199,9 @@ func (session *ControlSession) HandleStreamClosed(channel string, streamId strin
 // resolutionList - List of resolutions
 // record - True to record
 // previewsConfig - Image previews configuration
-func (session *ControlSession) SendEncodeStart(channel string, streamId string, publishType int, publishSourceURL string, resolutionList ResolutionList, record bool, previewsConfig PreviewsConfiguration) {
+// config - Extra configuration of the stream
+// resumeTime - Seconds of the stream already encoded by another encoder (0 for new streams)
+func (session *ControlSession) SendEncodeStart(channel string, streamId string, publishType int, publishSourceURL string, resolutionList ResolutionList, record bool, previewsConfig PreviewsConfiguration, config StreamConfiguration, resumeTime float64) {
 	params := make(map[string]string)
 
 	params["Stream-Channel"] = channel
@@ -134,6 +226,12 @@ func (session *ControlSession) SendEncodeStart(channel string, streamId string,
 
 	params["Previews"] = previewsConfig.Encode()
 
+	config.AddEncodeStartParams(params)
+
+	if resumeTime > 0 {
+		params["Resume-Time"] = strconv.FormatFloat(resumeTime, 'f', 3, 64)
+	}
+
 	msg := messages.RPCMessage{
 		Method: "ENCODE-START",
 		Params: params,
@@ -2,7 +2,22 @@
 
 package main
 
-import messages "github.com/AgustinSRG/go-simple-rpc-message"
+import (
+	"strings"
+	"time"
+
+	messages "github.com/AgustinSRG/go-simple-rpc-message"
+)
+
+// Reasons to deny a publish request
+const (
+	PUBLISH_DENY_REASON_INVALID_CHANNEL    = "invalid-channel"
+	PUBLISH_DENY_REASON_INVALID_KEY        = "invalid-key"
+	PUBLISH_DENY_REASON_ALREADY_PUBLISHING = "already-publishing"
+	PUBLISH_DENY_REASON_INVALID_SERVER     = "invalid-server"
+	PUBLISH_DENY_REASON_NO_ENCODER         = "no-encoder"
+	PUBLISH_DENY_REASON_SERVER_DRAINING    = "server-draining"
+)
 
 // Handles PUBLISH-REQUEST message
 // requestId - Request ID
@@ -14,19 +29,52 @@ func (session *ControlSession) HandlePublishRequest(requestId string, channel st
 		return
 	}
 
+	session.server.coordinator.liveEvents.Publish(&LiveEvent{
+		EventType:  LIVE_EVENT_PUBLISH_REQUEST,
+		Channel:    channel,
+		ServerId:   session.id,
+		ServerType: GetStreamingServerTypeName(session.sessionType),
+		IP:         ip,
+	})
+
 	if !validateStreamIDString(channel) {
-		session.SendPublishDeny(requestId, channel)
+		session.DenyPublish(requestId, channel, ip, PUBLISH_DENY_REASON_INVALID_CHANNEL)
 		return
 	}
 
 	if !validateStreamIDString(key) {
-		session.SendPublishDeny(requestId, channel)
+		session.DenyPublish(requestId, channel, ip, PUBLISH_DENY_REASON_INVALID_KEY)
 		return
 	}
 
-	keyValid, resolutionList, record, previewsConfig := ValidateStreamKey(channel, key, ip)
+	if session.server.coordinator.IsStreamingServerDraining(session.id) {
+		session.DenyPublish(requestId, channel, ip, PUBLISH_DENY_REASON_SERVER_DRAINING)
+		return
+	}
+
+	keyValid, resolutionList, record, previewsConfig, streamConfig := session.server.coordinator.ValidateStreamKey(channel, key, ip)
 	if !keyValid {
-		session.SendPublishDeny(requestId, channel)
+		session.DenyPublish(requestId, channel, ip, PUBLISH_DENY_REASON_INVALID_KEY)
+		return
+	}
+
+	session.startPublish(requestId, channel, key, ip, resolutionList, record, previewsConfig, streamConfig, nil)
+}
+
+// Starts publishing on a channel, after the key was validated
+// requestId - Request ID
+// channel - The channel
+// key  - The streaming key
+// ip - User IP
+// resolutionList - List of resolutions to encode
+// record - True if recording is enabled
+// previewsConfig - Configuration for the image previews
+// streamConfig - Extra configuration of the stream
+// queuedEncoder - Encoder already assigned to the request, if it was waiting in the publish queue. nil otherwise
+func (session *ControlSession) startPublish(requestId string, channel string, key string, ip string, resolutionList ResolutionList, record bool, previewsConfig PreviewsConfiguration, streamConfig StreamConfiguration, queuedEncoder *ControlSession) {
+	if queuedEncoder != nil && session.server.GetSession(session.id) != session {
+		// The streaming server disconnected while the request was queued
+		session.server.ReleaseEncoder(queuedEncoder.id)
 		return
 	}
 
@@ -36,14 +84,40 @@ func (session *ControlSession) HandlePublishRequest(requestId string, channel st
 
 	channelData := session.server.coordinator.AcquireChannel(channel)
 
+	if queuedEncoder != nil && !channelData.closed {
+		// Another publisher started the stream while the request was queued
+		session.server.ReleaseEncoder(queuedEncoder.id)
+		queuedEncoder = nil
+	}
+
+	if _, migrating := session.server.coordinator.GetMigratingStream(channel, channelData.streamId); migrating && !channelData.closed {
+		// The publisher is reconnecting after being asked to migrate
+		session.ResumeMigratingStream(requestId, channelData, key, ip)
+		return
+	}
+
+	if !channelData.closed && streamConfig.BackupIngest && channelData.publisher != 0 && channelData.backupPublisher == 0 {
+		// The new publisher stays on standby, as backup of the current one
+		session.AcceptBackupPublisher(requestId, channelData, key, ip)
+		return
+	}
+
+	if !channelData.closed && streamConfig.PublisherTakeover {
+		// The new publisher replaces the current one
+		session.TakeOverStream(requestId, channelData, key, ip)
+		return
+	}
+
 	if !channelData.closed {
 		// Already publishing
 		session.server.coordinator.ReleaseChannel(channelData)
-		session.SendPublishDeny(requestId, channel)
+		session.DenyPublish(requestId, channel, ip, PUBLISH_DENY_REASON_ALREADY_PUBLISHING)
 		return
 	}
 
 	channelData.closed = false
+	channelData.closeReason = StreamCloseReason{}
+	channelData.streamId = streamId
 	channelData.publisher = session.id
 	switch session.sessionType {
 	case SESSION_TYPE_RTMP:
@@ -53,36 +127,113 @@ func (session *ControlSession) HandlePublishRequest(requestId string, channel st
 	default:
 		channelData.closed = true
 		session.server.coordinator.ReleaseChannel(channelData)
-		session.SendPublishDeny(requestId, channel)
+		session.DenyPublish(requestId, channel, ip, PUBLISH_DENY_REASON_INVALID_SERVER)
 		return
 	}
 	session.AssociateChannel(channel)
 
 	// Find an encoder and assign it
-	encoderServer := session.server.AssignAvailableEncoder()
+	encoderServer := queuedEncoder
+	if encoderServer == nil {
+		encoderServer = session.server.AssignAvailableEncoder(channel, streamConfig.EncoderTags)
+	}
 	if encoderServer == nil {
 		channelData.closed = true
 		session.server.coordinator.ReleaseChannel(channelData)
-		session.SendPublishDeny(requestId, channel)
+
+		if session.server.coordinator.publishQueue.IsEnabled() && session.QueuePublishRequest(&QueuedPublishRequest{
+			session:        session,
+			requestId:      requestId,
+			channel:        channel,
+			key:            key,
+			ip:             ip,
+			resolutionList: resolutionList,
+			record:         record,
+			previewsConfig: previewsConfig,
+			streamConfig:   streamConfig,
+		}) {
+			// Wait for an encoder
+			return
+		}
+
+		session.DenyPublish(requestId, channel, ip, PUBLISH_DENY_REASON_NO_ENCODER)
 		return
 	}
 
 	encoderServer.AssociateChannel(channel)
 	channelData.encoder = encoderServer.id
 
-	encoderServer.SendEncodeStart(channel, streamId, channelData.publishMethod, session.GeneratePublishSourceURL(channel, key), resolutionList, record, previewsConfig)
+	channelData.sourceURL = session.GeneratePublishSourceURL(channel, key)
+	channelData.resolutions = resolutionList
+	channelData.record = record
+	channelData.previews = previewsConfig
+	channelData.config = streamConfig
+	channelData.sourceInfo = nil
+	channelData.sourceBitrate = 0
+	channelData.sourceStalledSince = 0
+	channelData.backupPublisher = 0
+	channelData.backupSourceURL = ""
+	channelData.encodeStartTime = time.Now().UnixMilli()
+
+	session.server.coordinator.UpdateChannelState(channelData)
+
+	encoderServer.SendEncodeStart(channel, streamId, channelData.publishMethod, channelData.sourceURL, resolutionList, record, previewsConfig, streamConfig, 0)
+
+	session.server.coordinator.liveEvents.Publish(&LiveEvent{
+		EventType: LIVE_EVENT_ENCODE_START,
+		Channel:   channel,
+		StreamId:  streamId,
+		EncoderId: encoderServer.id,
+	})
 
 	// Release channel data
 	session.server.coordinator.ReleaseChannel(channelData)
 
 	// Accepted
 	session.SendPublishAccept(requestId, channel, streamId)
+
+	session.server.StartStreamDurationLimit(channel, streamId, streamConfig.MaxDuration, 0)
+
+	METRICS.OnPublishRequest(true, "")
+
+	session.server.coordinator.OnPublishStarted(channel, streamId, ip, GetStreamingServerTypeName(session.sessionType))
+
+	session.server.coordinator.liveEvents.Publish(&LiveEvent{
+		EventType:  LIVE_EVENT_PUBLISH_ACCEPTED,
+		Channel:    channel,
+		StreamId:   streamId,
+		ServerId:   session.id,
+		ServerType: GetStreamingServerTypeName(session.sessionType),
+		EncoderId:  encoderServer.id,
+	})
+}
+
+// Denies a publish request
+// requestId - The request ID
+// channel - The channel
+// ip - User IP
+// reason - The reason to deny the request
+func (session *ControlSession) DenyPublish(requestId string, channel string, ip string, reason string) {
+	session.SendPublishDeny(requestId, channel)
+
+	METRICS.OnPublishRequest(false, reason)
+
+	session.server.coordinator.OnPublishDenied(channel, ip, GetStreamingServerTypeName(session.sessionType), reason)
+
+	session.server.coordinator.liveEvents.Publish(&LiveEvent{
+		EventType:  LIVE_EVENT_PUBLISH_DENIED,
+		Channel:    channel,
+		ServerId:   session.id,
+		ServerType: GetStreamingServerTypeName(session.sessionType),
+		Reason:     reason,
+	})
 }
 
 // Handles a PUBLISH-END message
 // channel - The channel
 // streamId - The stream ID
-func (session *ControlSession) HandlePublishEnd(channel string, streamId string) {
+// closeReason - Reason reported by the streaming server
+func (session *ControlSession) HandlePublishEnd(channel string, streamId string, closeReason StreamCloseReason) {
 	if session.sessionType != SESSION_TYPE_RTMP && session.sessionType != SESSION_TYPE_WSS {
 		return
 	}
@@ -94,7 +245,46 @@ func (session *ControlSession) HandlePublishEnd(channel string, streamId string)
 		return
 	}
 
+	if channelData.backupPublisher == session.id && channelData.streamId == streamId {
+		// The backup publisher ended, the stream continues with the primary one
+		channelData.backupPublisher = 0
+		channelData.backupSourceURL = ""
+		session.DisassociateChannel(channel)
+		session.server.coordinator.ReleaseChannel(channelData)
+		session.log("BACKUP PUBLISHER ENDED: " + channel + "/" + streamId)
+		return
+	}
+
+	if channelData.publisher != 0 && channelData.publisher != session.id {
+		// The publisher already reconnected to another server
+		session.server.coordinator.ReleaseChannel(channelData)
+		return
+	}
+
+	if _, migrating := session.server.coordinator.GetMigratingStream(channel, streamId); migrating {
+		// Wait for the publisher to reconnect to another server
+		session.DisassociateChannel(channel)
+		session.server.detachMigratingPublisher(channelData, session.server.GetSession(channelData.encoder))
+		session.server.coordinator.ReleaseChannel(channelData)
+		return
+	}
+
+	if channelData.backupPublisher != 0 {
+		// Continue the stream with the backup publisher
+		session.DisassociateChannel(channel)
+		session.server.switchToBackupPublisher(channelData, session.server.GetSession(channelData.encoder), BACKUP_SWITCH_REASON_ENDED)
+		session.server.coordinator.ReleaseChannel(channelData)
+		return
+	}
+
+	if closeReason.code == "" {
+		closeReason = MakeStreamCloseReason(CLOSE_REASON_PUBLISHER_ENDED, "The publisher stopped publishing")
+	}
+
 	channelData.closed = true
+	channelData.SetCloseReason(closeReason)
+
+	session.server.coordinator.UpdateChannelState(channelData)
 
 	// Disassociate the channel from the session
 	session.DisassociateChannel(channel)
@@ -111,6 +301,53 @@ func (session *ControlSession) HandlePublishEnd(channel string, streamId string)
 	session.server.coordinator.ReleaseChannel(channelData)
 }
 
+// Handles an ACTIVE-PUBLISHERS message
+// Publishers matching a stream recovered after a restart are adopted, and the rest are killed
+// publishers - The publishers reported by the streaming server
+func (session *ControlSession) HandleActivePublishers(publishers []ActivePublisherInfo) {
+	if session.sessionType != SESSION_TYPE_RTMP && session.sessionType != SESSION_TYPE_WSS {
+		return
+	}
+
+	publishMethod := PUBLISH_METHOD_RTMP
+
+	if session.sessionType == SESSION_TYPE_WSS {
+		publishMethod = PUBLISH_METHOD_WS
+	}
+
+	for i := 0; i < len(publishers); i++ {
+		publisher := publishers[i]
+
+		// The source URL of the stream must point to this server
+		sourceURLPrefix := session.GeneratePublishSourceURL(publisher.channel, "")
+
+		channelData := session.server.coordinator.AcquireChannel(publisher.channel)
+
+		if !channelData.closed && channelData.streamId == publisher.streamId && channelData.publisher == session.id {
+			// Already associated
+			session.server.coordinator.ReleaseChannel(channelData)
+			continue
+		}
+
+		if channelData.closed || channelData.streamId != publisher.streamId || channelData.publisher != 0 || channelData.publishMethod != publishMethod || !strings.HasPrefix(channelData.sourceURL, sourceURLPrefix) {
+			session.server.coordinator.ReleaseChannel(channelData)
+
+			session.log("UNKNOWN PUBLISHER: " + publisher.channel + "/" + publisher.streamId + ". Killing it.")
+			session.SendStreamKill(publisher.channel, publisher.streamId)
+			continue
+		}
+
+		channelData.publisher = session.id
+		session.AssociateChannel(publisher.channel)
+
+		session.server.coordinator.UpdateChannelState(channelData)
+
+		session.server.coordinator.ReleaseChannel(channelData)
+
+		session.log("ADOPTED PUBLISHER: " + publisher.channel + "/" + publisher.streamId)
+	}
+}
+
 // Sends a PUBLISH-DENY message
 // requestId - The request ID
 // channel - The channel
@@ -0,0 +1,71 @@
+// Coordinator state store
+
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// Storage for the coordinator state, shared between the coordinator nodes
+// It also allows the nodes to elect a leader (the only one handling the sessions)
+type StateStore interface {
+	// Loads a value
+	// key - The key
+	// Returns the value (nil if not found), or an error
+	Load(key string) ([]byte, error)
+
+	// Saves a value
+	// key - The key
+	// value - The value
+	// Returns an error if it could not be saved
+	Save(key string, value []byte) error
+
+	// Tries to acquire the leadership, or renew it if the node is already the leader
+	// nodeId - ID of the node
+	// lease - Duration of the leadership. It must be renewed before it expires
+	// Returns true if the node is the leader, or an error
+	AcquireLeadership(nodeId string, lease time.Duration) (bool, error)
+
+	// Releases the leadership, so another node can acquire it
+	// nodeId - ID of the node
+	// Returns an error if it could not be released
+	ReleaseLeadership(nodeId string) error
+
+	// Gets the path to the directory to store the events outbox file
+	// It must be shared between the nodes, so the new leader can replay the pending events
+	// Returns the directory path
+	GetOutboxPath() string
+}
+
+// State store types
+const (
+	STATE_STORE_FILE = "file" // Files in a directory (shared between the nodes)
+)
+
+// Function to create a state store
+// Returns the store, or an error
+type StateStoreFactory func() (StateStore, error)
+
+// Available state stores. Map: type -> factory
+var STATE_STORES = map[string]StateStoreFactory{
+	STATE_STORE_FILE: CreateFileStateStore,
+}
+
+// Creates the state store from the STATE_STORE environment variable
+// Returns the store, or an error
+func CreateStateStore() (StateStore, error) {
+	storeType := strings.ToLower(os.Getenv("STATE_STORE"))
+
+	if storeType == "" {
+		storeType = STATE_STORE_FILE
+	}
+
+	if STATE_STORES[storeType] == nil {
+		LogWarning("Unknown STATE_STORE: " + storeType + ". Using " + STATE_STORE_FILE)
+		storeType = STATE_STORE_FILE
+	}
+
+	return STATE_STORES[storeType]()
+}
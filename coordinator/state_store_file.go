@@ -0,0 +1,231 @@
+// File state store
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const STATE_STORE_LEADER_FILE = "leader.json"
+const STATE_STORE_LEADER_LOCK_FILE = "leader.lock"
+
+const STATE_STORE_LOCK_STALE_TIME = 10 * time.Second // Time after a lock file is considered abandoned
+
+// State store keeping each value in a file of a directory
+// For multiple nodes, the directory must be shared between them (eg: network file system)
+type FileStateStore struct {
+	path string // Path to the directory
+}
+
+// Leadership lease, stored in the leader file
+type FileStateStoreLease struct {
+	NodeId  string `json:"node_id"` // ID of the leader node
+	Expires int64  `json:"expires"` // Unix timestamp (milliseconds) when the lease expires
+}
+
+// Creates the file state store from the STATE_STORE_PATH environment variable
+// By default, the working directory is used
+// Returns the store, or an error
+func CreateFileStateStore() (StateStore, error) {
+	path := os.Getenv("STATE_STORE_PATH")
+
+	if path == "" {
+		path = "."
+	}
+
+	err := os.MkdirAll(path, FOLDER_PERMISSION)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStateStore{
+		path: path,
+	}, nil
+}
+
+// Gets the path of the file storing a key
+// key - The key (file name)
+// Returns the file path
+func (store *FileStateStore) getFilePath(key string) string {
+	return filepath.Join(store.path, key)
+}
+
+// Gets the path to the directory to store the events outbox
+// The directory of the store is used, so the new leader replays the events pending of being delivered by the previous one
+// Returns the directory path
+func (store *FileStateStore) GetOutboxPath() string {
+	return store.path
+}
+
+// Gets the path of the temporal file to write a key
+// key - The key (file name)
+// Returns the file path
+func (store *FileStateStore) getTempFilePath(key string) string {
+	return filepath.Join(store.path, strings.TrimSuffix(key, filepath.Ext(key))+".tmp")
+}
+
+// Loads a value
+// key - The key
+// Returns the value (nil if not found), or an error
+func (store *FileStateStore) Load(key string) ([]byte, error) {
+	content, err := os.ReadFile(store.getFilePath(key))
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// Saves a value
+// The value is written to a temporal file and then renamed, so it is never left half written
+// key - The key
+// value - The value
+// Returns an error if it could not be saved
+func (store *FileStateStore) Save(key string, value []byte) error {
+	tmpFile := store.getTempFilePath(key)
+
+	err := os.WriteFile(tmpFile, value, FILE_PERMISSION)
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, store.getFilePath(key))
+}
+
+// Locks the leader file, so only a node can modify it at the same time
+// Returns true if locked. Call unlockLeader after it
+func (store *FileStateStore) lockLeader() (bool, error) {
+	lockFile := store.getFilePath(STATE_STORE_LEADER_LOCK_FILE)
+
+	f, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, FILE_PERMISSION)
+
+	if err != nil {
+		if !os.IsExist(err) {
+			return false, err
+		}
+
+		// Remove the lock if the node holding it crashed
+
+		stat, err := os.Stat(lockFile)
+
+		if err == nil && time.Since(stat.ModTime()) > STATE_STORE_LOCK_STALE_TIME {
+			os.Remove(lockFile)
+		}
+
+		return false, nil
+	}
+
+	f.Close()
+
+	return true, nil
+}
+
+// Unlocks the leader file
+func (store *FileStateStore) unlockLeader() {
+	err := os.Remove(store.getFilePath(STATE_STORE_LEADER_LOCK_FILE))
+
+	if err != nil {
+		LogError(err)
+	}
+}
+
+// Reads the current leadership lease
+// Returns the lease (nil if none), or an error
+func (store *FileStateStore) readLease() (*FileStateStoreLease, error) {
+	content, err := store.Load(STATE_STORE_LEADER_FILE)
+
+	if err != nil || content == nil {
+		return nil, err
+	}
+
+	lease := &FileStateStoreLease{}
+
+	err = json.Unmarshal(content, lease)
+
+	if err != nil {
+		LogWarning("Ignored invalid " + STATE_STORE_LEADER_FILE + ": " + err.Error())
+		return nil, nil
+	}
+
+	return lease, nil
+}
+
+// Tries to acquire the leadership, or renew it if the node is already the leader
+// nodeId - ID of the node
+// lease - Duration of the leadership. It must be renewed before it expires
+// Returns true if the node is the leader, or an error
+func (store *FileStateStore) AcquireLeadership(nodeId string, lease time.Duration) (bool, error) {
+	locked, err := store.lockLeader()
+
+	if err != nil || !locked {
+		return false, err
+	}
+
+	defer store.unlockLeader()
+
+	currentLease, err := store.readLease()
+
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UnixMilli()
+
+	if currentLease != nil && currentLease.NodeId != nodeId && currentLease.Expires > now {
+		return false, nil // Another node is the leader
+	}
+
+	content, err := json.Marshal(FileStateStoreLease{
+		NodeId:  nodeId,
+		Expires: now + lease.Milliseconds(),
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	err = store.Save(STATE_STORE_LEADER_FILE, content)
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Releases the leadership, so another node can acquire it
+// nodeId - ID of the node
+// Returns an error if it could not be released
+func (store *FileStateStore) ReleaseLeadership(nodeId string) error {
+	locked, err := store.lockLeader()
+
+	if err != nil {
+		return err
+	}
+
+	if !locked {
+		return errors.New("could not lock " + STATE_STORE_LEADER_FILE)
+	}
+
+	defer store.unlockLeader()
+
+	currentLease, err := store.readLease()
+
+	if err != nil || currentLease == nil || currentLease.NodeId != nodeId {
+		return err
+	}
+
+	return os.Remove(store.getFilePath(STATE_STORE_LEADER_FILE))
+}
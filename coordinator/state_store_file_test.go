@@ -0,0 +1,256 @@
+// Tests for the file state store and the leader election
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Creates a file state store for the tests
+// Each node creates its own store, sharing the directory
+func makeTestFileStateStore(t *testing.T, path string) StateStore {
+	t.Setenv("STATE_STORE_PATH", path)
+
+	store, err := CreateFileStateStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// Tries to acquire the leadership, failing the test on error
+func acquireTestLeadership(t *testing.T, store StateStore, nodeId string, lease time.Duration) bool {
+	acquired, err := store.AcquireLeadership(nodeId, lease)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return acquired
+}
+
+func TestFileStateStoreLeadershipCompetition(t *testing.T) {
+	path := t.TempDir()
+
+	storeA := makeTestFileStateStore(t, path)
+	storeB := makeTestFileStateStore(t, path)
+
+	if !acquireTestLeadership(t, storeA, "node-a", time.Minute) {
+		t.Fatal("node-a should acquire the leadership")
+	}
+
+	if acquireTestLeadership(t, storeB, "node-b", time.Minute) {
+		t.Fatal("node-b should not acquire the leadership while node-a holds it")
+	}
+
+	if !acquireTestLeadership(t, storeA, "node-a", time.Minute) {
+		t.Fatal("node-a should renew the leadership")
+	}
+
+	if acquireTestLeadership(t, storeB, "node-b", time.Minute) {
+		t.Fatal("node-b should not acquire the leadership after node-a renewed it")
+	}
+
+	if _, err := os.Stat(filepath.Join(path, STATE_STORE_LEADER_LOCK_FILE)); !os.IsNotExist(err) {
+		t.Fatal("the lock file should be removed after each operation")
+	}
+}
+
+func TestFileStateStoreLeaseExpiry(t *testing.T) {
+	path := t.TempDir()
+
+	storeA := makeTestFileStateStore(t, path)
+	storeB := makeTestFileStateStore(t, path)
+
+	if !acquireTestLeadership(t, storeA, "node-a", 50*time.Millisecond) {
+		t.Fatal("node-a should acquire the leadership")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if !acquireTestLeadership(t, storeB, "node-b", time.Minute) {
+		t.Fatal("node-b should take over after the lease of node-a expired")
+	}
+
+	if acquireTestLeadership(t, storeA, "node-a", time.Minute) {
+		t.Fatal("node-a should not renew the leadership after node-b took over")
+	}
+}
+
+func TestFileStateStoreReleaseLeadership(t *testing.T) {
+	path := t.TempDir()
+
+	storeA := makeTestFileStateStore(t, path)
+	storeB := makeTestFileStateStore(t, path)
+
+	if !acquireTestLeadership(t, storeA, "node-a", time.Minute) {
+		t.Fatal("node-a should acquire the leadership")
+	}
+
+	// Releasing a leadership held by another node does nothing
+	err := storeB.ReleaseLeadership("node-b")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if acquireTestLeadership(t, storeB, "node-b", time.Minute) {
+		t.Fatal("node-b should not acquire the leadership while node-a holds it")
+	}
+
+	err = storeA.ReleaseLeadership("node-a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !acquireTestLeadership(t, storeB, "node-b", time.Minute) {
+		t.Fatal("node-b should acquire the leadership released by node-a")
+	}
+}
+
+func TestFileStateStoreStaleLock(t *testing.T) {
+	path := t.TempDir()
+
+	store := makeTestFileStateStore(t, path)
+
+	lockFile := filepath.Join(path, STATE_STORE_LEADER_LOCK_FILE)
+
+	err := os.WriteFile(lockFile, []byte{}, FILE_PERMISSION)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A recent lock is held by another node
+
+	if acquireTestLeadership(t, store, "node-a", time.Minute) {
+		t.Fatal("the leadership should not be acquired while the lock is held")
+	}
+
+	if _, err := os.Stat(lockFile); err != nil {
+		t.Fatal("a recent lock file should not be removed")
+	}
+
+	// The node holding the lock crashed
+
+	staleTime := time.Now().Add(-2 * STATE_STORE_LOCK_STALE_TIME)
+
+	err = os.Chtimes(lockFile, staleTime, staleTime)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if acquireTestLeadership(t, store, "node-a", time.Minute) {
+		t.Fatal("the leadership should not be acquired in the same attempt the stale lock is removed")
+	}
+
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Fatal("the stale lock file should be removed")
+	}
+
+	if !acquireTestLeadership(t, store, "node-a", time.Minute) {
+		t.Fatal("the leadership should be acquired after removing the stale lock")
+	}
+}
+
+func TestFileStateStoreChannelsStateFailover(t *testing.T) {
+	path := t.TempDir()
+
+	storeA := makeTestFileStateStore(t, path)
+	storeB := makeTestFileStateStore(t, path)
+
+	// Node A is the leader, with an open channel
+
+	if !acquireTestLeadership(t, storeA, "node-a", 50*time.Millisecond) {
+		t.Fatal("node-a should acquire the leadership")
+	}
+
+	coordA := &Streaming_Coordinator{}
+	coordA.Initialize(storeA)
+
+	if _, err := os.Stat(filepath.Join(path, EVENTS_OUTBOX_FILE)); err != nil {
+		t.Fatal("the events outbox should be stored in the directory of the state store")
+	}
+
+	channelData := coordA.AcquireChannel("test-channel")
+	channelData.closed = false
+	channelData.streamId = "test-stream"
+	channelData.publishMethod = PUBLISH_METHOD_WS
+	channelData.publisher = 1
+	channelData.encoder = 2
+	channelData.sourceURL = "ws://127.0.0.1/test-channel/key"
+	channelData.record = true
+	coordA.UpdateChannelState(channelData)
+	coordA.ReleaseChannel(channelData)
+
+	// Wait for the state to be saved
+
+	for saving := true; saving; {
+		coordA.mutex.Lock()
+		saving = coordA.savingChannelsState
+		coordA.mutex.Unlock()
+
+		time.Sleep(time.Millisecond)
+	}
+
+	// Node A stops renewing, so node B takes over and restores the state
+
+	time.Sleep(100 * time.Millisecond)
+
+	if !acquireTestLeadership(t, storeB, "node-b", time.Minute) {
+		t.Fatal("node-b should take over after the lease of node-a expired")
+	}
+
+	coordB := &Streaming_Coordinator{}
+	coordB.Initialize(storeB)
+
+	restored := coordB.AcquireChannel("test-channel")
+	defer coordB.ReleaseChannel(restored)
+
+	if restored.closed {
+		t.Fatal("the channel should be restored as open")
+	}
+
+	if restored.streamId != "test-stream" || restored.publishMethod != PUBLISH_METHOD_WS || restored.sourceURL != "ws://127.0.0.1/test-channel/key" || !restored.record {
+		t.Fatal("the restored channel does not match the saved one")
+	}
+
+	if restored.publisher != 0 || restored.encoder != 0 {
+		t.Fatal("the restored channel should wait for its publisher and encoder to reconnect")
+	}
+
+	coordB.mutex.Lock()
+	defer coordB.mutex.Unlock()
+
+	if coordB.recoveringStreams["test-channel:test-stream"] == nil {
+		t.Fatal("the restored stream should be recovering")
+	}
+
+	if coordB.lostEncoderStreams["test-channel:test-stream"] != 2 {
+		t.Fatal("the encoder of the restored stream should be able to adopt it")
+	}
+}
+
+func TestGetNodeId(t *testing.T) {
+	t.Setenv("NODE_ID", "")
+
+	nodeA := GetNodeId()
+	nodeB := GetNodeId()
+
+	if nodeA == "" || nodeA == nodeB {
+		t.Fatal("the generated node IDs should be unique")
+	}
+
+	t.Setenv("NODE_ID", "custom-node")
+
+	if GetNodeId() != "custom-node" {
+		t.Fatal("the node ID should be loaded from NODE_ID")
+	}
+}
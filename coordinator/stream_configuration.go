@@ -0,0 +1,134 @@
+// Per-stream configuration (from the key verification API)
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const STREAM_MAX_SEGMENT_DURATION = 60 // Max duration of the HLS fragments allowed for a stream (seconds)
+
+// H.264 profiles allowed for a stream
+var STREAM_CODEC_PROFILES = map[string]bool{
+	"baseline": true,
+	"main":     true,
+	"high":     true,
+}
+
+// Watermark positions
+const (
+	WATERMARK_POSITION_TOP_LEFT     = "top-left"
+	WATERMARK_POSITION_TOP_RIGHT    = "top-right"
+	WATERMARK_POSITION_BOTTOM_LEFT  = "bottom-left"
+	WATERMARK_POSITION_BOTTOM_RIGHT = "bottom-right"
+)
+
+// Watermark positions allowed for a stream
+var STREAM_WATERMARK_POSITIONS = map[string]bool{
+	WATERMARK_POSITION_TOP_LEFT:     true,
+	WATERMARK_POSITION_TOP_RIGHT:    true,
+	WATERMARK_POSITION_BOTTOM_LEFT:  true,
+	WATERMARK_POSITION_BOTTOM_RIGHT: true,
+}
+
+// Text watermark to draw over the video
+type StreamWatermark struct {
+	Text     string `json:"text"`               // Text to draw
+	Position string `json:"position,omitempty"` // Position: top-left, top-right, bottom-left or bottom-right (default)
+}
+
+// Extra configuration of a stream, returned by the key verification API
+type StreamConfiguration struct {
+	MaxDuration       int               `json:"maxDuration,omitempty"`       // Max duration of the stream (seconds). 0 = No limit
+	SegmentDuration   int               `json:"segmentDuration,omitempty"`   // Duration of the HLS fragments (seconds). 0 = Encoder default
+	CodecProfile      string            `json:"codecProfile,omitempty"`      // H.264 profile: baseline, main or high. Empty = Encoder default
+	Watermark         *StreamWatermark  `json:"watermark,omitempty"`         // Text watermark. nil = No watermark
+	Labels            map[string]string `json:"labels,omitempty"`            // Custom labels, added as metadata to the encoded stream
+	EncoderTags       []string          `json:"encoderTags,omitempty"`       // Tags the encoder must have to be assigned to the stream
+	PublisherTakeover bool              `json:"publisherTakeover,omitempty"` // True to let a new publisher take over the channel, instead of denying it
+	BackupIngest      bool              `json:"backupIngest,omitempty"`      // True to accept a second publisher for the channel as backup, instead of denying it
+	QueuePriority     int               `json:"queuePriority,omitempty"`     // Priority in the publish queue (higher first), if PUBLISH_QUEUE_ORDER is PRIORITY
+}
+
+// Response body of the key verification API (optional)
+// Fields not present are taken from the response headers
+type KeyVerificationResponseBody struct {
+	Record      *bool   `json:"record"`      // True if recording is enabled
+	Resolutions *string `json:"resolutions"` // List of resolutions, same format as the x-resolutions header
+	Previews    *string `json:"previews"`    // Previews configuration, same format as the x-previews header
+
+	StreamConfiguration
+}
+
+// Validates the configuration, removing any invalid values
+// channel - The channel (for logging)
+func (config *StreamConfiguration) Sanitize(channel string) {
+	if config.MaxDuration < 0 {
+		config.MaxDuration = 0
+	}
+
+	if config.SegmentDuration < 0 || config.SegmentDuration > STREAM_MAX_SEGMENT_DURATION {
+		LogWarning("Ignored invalid segment duration for channel " + channel)
+		config.SegmentDuration = 0
+	}
+
+	config.CodecProfile = strings.ToLower(config.CodecProfile)
+
+	if config.CodecProfile != "" && !STREAM_CODEC_PROFILES[config.CodecProfile] {
+		LogWarning("Ignored invalid codec profile for channel " + channel + ": " + config.CodecProfile)
+		config.CodecProfile = ""
+	}
+
+	if config.Watermark != nil {
+		if config.Watermark.Text == "" {
+			config.Watermark = nil
+		} else {
+			config.Watermark.Position = strings.ToLower(config.Watermark.Position)
+
+			if !STREAM_WATERMARK_POSITIONS[config.Watermark.Position] {
+				config.Watermark.Position = WATERMARK_POSITION_BOTTOM_RIGHT
+			}
+		}
+	}
+
+	tags := make([]string, 0, len(config.EncoderTags))
+
+	for i := 0; i < len(config.EncoderTags); i++ {
+		tag := strings.ToLower(strings.Trim(config.EncoderTags[i], " "))
+
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	config.EncoderTags = tags
+}
+
+// Adds the encoding parameters to the ENCODE-START message
+// params - The message parameters
+func (config *StreamConfiguration) AddEncodeStartParams(params map[string]string) {
+	if config.SegmentDuration > 0 {
+		params["Segment-Duration"] = fmt.Sprint(config.SegmentDuration)
+	}
+
+	if config.CodecProfile != "" {
+		params["Codec-Profile"] = config.CodecProfile
+	}
+
+	if config.Watermark != nil {
+		params["Watermark"] = url.QueryEscape(config.Watermark.Text)
+		params["Watermark-Position"] = config.Watermark.Position
+	}
+
+	if len(config.Labels) > 0 {
+		metadata := url.Values{}
+
+		for key, value := range config.Labels {
+			metadata.Set(key, value)
+		}
+
+		params["Metadata"] = metadata.Encode()
+	}
+}
@@ -0,0 +1,115 @@
+// Max stream duration enforcement
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const STREAM_DURATION_DEFAULT_WARNING_TIME = 60 // Default time before the max duration to send the warning event (seconds)
+
+// Starts the timers to enforce the max duration of a stream
+// channel - The channel
+// streamId - The stream ID
+// maxDuration - Max duration of the stream (seconds). 0 = No limit
+// elapsed - Time the stream has already been published (greater than 0 when recovering after a restart)
+func (server *Streaming_Coordinator_Server) StartStreamDurationLimit(channel string, streamId string, maxDuration int, elapsed time.Duration) {
+	if maxDuration <= 0 {
+		return
+	}
+
+	remaining := time.Duration(maxDuration)*time.Second - elapsed
+
+	warningTime := server.coordinator.maxDurationWarningTime
+
+	if warningTime > 0 && remaining > warningTime {
+		time.AfterFunc(remaining-warningTime, func() {
+			server.onStreamDurationWarning(channel, streamId, warningTime)
+		})
+	}
+
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	time.AfterFunc(remaining, func() {
+		server.onStreamDurationExceeded(channel, streamId)
+	})
+}
+
+// Called shortly before a stream reaches its max duration
+// Sends the stream-duration-warning event
+// channel - The channel
+// streamId - The stream ID
+// remaining - Remaining time until the stream is closed
+func (server *Streaming_Coordinator_Server) onStreamDurationWarning(channel string, streamId string, remaining time.Duration) {
+	channelData := server.coordinator.AcquireChannel(channel)
+	isOpen := !channelData.closed && channelData.streamId == streamId
+	server.coordinator.ReleaseChannel(channelData)
+
+	if !isOpen {
+		return
+	}
+
+	remainingSeconds := int64(remaining / time.Second)
+
+	LogInfo("[MAX-DURATION] Stream " + channel + "/" + streamId + " will be closed in " + fmt.Sprint(remainingSeconds) + " seconds")
+
+	event := &CallbackEvent{
+		Id:            GenerateEventId(),
+		Timestamp:     time.Now().UnixMilli(),
+		EventType:     "stream-duration-warning",
+		Channel:       channel,
+		StreamId:      streamId,
+		RemainingTime: remainingSeconds,
+	}
+
+	deliveries := server.coordinator.EnqueueEvent(event)
+
+	go server.coordinator.SendEventDeliveries(deliveries, func() bool {
+		return false
+	})
+
+	server.coordinator.liveEvents.Publish(&LiveEvent{
+		EventType:     LIVE_EVENT_STREAM_DURATION_WARNING,
+		Channel:       channel,
+		StreamId:      streamId,
+		RemainingTime: remainingSeconds,
+	})
+}
+
+// Called when a stream reaches its max duration
+// Kills the publisher and stops the encoder
+// channel - The channel
+// streamId - The stream ID
+func (server *Streaming_Coordinator_Server) onStreamDurationExceeded(channel string, streamId string) {
+	channelData := server.coordinator.AcquireChannel(channel)
+	defer server.coordinator.ReleaseChannel(channelData)
+
+	if channelData.closed || channelData.streamId != streamId {
+		return
+	}
+
+	LogInfo("[MAX-DURATION] Stream " + channel + "/" + streamId + " reached its max duration. Closing the stream.")
+
+	channelData.SetCloseReason(MakeStreamCloseReason(CLOSE_REASON_MAX_DURATION, "The stream reached its max duration of "+fmt.Sprint(channelData.config.MaxDuration)+" seconds"))
+
+	channelData.closed = true
+
+	server.coordinator.UpdateChannelState(channelData)
+
+	pubSession := server.GetSession(channelData.publisher)
+
+	if pubSession != nil {
+		pubSession.SendStreamKill(channel, streamId)
+		pubSession.DisassociateChannel(channel)
+	}
+
+	encoderSession := server.GetSession(channelData.encoder)
+
+	if encoderSession != nil {
+		encoderSession.SendEncodeStop(channel, streamId)
+		encoderSession.DisassociateChannel(channel)
+	}
+}
@@ -0,0 +1,127 @@
+// Source stream information (sent by the encoders after probing the stream)
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	messages "github.com/AgustinSRG/go-simple-rpc-message"
+)
+
+// Information of the source stream, as received by the encoder
+type StreamSourceInfo struct {
+	Format  string `json:"format,omitempty"`  // Container format
+	Bitrate int64  `json:"bitrate,omitempty"` // Total bitrate (bits/s)
+
+	VideoCodec   string  `json:"videoCodec,omitempty"`   // Video codec
+	VideoProfile string  `json:"videoProfile,omitempty"` // Video codec profile
+	Width        int     `json:"width,omitempty"`        // Video width (pixels)
+	Height       int     `json:"height,omitempty"`       // Video height (pixels)
+	FrameRate    float64 `json:"frameRate,omitempty"`    // Video frame rate (frames/s)
+	VideoBitrate int64   `json:"videoBitrate,omitempty"` // Video bitrate (bits/s)
+
+	AudioCodec      string `json:"audioCodec,omitempty"`      // Audio codec
+	AudioSampleRate int    `json:"audioSampleRate,omitempty"` // Audio sample rate (Hz)
+	AudioChannels   int    `json:"audioChannels,omitempty"`   // Number of audio channels
+	AudioBitrate    int64  `json:"audioBitrate,omitempty"`    // Audio bitrate (bits/s)
+}
+
+// Parses the source stream information from a STREAM-INFO message
+// Invalid or missing values are left empty
+// msg - The message
+// Returns the information
+func ParseStreamSourceInfo(msg *messages.RPCMessage) *StreamSourceInfo {
+	parseInt := func(name string) int64 {
+		n, err := strconv.ParseInt(msg.GetParam(name), 10, 64)
+
+		if err != nil || n < 0 {
+			return 0
+		}
+
+		return n
+	}
+
+	frameRate, err := strconv.ParseFloat(msg.GetParam("Frame-Rate"), 64)
+
+	if err != nil || frameRate < 0 {
+		frameRate = 0
+	}
+
+	return &StreamSourceInfo{
+		Format:          msg.GetParam("Format"),
+		Bitrate:         parseInt("Bitrate"),
+		VideoCodec:      msg.GetParam("Video-Codec"),
+		VideoProfile:    msg.GetParam("Video-Profile"),
+		Width:           int(parseInt("Width")),
+		Height:          int(parseInt("Height")),
+		FrameRate:       frameRate,
+		VideoBitrate:    parseInt("Video-Bitrate"),
+		AudioCodec:      msg.GetParam("Audio-Codec"),
+		AudioSampleRate: int(parseInt("Audio-Sample-Rate")),
+		AudioChannels:   int(parseInt("Audio-Channels")),
+		AudioBitrate:    parseInt("Audio-Bitrate"),
+	}
+}
+
+// Sets the headers of the stream-info event callback
+// Unknown values are not included
+// header - The request headers
+func (info *StreamSourceInfo) SetHeaders(header http.Header) {
+	setString := func(name string, value string) {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+
+	setInt := func(name string, value int64) {
+		if value > 0 {
+			header.Set(name, fmt.Sprint(value))
+		}
+	}
+
+	setString("x-source-format", info.Format)
+	setInt("x-source-bitrate", info.Bitrate)
+
+	setString("x-video-codec", info.VideoCodec)
+	setString("x-video-profile", info.VideoProfile)
+	setInt("x-video-width", int64(info.Width))
+	setInt("x-video-height", int64(info.Height))
+
+	if info.FrameRate > 0 {
+		header.Set("x-video-frame-rate", strconv.FormatFloat(info.FrameRate, 'f', 2, 64))
+	}
+
+	setInt("x-video-bitrate", info.VideoBitrate)
+
+	setString("x-audio-codec", info.AudioCodec)
+	setInt("x-audio-sample-rate", int64(info.AudioSampleRate))
+	setInt("x-audio-channels", int64(info.AudioChannels))
+	setInt("x-audio-bitrate", info.AudioBitrate)
+}
+
+// Handles STREAM-INFO message
+// channel - The channel
+// streamId - The stream ID
+// info - Information of the source stream
+func (session *ControlSession) HandleStreamInfo(channel string, streamId string, info *StreamSourceInfo) {
+	if session.sessionType != SESSION_TYPE_HLS {
+		return
+	}
+
+	channelData := session.server.coordinator.AcquireChannel(channel)
+
+	if channelData.closed || channelData.streamId != streamId || channelData.encoder != session.id {
+		session.server.coordinator.ReleaseChannel(channelData)
+		return
+	}
+
+	channelData.sourceInfo = info
+
+	session.server.coordinator.UpdateChannelState(channelData)
+
+	session.server.coordinator.ReleaseChannel(channelData)
+
+	session.server.coordinator.OnStreamInfo(channel, streamId, info)
+}
@@ -0,0 +1,132 @@
+// Per-stream live statistics
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats of a publisher, reported by a streaming server
+type PublisherStats struct {
+	channel  string // Channel ID
+	streamId string // Stream ID
+	bitrate  uint64 // Bitrate of the source stream (bits/s)
+}
+
+// Live statistics of a stream
+type StreamStats struct {
+	Uptime           int64   `json:"uptime"`           // Time since the stream started (seconds)
+	SourceBitrate    uint64  `json:"sourceBitrate"`    // Bitrate of the source stream, reported by the streaming server (bits/s). 0 if unknown
+	FPS              float64 `json:"fps"`              // Encoding frames per second
+	Speed            float64 `json:"speed"`            // Encoding speed, relative to real time (1 = real time)
+	DroppedFrames    uint64  `json:"droppedFrames"`    // Number of dropped frames
+	Renditions       int     `json:"renditions"`       // Number of renditions being encoded
+	Fragments        int     `json:"fragments"`        // Number of HLS fragments produced
+	LastFragmentTime int64   `json:"lastFragmentTime"` // Unix timestamp (milliseconds) of the last fragment. 0 if none yet
+}
+
+// Parses the list of publisher stats sent by the streaming server in the PUBLISHER-STATS message
+// str - The list. Format: {CHANNEL}:{STREAM_ID}:{BITRATE}, split by commas
+// Returns the parsed list. Invalid entries are ignored
+func ParsePublisherStatsList(str string) []PublisherStats {
+	result := make([]PublisherStats, 0)
+
+	if str == "" {
+		return result
+	}
+
+	entries := strings.Split(str, ",")
+
+	for i := 0; i < len(entries); i++ {
+		parts := strings.Split(strings.Trim(entries[i], " "), ":")
+
+		if len(parts) != 3 {
+			continue
+		}
+
+		bitrate, err := strconv.ParseUint(parts[2], 10, 64)
+
+		if err != nil {
+			continue
+		}
+
+		result = append(result, PublisherStats{
+			channel:  parts[0],
+			streamId: parts[1],
+			bitrate:  bitrate,
+		})
+	}
+
+	return result
+}
+
+// Handles PUBLISHER-STATS message
+// publishers - Stats of the publishers reported by the streaming server
+func (session *ControlSession) HandlePublisherStats(publishers []PublisherStats) {
+	if session.sessionType != SESSION_TYPE_RTMP && session.sessionType != SESSION_TYPE_WSS {
+		return
+	}
+
+	for i := 0; i < len(publishers); i++ {
+		channelData := session.server.coordinator.AcquireChannel(publishers[i].channel)
+
+		if !channelData.closed && channelData.streamId == publishers[i].streamId && channelData.publisher == session.id {
+			channelData.sourceBitrate = publishers[i].bitrate
+			session.checkPublisherStall(channelData)
+		}
+
+		session.server.coordinator.ReleaseChannel(channelData)
+	}
+}
+
+// Gets the stats of the encoding tasks, reported by the encoders
+// Returns a map: {ENCODER_ID}:{CHANNEL}:{STREAM_ID} -> Task stats
+func (coord *Streaming_Coordinator) GetEncoderTaskStatsMap() map[string]EncoderTaskStats {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	result := make(map[string]EncoderTaskStats)
+
+	for _, encoder := range coord.hlsEncoders {
+		if encoder.stats == nil {
+			continue
+		}
+
+		for i := 0; i < len(encoder.stats.tasks); i++ {
+			result[fmt.Sprint(encoder.id)+":"+encoder.stats.tasks[i].channel+":"+encoder.stats.tasks[i].streamId] = encoder.stats.tasks[i]
+		}
+	}
+
+	return result
+}
+
+// Gets the live stats of the current stream of a channel
+// Must be called with the channel acquired
+// channelData - The channel
+// taskStats - Stats of the encoding tasks (from GetEncoderTaskStatsMap)
+// Returns the stats
+func (channelData *StreamingChannel) GetStats(taskStats map[string]EncoderTaskStats) StreamStats {
+	stats := StreamStats{
+		SourceBitrate: channelData.sourceBitrate,
+	}
+
+	if channelData.encodeStartTime > 0 {
+		stats.Uptime = (time.Now().UnixMilli() - channelData.encodeStartTime) / 1000
+	}
+
+	task, found := taskStats[fmt.Sprint(channelData.encoder)+":"+channelData.id+":"+channelData.streamId]
+
+	if found {
+		stats.FPS = task.fps
+		stats.Speed = task.speed
+		stats.DroppedFrames = task.droppedFrames
+		stats.Renditions = task.renditions
+		stats.Fragments = task.fragments
+		stats.LastFragmentTime = task.lastFragmentTime
+	}
+
+	return stats
+}
@@ -0,0 +1,505 @@
+// Streaming server drain mode and publisher migration
+
+package main
+
+import (
+	"fmt"
+	mathRand "math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	messages "github.com/AgustinSRG/go-simple-rpc-message"
+)
+
+const PUBLISHER_MIGRATION_DEFAULT_GRACE_PERIOD = 30 // Default time to wait for a migrating publisher to reconnect (seconds)
+
+// Sets the drain mode of a streaming server
+// A draining server does not accept new publishers
+// id - Server ID
+// draining - True to drain the server, false to cancel the drain
+// Returns true if the server was found
+func (coord *Streaming_Coordinator) SetStreamingServerDrain(id uint64, draining bool) bool {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	var serverType string
+
+	if coord.rtmpServers[id] != nil {
+		coord.rtmpServers[id].draining = draining
+		serverType = GetStreamingServerTypeName(SESSION_TYPE_RTMP)
+	} else if coord.wssServers[id] != nil {
+		coord.wssServers[id].draining = draining
+		serverType = GetStreamingServerTypeName(SESSION_TYPE_WSS)
+	} else {
+		return false
+	}
+
+	eventType := LIVE_EVENT_SERVER_DRAIN
+
+	if !draining {
+		eventType = LIVE_EVENT_SERVER_DRAIN_CANCEL
+	}
+
+	coord.liveEvents.Publish(&LiveEvent{
+		EventType:  eventType,
+		ServerId:   id,
+		ServerType: serverType,
+	})
+
+	return true
+}
+
+// Checks if a streaming server is draining
+// id - Server ID
+// Returns true if the server is draining
+func (coord *Streaming_Coordinator) IsStreamingServerDraining(id uint64) bool {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	if coord.rtmpServers[id] != nil {
+		return coord.rtmpServers[id].draining
+	}
+
+	if coord.wssServers[id] != nil {
+		return coord.wssServers[id].draining
+	}
+
+	return false
+}
+
+// Generates the base URL of a streaming server
+// sessionType - Type of server
+// ip - Server IP
+// port - Server port
+// ssl - True if the server uses SSL
+// Returns the URL
+func GetStreamingServerBaseURL(sessionType int, ip string, port int, ssl bool) string {
+	if strings.Contains(ip, ":") {
+		// IPV6 should be wrapped
+		ip = "[" + ip + "]"
+	}
+
+	scheme := ""
+
+	switch sessionType {
+	case SESSION_TYPE_RTMP:
+		scheme = "rtmp"
+	case SESSION_TYPE_WSS:
+		scheme = "ws"
+	default:
+		return ""
+	}
+
+	if ssl {
+		scheme += "s"
+	}
+
+	return scheme + "://" + ip + ":" + fmt.Sprint(port) + "/"
+}
+
+// Chooses a streaming server for the publishers of a draining server to migrate to
+// A random server of the same type, not draining, is chosen
+// id - ID of the draining server
+// Returns the base URL of the chosen server, or an empty string if there are no other servers
+func (coord *Streaming_Coordinator) GetMigrationURL(id uint64) string {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	candidates := make([]string, 0)
+
+	if coord.rtmpServers[id] != nil {
+		for _, server := range coord.rtmpServers {
+			if server.id != id && !server.draining {
+				candidates = append(candidates, GetStreamingServerBaseURL(SESSION_TYPE_RTMP, server.ip, server.port, server.ssl))
+			}
+		}
+	} else if coord.wssServers[id] != nil {
+		for _, server := range coord.wssServers {
+			if server.id != id && !server.draining {
+				candidates = append(candidates, GetStreamingServerBaseURL(SESSION_TYPE_WSS, server.ip, server.port, server.ssl))
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	return candidates[mathRand.IntN(len(candidates))]
+}
+
+// Registers a stream whose publisher was asked to migrate to another streaming server
+// channel - The channel
+// streamId - The stream ID
+// serverId - ID of the draining server
+func (coord *Streaming_Coordinator) AddMigratingStream(channel string, streamId string, serverId uint64) {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	coord.migratingStreams[channel+":"+streamId] = serverId
+}
+
+// Gets the draining server of a migrating stream
+// channel - The channel
+// streamId - The stream ID
+// Returns the ID of the draining server, and true if the stream is migrating
+func (coord *Streaming_Coordinator) GetMigratingStream(channel string, streamId string) (uint64, bool) {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	serverId, found := coord.migratingStreams[channel+":"+streamId]
+
+	return serverId, found
+}
+
+// Removes a stream from the list of migrating streams
+// channel - The channel
+// streamId - The stream ID
+// Returns true if the stream was in the list
+func (coord *Streaming_Coordinator) TakeMigratingStream(channel string, streamId string) bool {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+
+	id := channel + ":" + streamId
+
+	_, found := coord.migratingStreams[id]
+
+	delete(coord.migratingStreams, id)
+
+	return found
+}
+
+// Starts draining a streaming server
+// The publishers connected to the server are asked to migrate to another server
+// id - Server ID
+// migrateURL - URL for the publishers to reconnect to. If empty, another server of the same type is chosen
+// Returns true if the server was found
+func (server *Streaming_Coordinator_Server) DrainStreamingServer(id uint64, migrateURL string) bool {
+	if !server.coordinator.SetStreamingServerDrain(id, true) {
+		return false
+	}
+
+	if migrateURL == "" {
+		migrateURL = server.coordinator.GetMigrationURL(id)
+	}
+
+	serverSession := server.GetSession(id)
+
+	if serverSession == nil {
+		return true
+	}
+
+	associatedChannels := serverSession.GetAssociatedChannels()
+
+	LogInfo("[DRAIN] Draining streaming server #" + fmt.Sprint(id) + ". Asking " + fmt.Sprint(len(associatedChannels)) + " publishers to migrate to: " + migrateURL)
+
+	for i := 0; i < len(associatedChannels); i++ {
+		channelData := server.coordinator.AcquireChannel(associatedChannels[i])
+
+		if !channelData.closed && channelData.publisher == id {
+			server.coordinator.AddMigratingStream(channelData.id, channelData.streamId, id)
+			serverSession.SendPublishMigrate(channelData.id, channelData.streamId, migrateURL)
+		}
+
+		server.coordinator.ReleaseChannel(channelData)
+	}
+
+	return true
+}
+
+// Cancels the drain mode of a streaming server
+// The publishers still connected to the server are no longer considered migrating
+// id - Server ID
+// Returns true if the server was found
+func (server *Streaming_Coordinator_Server) CancelStreamingServerDrain(id uint64) bool {
+	if !server.coordinator.SetStreamingServerDrain(id, false) {
+		return false
+	}
+
+	LogInfo("[DRAIN] Cancelled drain of streaming server #" + fmt.Sprint(id))
+
+	serverSession := server.GetSession(id)
+
+	if serverSession == nil {
+		return true
+	}
+
+	associatedChannels := serverSession.GetAssociatedChannels()
+
+	for i := 0; i < len(associatedChannels); i++ {
+		channelData := server.coordinator.AcquireChannel(associatedChannels[i])
+
+		if !channelData.closed && channelData.publisher == id {
+			server.coordinator.TakeMigratingStream(channelData.id, channelData.streamId)
+		}
+
+		server.coordinator.ReleaseChannel(channelData)
+	}
+
+	return true
+}
+
+// Detaches the publisher of a migrating stream, after it disconnected from the draining server
+// The stream is kept open, waiting for the publisher to reconnect (PUBLISHER_MIGRATION_GRACE_SECONDS)
+// Must be called with the channel acquired
+// channelData - The channel
+// encoderSession - Session of the encoder of the stream (nil if not connected)
+func (server *Streaming_Coordinator_Server) detachMigratingPublisher(channelData *StreamingChannel, encoderSession *ControlSession) {
+	channelData.publisher = 0
+	channelData.sourceBitrate = 0
+
+	server.coordinator.UpdateChannelState(channelData)
+
+	// The source is gone, so the encoding is restarted when the publisher reconnects
+	if encoderSession != nil {
+		encoderSession.SendEncodeStop(channelData.id, channelData.streamId)
+	}
+
+	LogInfo("[MIGRATE] Publisher of " + channelData.id + "/" + channelData.streamId + " disconnected. Waiting for it to reconnect.")
+
+	go server.WaitForPublisherMigration(channelData.id, channelData.streamId)
+}
+
+// Waits for the publisher of a migrating stream to reconnect (PUBLISHER_MIGRATION_GRACE_SECONDS)
+// If the publisher does not reconnect, the stream is closed
+// channel - The channel
+// streamId - The stream ID
+func (server *Streaming_Coordinator_Server) WaitForPublisherMigration(channel string, streamId string) {
+	if server.coordinator.publisherMigrationGracePeriod > 0 {
+		time.Sleep(server.coordinator.publisherMigrationGracePeriod)
+	}
+
+	channelData := server.coordinator.AcquireChannel(channel)
+	defer server.coordinator.ReleaseChannel(channelData)
+
+	drainingServer, migrating := server.coordinator.GetMigratingStream(channel, streamId)
+
+	if !migrating || channelData.closed || channelData.streamId != streamId {
+		return
+	}
+
+	if channelData.publisher != 0 && (channelData.publisher != drainingServer || channelData.encoder != 0) {
+		return // Reconnected, or still publishing in the draining server
+	}
+
+	server.coordinator.TakeMigratingStream(channel, streamId)
+
+	LogInfo("[MIGRATE] Publisher of " + channel + "/" + streamId + " did not reconnect. Closing the stream.")
+
+	channelData.closed = true
+	channelData.SetCloseReason(MakeStreamCloseReason(CLOSE_REASON_PUBLISHER_ENDED, "The publisher did not reconnect after being asked to migrate"))
+
+	server.coordinator.UpdateChannelState(channelData)
+
+	if channelData.publisher != 0 {
+		pubSession := server.GetSession(channelData.publisher)
+
+		if pubSession != nil {
+			pubSession.SendStreamKill(channel, streamId)
+			pubSession.DisassociateChannel(channel)
+		}
+	}
+
+	if channelData.encoder != 0 {
+		return // The stream is closed when the encoder reports it (STREAM-CLOSED)
+	}
+
+	server.coordinator.OnActiveStreamClosed(channel, streamId, channelData.GetCloseReason(streamId))
+
+	server.releaseBackupPublisher(channelData, server.GetSession(channelData.backupPublisher))
+
+	// Cancel any stream-available events
+	for _, event := range channelData.pendingEvents {
+		event.cancelled = true
+	}
+}
+
+// Restarts the encoding of a migrating stream, after the publisher reconnected (or was replaced)
+// The stream keeps the same ID, and the new encoding task continues the fragment numbering
+// Must be called with the channel acquired
+// channelData - The channel
+// encoderServer - The encoder assigned to the stream
+func (server *Streaming_Coordinator_Server) restartMigratedEncoding(channelData *StreamingChannel, encoderServer *ControlSession) {
+	channel := channelData.id
+	streamId := channelData.streamId
+
+	encoderServer.AssociateChannel(channel)
+	channelData.encoder = encoderServer.id
+
+	server.coordinator.UpdateChannelState(channelData)
+
+	server.coordinator.TakeMigratingStream(channel, streamId)
+
+	resumeTime := float64(time.Now().UnixMilli()-channelData.encodeStartTime) / 1000
+
+	encoderServer.SendEncodeStart(channel, streamId, channelData.publishMethod, channelData.sourceURL, channelData.resolutions, channelData.record, channelData.previews, channelData.config, resumeTime)
+
+	LogInfo("[MIGRATE] Resumed " + channel + "/" + streamId + " from streaming server #" + fmt.Sprint(channelData.publisher) + " in encoder #" + fmt.Sprint(encoderServer.id))
+
+	server.coordinator.OnActiveStreamInterrupted(channel, streamId, encoderServer.id)
+
+	server.coordinator.liveEvents.Publish(&LiveEvent{
+		EventType: LIVE_EVENT_ENCODE_START,
+		Channel:   channel,
+		StreamId:  streamId,
+		EncoderId: encoderServer.id,
+	})
+}
+
+// Handles the end of the encoding task of a migrating stream
+// If the publisher already reconnected, the encoding is restarted. Otherwise, the stream waits for it
+// Must be called with the channel acquired
+// session - The encoder session
+// channelData - The channel
+// drainingServer - ID of the draining streaming server
+func (session *ControlSession) onMigratingStreamEncodingEnded(channelData *StreamingChannel, drainingServer uint64) {
+	session.DisassociateChannel(channelData.id)
+	channelData.encoder = 0
+
+	server := session.server
+
+	if channelData.publisher == 0 || channelData.publisher == drainingServer {
+		// Waiting for the publisher to reconnect
+		server.coordinator.UpdateChannelState(channelData)
+
+		session.log("STREAM-CLOSED: " + channelData.id + "/" + channelData.streamId + " | WAITING FOR THE PUBLISHER TO MIGRATE")
+
+		if channelData.publisher != 0 {
+			go server.WaitForPublisherMigration(channelData.id, channelData.streamId)
+		}
+
+		return
+	}
+
+	encoderServer := server.AssignAvailableEncoder(channelData.id, channelData.config.EncoderTags)
+
+	if encoderServer == nil {
+		LogWarning("[MIGRATE] No encoders available for " + channelData.id + "/" + channelData.streamId + ". Closing the stream.")
+
+		server.coordinator.TakeMigratingStream(channelData.id, channelData.streamId)
+
+		channelData.closed = true
+		channelData.SetCloseReason(MakeStreamCloseReason(CLOSE_REASON_ENCODER_LOST, "No encoders available to continue the stream after the publisher migrated"))
+
+		server.coordinator.UpdateChannelState(channelData)
+
+		closeReason := channelData.GetCloseReason(channelData.streamId)
+
+		server.coordinator.OnStreamFailed(channelData.id, channelData.streamId, closeReason)
+		server.coordinator.OnActiveStreamClosed(channelData.id, channelData.streamId, closeReason)
+
+		pubSession := server.GetSession(channelData.publisher)
+
+		if pubSession != nil {
+			pubSession.SendStreamKill(channelData.id, channelData.streamId)
+		}
+
+		server.releaseBackupPublisher(channelData, server.GetSession(channelData.backupPublisher))
+
+		// Cancel any stream-available events
+		for _, event := range channelData.pendingEvents {
+			event.cancelled = true
+		}
+
+		return
+	}
+
+	server.restartMigratedEncoding(channelData, encoderServer)
+}
+
+// Resumes a migrating stream, after its publisher reconnected to another streaming server
+// The stream keeps the same ID
+// Must be called with the channel acquired. The channel is released
+// requestId - Request ID
+// channelData - The channel
+// key - The streaming key
+// ip - User IP
+func (session *ControlSession) ResumeMigratingStream(requestId string, channelData *StreamingChannel, key string, ip string) {
+	channel := channelData.id
+	streamId := channelData.streamId
+
+	if channelData.publisher == session.id {
+		// Already resumed in this server
+		session.server.coordinator.ReleaseChannel(channelData)
+		session.DenyPublish(requestId, channel, ip, PUBLISH_DENY_REASON_ALREADY_PUBLISHING)
+		return
+	}
+
+	if session.ReplaceStreamPublisher(requestId, channelData, key, ip) {
+		session.log("PUBLISHER MIGRATED: " + channel + "/" + streamId)
+	}
+}
+
+// Sends a PUBLISH-MIGRATE message
+// channel - The channel
+// streamId - The stream ID
+// migrateURL - Base URL of the streaming server to reconnect to. Empty if unknown
+func (session *ControlSession) SendPublishMigrate(channel string, streamId string, migrateURL string) {
+	params := make(map[string]string)
+
+	params["Stream-Channel"] = channel
+	params["Stream-ID"] = streamId
+
+	if migrateURL != "" {
+		params["Migrate-URL"] = migrateURL
+	}
+
+	msg := messages.RPCMessage{
+		Method: "PUBLISH-MIGRATE",
+		Params: params,
+	}
+
+	err := session.Send(msg)
+
+	if err != nil {
+		LogError(err)
+	}
+}
+
+// Runs the streaming server drain command
+// w - Writer to send the response
+// req - Client request
+func (server *Streaming_Coordinator_Server) RunStreamingServerDrainCommand(w http.ResponseWriter, req *http.Request) {
+	authentication := req.Header.Get("Authorization")
+
+	if !CheckCommandAuthentication(authentication) {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Invalid authorization header.")
+		return
+	}
+
+	id, err := strconv.ParseUint(req.Header.Get("x-server-id"), 10, 64)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Invalid x-server-id header.")
+		return
+	}
+
+	migrateURL := req.Header.Get("x-migrate-url")
+
+	if strings.ContainsAny(migrateURL, "\r\n") {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Invalid x-migrate-url header.")
+		return
+	}
+
+	var found bool
+
+	if req.Header.Get("x-drain-cancel") == "true" {
+		found = server.CancelStreamingServerDrain(id)
+	} else {
+		found = server.DrainStreamingServer(id, migrateURL)
+	}
+
+	if !found {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Streaming server not found.")
+		return
+	}
+
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "SUCCESS")
+}
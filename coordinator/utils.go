@@ -40,3 +40,45 @@ func validateStreamIDString(str string) bool {
 
 	return m
 }
+
+// Reads an integer from an environment variable
+// name - Name of the environment variable
+// defaultValue - Value to return if the variable is not set or not valid
+// Returns the integer value
+func getEnvInt(name string, defaultValue int) int {
+	str := os.Getenv(name)
+
+	if str == "" {
+		return defaultValue
+	}
+
+	n, e := strconv.Atoi(str)
+
+	if e != nil {
+		LogWarning("Invalid value for " + name + ": " + str)
+		return defaultValue
+	}
+
+	return n
+}
+
+// Reads a decimal number from an environment variable
+// name - Name of the environment variable
+// defaultValue - Value to return if the variable is not set or not valid
+// Returns the number
+func getEnvFloat(name string, defaultValue float64) float64 {
+	str := os.Getenv(name)
+
+	if str == "" {
+		return defaultValue
+	}
+
+	n, e := strconv.ParseFloat(str, 64)
+
+	if e != nil {
+		LogWarning("Invalid value for " + name + ": " + str)
+		return defaultValue
+	}
+
+	return n
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -19,12 +20,15 @@ import (
 type ControlServerConnection struct {
 	server *HLS_Encoder_Server // Reference to the RTMP server
 
-	connectionURL string          // Connection URL
-	connection    *websocket.Conn // Websocket connection
+	connectionURLs     []string        // Connection URLs (one per coordinator node)
+	connectionURLIndex int             // Index of the URL to connect to
+	connection         *websocket.Conn // Websocket connection
 
 	lock *sync.Mutex // Mutex to control access to this struct
 
 	enabled bool // True if the connection is enabled (will reconnect)
+
+	cpuMeter *CPUUsageMeter // Meter to measure the CPU usage
 }
 
 // Initializes connection
@@ -41,26 +45,43 @@ func (c *ControlServerConnection) Initialize(server *HLS_Encoder_Server) {
 		return
 	}
 
-	connectionURL, err := url.Parse(baseURL)
+	pathURL, err := url.Parse("/ws/control/hls")
 	if err != nil {
 		LogError(err)
 		LogWarning("CONTROL_BASE_URL not provided. The encoding server will not work if not connected to a coordinator server.")
 		c.enabled = false
 		return
 	}
-	pathURL, err := url.Parse("/ws/control/hls")
-	if err != nil {
-		LogError(err)
+
+	// Multiple coordinator nodes can be set, split by commas
+	c.connectionURLs = make([]string, 0)
+
+	baseURLs := strings.Split(baseURL, ",")
+
+	for i := 0; i < len(baseURLs); i++ {
+		connectionURL, err := url.Parse(strings.TrimSpace(baseURLs[i]))
+		if err != nil {
+			LogError(err)
+			continue
+		}
+
+		c.connectionURLs = append(c.connectionURLs, connectionURL.ResolveReference(pathURL).String())
+	}
+
+	if len(c.connectionURLs) == 0 {
 		LogWarning("CONTROL_BASE_URL not provided. The encoding server will not work if not connected to a coordinator server.")
 		c.enabled = false
 		return
 	}
 
-	c.connectionURL = connectionURL.ResolveReference(pathURL).String()
+	c.connectionURLIndex = 0
 	c.enabled = true
 
+	c.cpuMeter = NewCPUUsageMeter()
+
 	go c.Connect()
 	go c.RunHeartBeatLoop()
+	go c.RunStatsLoop()
 }
 
 // Connect to the websocket server
@@ -72,7 +93,9 @@ func (c *ControlServerConnection) Connect() {
 		return // Already connected
 	}
 
-	LogInfo("[WS-CONTROL] Connecting to " + c.connectionURL)
+	connectionURL := c.connectionURLs[c.connectionURLIndex]
+
+	LogInfo("[WS-CONTROL] Connecting to " + connectionURL)
 
 	headers := http.Header{}
 
@@ -82,9 +105,11 @@ func (c *ControlServerConnection) Connect() {
 		headers.Set("x-control-auth-token", authToken)
 	}
 
-	conn, _, err := websocket.DefaultDialer.Dial(c.connectionURL, headers)
+	conn, _, err := websocket.DefaultDialer.Dial(connectionURL, headers)
 
 	if err != nil {
+		// Try the next coordinator node (it may be the leader)
+		c.connectionURLIndex = (c.connectionURLIndex + 1) % len(c.connectionURLs)
 		c.lock.Unlock()
 		LogErrorMessage("[WS-CONTROL] Connection error: " + err.Error())
 		go c.Reconnect()
@@ -96,10 +121,12 @@ func (c *ControlServerConnection) Connect() {
 	c.lock.Unlock()
 
 	// Right after connecting, send the REGISTER message
-	c.SendRegister(c.server.capacity)
+	// The coordinator will stop the tasks it no longer recognizes
+	c.SendRegister(c.server.capacity, c.server.tags, c.server.GetActiveTasks())
 
-	// After a connection is established, any previous encoding tasks must be stopped
-	c.server.KillAllActiveTasks()
+	if c.server.IsDraining() {
+		c.SendEncoderDrain(c.server.GetDrainRemainingTime())
+	}
 
 	go c.RunReaderLoop(conn)
 }
@@ -181,7 +208,8 @@ func (c *ControlServerConnection) ParseIncomingMessage(msg *messages.RPCMessage)
 	case "ERROR":
 		LogErrorMessage("[WS-CONTROL] Remote error. Code=" + msg.GetParam("Error-Code") + " / Details: " + msg.GetParam("Error-Message"))
 	case "ENCODE-START":
-		c.ReceiveEncodeStart(msg.GetParam("Stream-Channel"), msg.GetParam("Stream-ID"), msg.GetParam("Stream-Source-Type"), msg.GetParam("Stream-Source-URI"), DecodeResolutionsList(msg.GetParam("Resolutions")), strings.ToLower(msg.GetParam("Record")) == "true", DecodePreviewsConfiguration(msg.GetParam("Previews"), ","))
+		resumeTime, _ := strconv.ParseFloat(msg.GetParam("Resume-Time"), 64)
+		c.ReceiveEncodeStart(msg.GetParam("Stream-Channel"), msg.GetParam("Stream-ID"), msg.GetParam("Stream-Source-Type"), msg.GetParam("Stream-Source-URI"), DecodeResolutionsList(msg.GetParam("Resolutions")), strings.ToLower(msg.GetParam("Record")) == "true", DecodePreviewsConfiguration(msg.GetParam("Previews"), ","), ParseEncodingOptions(msg), resumeTime)
 	case "ENCODE-STOP":
 		c.ReceiveEncodeStop(msg.GetParam("Stream-Channel"), msg.GetParam("Stream-ID"))
 	}
@@ -201,13 +229,46 @@ func (c *ControlServerConnection) RunHeartBeatLoop() {
 	}
 }
 
+// Sends encoder stats messages periodically
+func (c *ControlServerConnection) RunStatsLoop() {
+	interval := GetConfiguredEncoderStatsInterval()
+
+	if interval <= 0 {
+		return // Disabled
+	}
+
+	c.cpuMeter.Measure() // First measure, to compute the usage in the next one
+
+	for {
+		time.Sleep(time.Duration(interval) * time.Second)
+
+		c.SendEncoderStats()
+	}
+}
+
 // Sends REGISTER message
 // capacity - Server capacity
-func (c *ControlServerConnection) SendRegister(capacity int) bool {
+// tags - Server tags
+// tasks - List of active encoding tasks
+func (c *ControlServerConnection) SendRegister(capacity int, tags []string, tasks []*EncodingTask) bool {
 	msgParams := make(map[string]string)
 
 	msgParams["Capacity"] = fmt.Sprint(capacity)
 
+	if len(tags) > 0 {
+		msgParams["Tags"] = strings.Join(tags, ",")
+	}
+
+	if len(tasks) > 0 {
+		encodedTasks := make([]string, len(tasks))
+
+		for i := 0; i < len(tasks); i++ {
+			encodedTasks[i] = tasks[i].channel + ":" + tasks[i].streamId + ":" + tasks[i].sourceType + ":" + url.QueryEscape(tasks[i].sourceURI)
+		}
+
+		msgParams["Tasks"] = strings.Join(encodedTasks, ",")
+	}
+
 	msg := messages.RPCMessage{
 		Method: "REGISTER",
 		Params: msgParams,
@@ -216,6 +277,23 @@ func (c *ControlServerConnection) SendRegister(capacity int) bool {
 	return c.Send(msg)
 }
 
+// Sends ENCODER-DRAIN message
+// deadline - Seconds until the coordinator should move the remaining tasks to other encoders. 0 = No deadline
+func (c *ControlServerConnection) SendEncoderDrain(deadline int) bool {
+	msgParams := make(map[string]string)
+
+	if deadline > 0 {
+		msgParams["Deadline"] = fmt.Sprint(deadline)
+	}
+
+	msg := messages.RPCMessage{
+		Method: "ENCODER-DRAIN",
+		Params: msgParams,
+	}
+
+	return c.Send(msg)
+}
+
 // Sends STREAM-AVAILABLE message
 // channel - Channel ID
 // streamId - Stream ID
@@ -243,15 +321,42 @@ func (c *ControlServerConnection) SendStreamAvailable(channel string, streamId s
 	return c.Send(msg)
 }
 
+// Sends STREAM-INFO message
+// channel - Channel ID
+// streamId - Stream ID
+// info - Information of the source stream
+func (c *ControlServerConnection) SendStreamInfo(channel string, streamId string, info *SourceStreamInfo) bool {
+	msgParams := make(map[string]string)
+
+	msgParams["Stream-Channel"] = channel
+	msgParams["Stream-ID"] = streamId
+
+	info.AddMessageParams(msgParams)
+
+	msg := messages.RPCMessage{
+		Method: "STREAM-INFO",
+		Params: msgParams,
+	}
+
+	return c.Send(msg)
+}
+
 // Sends STREAM-CLOSED message
 // channel - Channel ID
 // streamId - Stream ID
-func (c *ControlServerConnection) SendStreamClosed(channel string, streamId string) bool {
+// closeReason - Reason code (empty if unknown)
+// closeMessage - Message explaining the reason
+func (c *ControlServerConnection) SendStreamClosed(channel string, streamId string, closeReason string, closeMessage string) bool {
 	msgParams := make(map[string]string)
 
 	msgParams["Stream-Channel"] = channel
 	msgParams["Stream-ID"] = streamId
 
+	if closeReason != "" {
+		msgParams["Close-Reason"] = closeReason
+		msgParams["Close-Message"] = strings.NewReplacer("\r", "", "\n", " ").Replace(closeMessage)
+	}
+
 	msg := messages.RPCMessage{
 		Method: "STREAM-CLOSED",
 		Params: msgParams,
@@ -260,6 +365,40 @@ func (c *ControlServerConnection) SendStreamClosed(channel string, streamId stri
 	return c.Send(msg)
 }
 
+// Sends ENCODER-STATS message
+// Includes the resource usage of the system and the progress of each encoding task
+func (c *ControlServerConnection) SendEncoderStats() bool {
+	msgParams := make(map[string]string)
+
+	cpuUsage := c.cpuMeter.Measure()
+
+	if cpuUsage >= 0 {
+		msgParams["CPU-Usage"] = strconv.FormatFloat(cpuUsage, 'f', 2, 64)
+	}
+
+	memoryUsed, memoryTotal, ok := ReadMemoryUsage()
+
+	if ok {
+		msgParams["Memory-Used"] = fmt.Sprint(memoryUsed)
+		msgParams["Memory-Total"] = fmt.Sprint(memoryTotal)
+	}
+
+	msgParams["Load"] = fmt.Sprint(c.server.GetLoad())
+
+	tasksStats := c.server.GetTasksStats()
+
+	if len(tasksStats) > 0 {
+		msgParams["Tasks"] = tasksStats.Encode()
+	}
+
+	msg := messages.RPCMessage{
+		Method: "ENCODER-STATS",
+		Params: msgParams,
+	}
+
+	return c.Send(msg)
+}
+
 // Receives an ENCODE-START message
 // channel - Channel ID
 // streamId - Stream ID
@@ -268,8 +407,10 @@ func (c *ControlServerConnection) SendStreamClosed(channel string, streamId stri
 // resolutions - List of resolutions to resize the video stream
 // record - True if recording is enabled
 // previews - Configuration for making stream previews
-func (c *ControlServerConnection) ReceiveEncodeStart(channel string, streamId string, sourceType string, sourceURI string, resolutions ResolutionList, record bool, previews PreviewsConfiguration) {
-	c.server.CreateTask(channel, streamId, sourceType, sourceURI, resolutions, record, previews)
+// options - Per-stream encoding options
+// resumeTime - Seconds of the stream already encoded by another encoder (0 for new streams)
+func (c *ControlServerConnection) ReceiveEncodeStart(channel string, streamId string, sourceType string, sourceURI string, resolutions ResolutionList, record bool, previews PreviewsConfiguration, options EncodingOptions, resumeTime float64) {
+	c.server.CreateTask(channel, streamId, sourceType, sourceURI, resolutions, record, previews, options, resumeTime)
 }
 
 // Receives an ENCODE-STOP message
@@ -280,6 +421,7 @@ func (c *ControlServerConnection) ReceiveEncodeStop(channel string, streamId str
 
 	if task != nil {
 		LogTaskStatus(channel, streamId, "Killing task...")
+		task.SetCloseReason(CLOSE_REASON_STOPPED, "The encoding was stopped by the coordinator")
 		task.Kill()
 	}
 }
@@ -0,0 +1,129 @@
+// Drain mode (graceful shutdown)
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const DRAIN_EXIT_MARGIN = 30 * time.Second // Time to wait after the drain deadline for the coordinator to move the streams, before exiting
+
+// Returns the configured drain timeout (seconds)
+// Returns 0 if there is no timeout
+func GetConfiguredDrainTimeout() int {
+	configuredTimeout := os.Getenv("DRAIN_TIMEOUT_SECONDS")
+	if configuredTimeout != "" {
+		t, err := strconv.ParseInt(configuredTimeout, 10, 32)
+
+		if err != nil || t < 0 {
+			return 0
+		}
+
+		return int(t)
+	} else {
+		return 0
+	}
+}
+
+// Waits for termination signals (SIGTERM or SIGINT)
+// On the first signal, the encoder starts draining
+// On the second signal, the encoder exits immediately
+func (server *HLS_Encoder_Server) HandleTerminationSignals() {
+	signals := make(chan os.Signal, 1)
+
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	<-signals
+
+	server.StartDrain()
+
+	<-signals
+
+	LogWarning("Received a second termination signal. Exiting without waiting for the tasks to end.")
+
+	os.Exit(1)
+}
+
+// Starts draining the encoder
+// The coordinator is notified, so it does not assign new streams to the encoder
+// The encoder exits after all the tasks end, or after the drain deadline (DRAIN_TIMEOUT_SECONDS)
+func (server *HLS_Encoder_Server) StartDrain() {
+	timeout := GetConfiguredDrainTimeout()
+
+	server.mutex.Lock()
+
+	if server.draining {
+		server.mutex.Unlock()
+		return
+	}
+
+	server.draining = true
+
+	if timeout > 0 {
+		server.drainDeadline = time.Now().Add(time.Duration(timeout) * time.Second)
+	}
+
+	load := server.load
+
+	server.mutex.Unlock()
+
+	if load == 0 {
+		LogInfo("Received termination signal. No active tasks. Exiting.")
+		os.Exit(0)
+	}
+
+	LogInfo("Received termination signal. Draining " + fmt.Sprint(load) + " tasks before exiting.")
+
+	server.websocketControlConnection.SendEncoderDrain(server.GetDrainRemainingTime())
+
+	go server.waitForDrain()
+}
+
+// Gets the drain status
+// Returns true if the encoder is draining
+func (server *HLS_Encoder_Server) IsDraining() bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return server.draining
+}
+
+// Gets the remaining time until the drain deadline
+// Returns the remaining time (seconds, at least 1), or 0 if there is no deadline
+func (server *HLS_Encoder_Server) GetDrainRemainingTime() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.drainDeadline.IsZero() {
+		return 0
+	}
+
+	return max(1, int(time.Until(server.drainDeadline)/time.Second))
+}
+
+// Waits for the tasks to end, then exits
+func (server *HLS_Encoder_Server) waitForDrain() {
+	for {
+		time.Sleep(1 * time.Second)
+
+		server.mutex.Lock()
+		load := server.load
+		deadline := server.drainDeadline
+		server.mutex.Unlock()
+
+		if load == 0 {
+			LogInfo("All the tasks ended. Exiting.")
+			os.Exit(0)
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline.Add(DRAIN_EXIT_MARGIN)) {
+			LogWarning("Drain deadline reached with " + fmt.Sprint(load) + " tasks left. Exiting.")
+			os.Exit(0)
+		}
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Stores the status data of the HLS encoder
@@ -22,6 +24,11 @@ type HLS_Encoder_Server struct {
 	capacity int // Server capacity
 	load     int // Server load
 
+	tags []string // Server tags, so the coordinator can restrict which streams it handles
+
+	draining      bool      // True if the server is draining (waiting for the tasks to end before exiting)
+	drainDeadline time.Time // Time when the coordinator moves the remaining tasks to other encoders. Zero = No deadline
+
 	mutex *sync.Mutex // Mutex to access the status data
 
 	loopBackPort int // Port of the loopback HTTP listener (randomly chosen)
@@ -54,6 +61,8 @@ func (server *HLS_Encoder_Server) Initialize() {
 		}
 	}
 
+	server.tags = GetConfiguredEncoderTags()
+
 	server.hlsTargetDuration = GetConfiguredHLSTime()
 	server.hlsLivePlayListSize = GetConfiguredHLSPlaylistSize()
 	server.hlsVODPlaylistMaxSize = GetConfiguredHLSVideoOnDemandMaxSize()
@@ -90,6 +99,9 @@ func (server *HLS_Encoder_Server) Start() {
 	// Start connection with the control server
 	server.websocketControlConnection.Initialize(server)
 
+	// Drain on termination signals
+	go server.HandleTerminationSignals()
+
 	err = loopBackServer.Serve(ln)
 
 	if err != nil {
@@ -97,24 +109,6 @@ func (server *HLS_Encoder_Server) Start() {
 	}
 }
 
-// Kills every single active task
-// Run only if the connection to the coordinator server is lost
-func (server *HLS_Encoder_Server) KillAllActiveTasks() {
-	tasksToKill := make([]*EncodingTask, 0)
-
-	server.mutex.Lock()
-
-	for _, task := range server.tasks {
-		tasksToKill = append(tasksToKill, task)
-	}
-
-	server.mutex.Unlock()
-
-	for i := 0; i < len(tasksToKill); i++ {
-		tasksToKill[i].Kill()
-	}
-}
-
 // Creates a new encoding task
 // channel - Channel ID
 // streamId - Stream ID
@@ -123,7 +117,9 @@ func (server *HLS_Encoder_Server) KillAllActiveTasks() {
 // resolutions - List of resolutions to resize the video stream
 // record - True if recording is enabled
 // previews - Configuration for making stream previews
-func (server *HLS_Encoder_Server) CreateTask(channel string, streamId string, sourceType string, sourceURI string, resolutions ResolutionList, record bool, previews PreviewsConfiguration) {
+// options - Per-stream encoding options
+// resumeTime - Seconds of the stream already encoded by another encoder (0 for new streams)
+func (server *HLS_Encoder_Server) CreateTask(channel string, streamId string, sourceType string, sourceURI string, resolutions ResolutionList, record bool, previews PreviewsConfiguration, options EncodingOptions, resumeTime float64) {
 	server.mutex.Lock()
 	defer server.mutex.Unlock()
 
@@ -133,6 +129,12 @@ func (server *HLS_Encoder_Server) CreateTask(channel string, streamId string, so
 		return // Already created
 	}
 
+	targetDuration := server.hlsTargetDuration
+
+	if options.segmentDuration > 0 {
+		targetDuration = options.segmentDuration
+	}
+
 	newTask := &EncodingTask{
 		server:                      server,
 		channel:                     channel,
@@ -142,12 +144,17 @@ func (server *HLS_Encoder_Server) CreateTask(channel string, streamId string, so
 		resolutions:                 resolutions,
 		record:                      record,
 		previews:                    previews,
+		options:                     options,
+		targetDuration:              targetDuration,
+		resumeTime:                  resumeTime,
+		fragmentOffset:              GetResumeFragmentOffset(resumeTime, targetDuration),
+		previewsOffset:              GetResumePreviewsOffset(resumeTime, previews),
 		killed:                      false,
 		hasStarted:                  false,
 		mutex:                       &sync.Mutex{},
 		process:                     nil,
 		subStreams:                  make(map[string]*SubStreamStatus),
-		previewsCount:               0,
+		previewsCount:               GetResumePreviewsOffset(resumeTime, previews),
 		previewsAvailable:           false,
 		previewsReady:               make(map[int]bool),
 		writingPreviewsIndex:        false,
@@ -160,7 +167,7 @@ func (server *HLS_Encoder_Server) CreateTask(channel string, streamId string, so
 
 	LogTaskStatus(channel, streamId, "Task created | Server load: "+fmt.Sprint(server.load))
 	if LOG_DEBUG_ENABLED {
-		LogDebugTask(channel, streamId, "Task details: sourceType="+sourceType+" | sourceURI="+sourceURI+" | resolutions="+resolutions.Encode()+" | record="+fmt.Sprint(record)+" | previews="+previews.Encode("-"))
+		LogDebugTask(channel, streamId, "Task details: sourceType="+sourceType+" | sourceURI="+sourceURI+" | resolutions="+resolutions.Encode()+" | record="+fmt.Sprint(record)+" | previews="+previews.Encode("-")+" | resumeTime="+fmt.Sprint(resumeTime)+" | "+options.String())
 	}
 
 	go newTask.Run()
@@ -193,3 +200,100 @@ func (server *HLS_Encoder_Server) GetTask(channel string, streamId string) *Enco
 
 	return server.tasks[channel+":"+streamId]
 }
+
+// Gets the server load
+// Returns the number of active encoding tasks
+func (server *HLS_Encoder_Server) GetLoad() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return server.load
+}
+
+// Gets the stats of the active encoding tasks
+// Only includes the tasks that already started encoding
+// Returns the list of stats
+func (server *HLS_Encoder_Server) GetTasksStats() EncodingTaskStatsList {
+	tasks := make([]*EncodingTask, 0)
+
+	server.mutex.Lock()
+
+	for _, task := range server.tasks {
+		tasks = append(tasks, task)
+	}
+
+	server.mutex.Unlock()
+
+	result := make(EncodingTaskStatsList, 0)
+
+	for i := 0; i < len(tasks); i++ {
+		started, progress := tasks[i].GetProgress()
+
+		if !started {
+			continue
+		}
+
+		renditions, fragments, lastFragmentTime := tasks[i].GetOutputStats()
+
+		result = append(result, EncodingTaskStats{
+			channel:          tasks[i].channel,
+			streamId:         tasks[i].streamId,
+			speed:            progress.speed,
+			fps:              progress.fps,
+			droppedFrames:    progress.droppedFrames,
+			renditions:       renditions,
+			fragments:        fragments,
+			lastFragmentTime: lastFragmentTime,
+		})
+	}
+
+	return result
+}
+
+// Gets the list of active encoding tasks (not killed)
+// Returns the list of tasks
+func (server *HLS_Encoder_Server) GetActiveTasks() []*EncodingTask {
+	tasks := make([]*EncodingTask, 0)
+
+	server.mutex.Lock()
+
+	for _, task := range server.tasks {
+		tasks = append(tasks, task)
+	}
+
+	server.mutex.Unlock()
+
+	result := make([]*EncodingTask, 0)
+
+	for i := 0; i < len(tasks); i++ {
+		if !tasks[i].IsKilled() {
+			result = append(result, tasks[i])
+		}
+	}
+
+	return result
+}
+
+// Loads the server tags from the ENCODER_TAGS environment variable
+// Returns the list of tags (lower case)
+func GetConfiguredEncoderTags() []string {
+	result := make([]string, 0)
+
+	tags := os.Getenv("ENCODER_TAGS")
+
+	if tags == "" {
+		return result
+	}
+
+	parts := strings.Split(tags, ",")
+
+	for i := 0; i < len(parts); i++ {
+		tag := strings.ToLower(strings.Trim(parts[i], " "))
+
+		if tag != "" {
+			result = append(result, tag)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,157 @@
+// Per-stream encoding options
+
+package main
+
+import (
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	messages "github.com/AgustinSRG/go-simple-rpc-message"
+)
+
+// Watermark positions
+const (
+	WATERMARK_POSITION_TOP_LEFT     = "top-left"
+	WATERMARK_POSITION_TOP_RIGHT    = "top-right"
+	WATERMARK_POSITION_BOTTOM_LEFT  = "bottom-left"
+	WATERMARK_POSITION_BOTTOM_RIGHT = "bottom-right"
+)
+
+const WATERMARK_MARGIN = 16 // Distance from the watermark to the video borders (pixels)
+
+// Encoding options of a stream, sent by the coordinator in the ENCODE-START message
+type EncodingOptions struct {
+	segmentDuration int // Duration of the fragments (seconds). 0 = Server default (HLS_TIME_SECONDS)
+
+	codecProfile string // H.264 profile. Empty = Codec default
+
+	watermarkText     string // Text to draw over the video. Empty = No watermark
+	watermarkPosition string // Position of the watermark
+
+	metadata map[string]string // Metadata to add to the encoded stream
+}
+
+// Parses the encoding options from the ENCODE-START message
+// msg - The message
+// Returns the options
+func ParseEncodingOptions(msg *messages.RPCMessage) EncodingOptions {
+	options := EncodingOptions{
+		metadata: make(map[string]string),
+	}
+
+	segmentDuration, err := strconv.Atoi(msg.GetParam("Segment-Duration"))
+
+	if err == nil && segmentDuration > 0 {
+		options.segmentDuration = segmentDuration
+	}
+
+	options.codecProfile = strings.ToLower(msg.GetParam("Codec-Profile"))
+
+	watermarkText, err := url.QueryUnescape(msg.GetParam("Watermark"))
+
+	if err == nil {
+		options.watermarkText = watermarkText
+	}
+
+	options.watermarkPosition = strings.ToLower(msg.GetParam("Watermark-Position"))
+
+	metadata, err := url.ParseQuery(msg.GetParam("Metadata"))
+
+	if err == nil {
+		for key := range metadata {
+			options.metadata[key] = metadata.Get(key)
+		}
+	}
+
+	return options
+}
+
+// Encodes the options for logging
+// Returns the encoded options
+func (options *EncodingOptions) String() string {
+	return "segmentDuration=" + strconv.Itoa(options.segmentDuration) +
+		" | codecProfile=" + options.codecProfile +
+		" | watermark=" + options.watermarkText +
+		" | metadataKeys=" + strconv.Itoa(len(options.metadata))
+}
+
+// Appends the codec profile argument to the encoder command
+// cmd - The command
+// videoCodec - The video codec
+func (options *EncodingOptions) AppendProfileArguments(cmd *exec.Cmd, videoCodec string) {
+	if options.codecProfile == "" {
+		return
+	}
+
+	if videoCodec == CODEC_H264 || videoCodec == CODEC_H264_NVENC {
+		cmd.Args = append(cmd.Args, "-profile:v", options.codecProfile)
+	}
+}
+
+// Appends the metadata arguments to the encoder command
+// cmd - The command
+func (options *EncodingOptions) AppendMetadataArguments(cmd *exec.Cmd) {
+	keys := make([]string, 0, len(options.metadata))
+
+	for key := range options.metadata {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for i := 0; i < len(keys); i++ {
+		cmd.Args = append(cmd.Args, "-metadata", keys[i]+"="+options.metadata[keys[i]])
+	}
+}
+
+// Gets the video filter to draw the watermark
+// Returns the filter, or an empty string if there is no watermark
+func (options *EncodingOptions) GetWatermarkFilter() string {
+	if options.watermarkText == "" {
+		return ""
+	}
+
+	margin := strconv.Itoa(WATERMARK_MARGIN)
+
+	x := "w-tw-" + margin
+	y := "h-th-" + margin
+
+	switch options.watermarkPosition {
+	case WATERMARK_POSITION_TOP_LEFT:
+		x = margin
+		y = margin
+	case WATERMARK_POSITION_TOP_RIGHT:
+		y = margin
+	case WATERMARK_POSITION_BOTTOM_LEFT:
+		x = margin
+	}
+
+	filter := "drawtext=expansion=none:text=" + escapeFilterText(options.watermarkText) +
+		":fontsize=h/24:fontcolor=white@0.6:shadowcolor=black@0.4:shadowx=1:shadowy=1" +
+		":x=" + x + ":y=" + y
+
+	fontFile := os.Getenv("WATERMARK_FONT_FILE")
+
+	if fontFile != "" {
+		filter += ":fontfile=" + escapeFilterText(fontFile)
+	}
+
+	return filter
+}
+
+// Escapes a text to be used as an option value of a filter, inside a filter graph
+// text - The text
+// Returns the escaped text
+func escapeFilterText(text string) string {
+	text = strings.NewReplacer("\r", "", "\n", " ").Replace(text)
+
+	// Escape the option value
+	text = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(text)
+
+	// Escape for the filter graph
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(text)
+}
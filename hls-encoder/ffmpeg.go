@@ -71,6 +71,8 @@ func PrepareEncodingFFMPEGCommand(task *EncodingTask, probeData *ffprobe.ProbeDa
 	videoHeight := videoStream.Height
 	videoFPS := ParseFrameRate(videoStream.AvgFrameRate)
 
+	watermarkFilter := task.options.GetWatermarkFilter()
+
 	// Add original output
 	if task.resolutions.hasOriginal {
 		// Encode
@@ -81,10 +83,22 @@ func PrepareEncodingFFMPEGCommand(task *EncodingTask, probeData *ffprobe.ProbeDa
 			cmd.Args = append(cmd.Args, "-preset", task.server.hlsH264Preset)
 		}
 
+		task.options.AppendProfileArguments(cmd, task.server.hlsVideoCodec)
+
 		cmd.Args = append(cmd.Args, "-pix_fmt", task.server.hlsPixelFormat)
 
+		videoFilters := make([]string, 0)
+
 		if videoWidth%2 != 0 || videoHeight%2 != 0 {
-			cmd.Args = append(cmd.Args, "-vf", "pad=ceil(iw/2)*2:ceil(ih/2)*2") // Ensure even width and height
+			videoFilters = append(videoFilters, "pad=ceil(iw/2)*2:ceil(ih/2)*2") // Ensure even width and height
+		}
+
+		if watermarkFilter != "" {
+			videoFilters = append(videoFilters, watermarkFilter)
+		}
+
+		if len(videoFilters) > 0 {
+			cmd.Args = append(cmd.Args, "-vf", strings.Join(videoFilters, ","))
 		}
 
 		AppendGenericHLSArguments(cmd, Resolution{width: videoWidth, height: videoHeight, fps: videoFPS}, task)
@@ -99,6 +113,8 @@ func PrepareEncodingFFMPEGCommand(task *EncodingTask, probeData *ffprobe.ProbeDa
 			cmd.Args = append(cmd.Args, "-preset", task.server.hlsH264Preset)
 		}
 
+		task.options.AppendProfileArguments(cmd, task.server.hlsVideoCodec)
+
 		cmd.Args = append(cmd.Args, "-pix_fmt", task.server.hlsPixelFormat)
 
 		videoFilter := ""
@@ -116,7 +132,11 @@ func PrepareEncodingFFMPEGCommand(task *EncodingTask, probeData *ffprobe.ProbeDa
 
 		videoFilter += "scale=" + fmt.Sprint(resolutions[i].width) + ":" + fmt.Sprint(resolutions[i].height)
 
-		cmd.Args = append(cmd.Args, "-vf", videoFilter) // Scale and FPS
+		if watermarkFilter != "" {
+			videoFilter += "," + watermarkFilter
+		}
+
+		cmd.Args = append(cmd.Args, "-vf", videoFilter) // Scale, FPS and watermark
 
 		AppendGenericHLSArguments(cmd, resolutions[i], task)
 	}
@@ -135,7 +155,7 @@ func PrepareEncodingFFMPEGCommand(task *EncodingTask, probeData *ffprobe.ProbeDa
 
 		cmd.Args = append(cmd.Args, "-protocol_opts", "method=PUT")
 
-		cmd.Args = append(cmd.Args, "-start_number", "0")
+		cmd.Args = append(cmd.Args, "-start_number", fmt.Sprint(task.previewsOffset))
 
 		cmd.Args = append(cmd.Args, "http://127.0.0.1:"+fmt.Sprint(task.server.loopBackPort)+"/img-preview/"+task.channel+"/"+task.streamId+"/"+task.previews.Encode("-")+"/%d.jpg")
 	}
@@ -177,10 +197,39 @@ func ParseFrameRate(fr string) int {
 	}
 }
 
+// Progress reported by FFMPEG
+type FFMPEGProgress struct {
+	time          float64 // Output time (seconds)
+	frames        uint64  // Number of encoded frames
+	fps           float64 // Encoding frames per second
+	speed         float64 // Encoding speed, relative to real time (1 = real time)
+	droppedFrames uint64  // Number of dropped frames
+}
+
+// Extracts a value from a FFMPEG progress line
+// line - The progress line (eg: frame=  120 fps= 30 ... speed=1.01x)
+// key - The key to extract
+// Returns the value, or an empty string if not found
+func getFFMPEGProgressValue(line string, key string) string {
+	parts := strings.Split(line, key+"=")
+
+	if len(parts) < 2 {
+		return ""
+	}
+
+	parts = strings.Split(strings.Trim(parts[1], " "), " ")
+
+	if len(parts) < 1 {
+		return ""
+	}
+
+	return parts[0]
+}
+
 // Reads progress and calls a reporter
 // pipe - Stderr pipe
 // progress_reporter - Function called each time ffmpeg reports progress via standard error
-func ReadFFMPEGProgress(pipe io.ReadCloser, progress_reporter func(time float64, frames uint64)) {
+func ReadFFMPEGProgress(pipe io.ReadCloser, progress_reporter func(progress FFMPEGProgress)) {
 	reader := bufio.NewReader(pipe)
 
 	var finished bool = false
@@ -202,35 +251,23 @@ func ReadFFMPEGProgress(pipe io.ReadCloser, progress_reporter func(time float64,
 
 		// Extract frame
 
-		parts := strings.Split(line, "frame=")
+		frames, _ := strconv.ParseUint(getFFMPEGProgressValue(line, "frame"), 10, 64)
 
-		if len(parts) < 2 {
-			continue
-		}
+		// Extract fps, speed and dropped frames
 
-		parts = strings.Split(strings.Trim(parts[1], " "), " ")
-
-		if len(parts) < 1 {
-			continue
-		}
-
-		frames, _ := strconv.ParseUint(parts[0], 10, 64)
+		fps, _ := strconv.ParseFloat(getFFMPEGProgressValue(line, "fps"), 64)
+		speed, _ := strconv.ParseFloat(strings.TrimSuffix(getFFMPEGProgressValue(line, "speed"), "x"), 64)
+		droppedFrames, _ := strconv.ParseUint(getFFMPEGProgressValue(line, "drop"), 10, 64)
 
 		// Extract time
 
-		parts = strings.Split(line, "time=")
-
-		if len(parts) < 2 {
-			continue
-		}
-
-		parts = strings.Split(strings.Trim(parts[1], " "), " ")
+		timeStr := getFFMPEGProgressValue(line, "time")
 
-		if len(parts) < 1 {
+		if timeStr == "" {
 			continue
 		}
 
-		parts = strings.Split(parts[0], ":")
+		parts := strings.Split(timeStr, ":")
 
 		if len(parts) != 3 {
 			continue
@@ -243,7 +280,13 @@ func ReadFFMPEGProgress(pipe io.ReadCloser, progress_reporter func(time float64,
 		out_duration := float64(hours)*3600 + float64(minutes)*60 + seconds
 
 		if out_duration > 0 {
-			progress_reporter(out_duration, uint64(frames))
+			progress_reporter(FFMPEGProgress{
+				time:          out_duration,
+				frames:        frames,
+				fps:           fps,
+				speed:         speed,
+				droppedFrames: droppedFrames,
+			})
 		}
 	}
 }
@@ -252,7 +295,7 @@ func ReadFFMPEGProgress(pipe io.ReadCloser, progress_reporter func(time float64,
 // cmd - Command to run
 // input_duration - Duration in seconds (used to calculate progress)
 // progress_reporter - Function called each time ffmpeg reports progress via standard error
-func RunFFMpegCommandAsync(cmd *exec.Cmd, progress_reporter func(time float64, frames uint64)) (process *os.Process, cmdErr error) {
+func RunFFMpegCommandAsync(cmd *exec.Cmd, progress_reporter func(progress FFMPEGProgress)) (process *os.Process, cmdErr error) {
 	// Configure command
 	err := child_process_manager.ConfigureCommand(cmd)
 	if err != nil {
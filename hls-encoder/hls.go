@@ -4,6 +4,7 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"os/exec"
 	"strconv"
@@ -23,6 +24,7 @@ const (
 	HLS_H264_DEFAULT_PRESET       = "veryfast"
 	HLS_H264_NVENC_DEFAULT_PRESET = "fast"
 	HLS_DEFAULT_PIXEL_FORMAT      = "yuv420p"
+	HLS_RESUME_FRAGMENT_MARGIN    = 2 // Extra fragments to skip when resuming a stream, to prevent overwriting the ones of the previous encoder
 )
 
 // Returns the configured HLS video codec
@@ -132,6 +134,32 @@ func GetConfiguredMaxHLSFragmentCount() int {
 	}
 }
 
+// Computes the index of the first fragment when resuming a stream
+// The fragments are cut each target duration, so the previous encoder
+// could not have generated more fragments than the elapsed time allows
+// resumeTime - Seconds of the stream already encoded
+// targetDuration - Duration of fragments (seconds)
+// Returns the index of the first fragment
+func GetResumeFragmentOffset(resumeTime float64, targetDuration int) int {
+	if resumeTime <= 0 || targetDuration <= 0 {
+		return 0
+	}
+
+	return int(math.Ceil(resumeTime/float64(targetDuration))) + HLS_RESUME_FRAGMENT_MARGIN
+}
+
+// Computes the index of the first preview image when resuming a stream
+// resumeTime - Seconds of the stream already encoded
+// previews - Previews configuration
+// Returns the index of the first image
+func GetResumePreviewsOffset(resumeTime float64, previews PreviewsConfiguration) int {
+	if resumeTime <= 0 || !previews.enabled || previews.delaySeconds <= 0 {
+		return 0
+	}
+
+	return int(math.Ceil(resumeTime/float64(previews.delaySeconds))) + HLS_RESUME_FRAGMENT_MARGIN
+}
+
 // Appends HLS arguments to the encoder command
 // cmd - The command
 // resolution - The resolution
@@ -144,11 +172,15 @@ func AppendGenericHLSArguments(cmd *exec.Cmd, resolution Resolution, task *Encod
 	if task.server.hlsVideoCodec == CODEC_H264_NVENC {
 		cmd.Args = append(cmd.Args, "-forced-idr", "1")
 	}
-	cmd.Args = append(cmd.Args, "-force_key_frames", "expr:gte(t,n_forced*"+fmt.Sprint(task.server.hlsTargetDuration)+")")
+	cmd.Args = append(cmd.Args, "-force_key_frames", "expr:gte(t,n_forced*"+fmt.Sprint(task.targetDuration)+")")
 
 	// Set HLS options
 	cmd.Args = append(cmd.Args, "-hls_list_size", fmt.Sprint(HLS_INTERNAL_PLAYLIST_SIZE))
-	cmd.Args = append(cmd.Args, "-hls_time", fmt.Sprint(task.server.hlsTargetDuration))
+	cmd.Args = append(cmd.Args, "-hls_time", fmt.Sprint(task.targetDuration))
+	cmd.Args = append(cmd.Args, "-start_number", fmt.Sprint(task.fragmentOffset))
+
+	// Custom metadata
+	task.options.AppendMetadataArguments(cmd)
 
 	// Method and URL
 	cmd.Args = append(cmd.Args, "-method", "PUT")
@@ -168,14 +200,17 @@ type HLS_PlayList struct {
 	IsVOD          bool // True if the playlist is a VOD playlist
 	IsEnded        bool // True if the playlist is an ended playlist
 
+	DiscontinuitySequence int // Number of discontinuities removed from the playlist
+
 	fragments []HLS_Fragment // Video TS fragments
 }
 
 // Stores HLS fragment metadata
 type HLS_Fragment struct {
-	Index        int     // Fragment index
-	Duration     float64 // Fragment duration
-	FragmentName string  // Fragment file name
+	Index         int     // Fragment index
+	Duration      float64 // Fragment duration
+	FragmentName  string  // Fragment file name
+	Discontinuity bool    // True if the fragment starts a discontinuity (eg: the stream was resumed by another encoder)
 }
 
 // Encodes playlist to M3U8
@@ -190,7 +225,15 @@ func (playlist *HLS_PlayList) Encode() string {
 	result += "#EXT-X-TARGETDURATION:" + fmt.Sprint(playlist.TargetDuration) + "\n"
 	result += "#EXT-X-MEDIA-SEQUENCE:" + fmt.Sprint(playlist.MediaSequence) + "\n"
 
+	if playlist.DiscontinuitySequence > 0 {
+		result += "#EXT-X-DISCONTINUITY-SEQUENCE:" + fmt.Sprint(playlist.DiscontinuitySequence) + "\n"
+	}
+
 	for i := 0; i < len(playlist.fragments); i++ {
+		if playlist.fragments[i].Discontinuity {
+			result += "#EXT-X-DISCONTINUITY" + "\n"
+		}
+
 		result += "#EXTINF:" + fmt.Sprintf("%0.6f", playlist.fragments[i].Duration) + "," + "\n"
 		result += playlist.fragments[i].FragmentName + "\n"
 	}
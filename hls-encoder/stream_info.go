@@ -0,0 +1,147 @@
+// Source stream information
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/vansante/go-ffprobe.v2"
+)
+
+// Information of the source stream, obtained when probing it
+type SourceStreamInfo struct {
+	format  string // Container format
+	bitrate int64  // Total bitrate (bits/s). 0 if unknown
+
+	videoCodec   string  // Video codec
+	videoProfile string  // Video codec profile
+	width        int     // Video width (pixels)
+	height       int     // Video height (pixels)
+	frameRate    float64 // Video frame rate (frames/s)
+	videoBitrate int64   // Video bitrate (bits/s). 0 if unknown
+
+	audioCodec      string // Audio codec. Empty if there is no audio
+	audioSampleRate int    // Audio sample rate (Hz)
+	audioChannels   int    // Number of audio channels
+	audioBitrate    int64  // Audio bitrate (bits/s). 0 if unknown
+}
+
+// Extracts the source stream information from the probe data
+// probeData - Probe data
+// Returns the information
+func GetSourceStreamInfo(probeData *ffprobe.ProbeData) *SourceStreamInfo {
+	info := &SourceStreamInfo{}
+
+	if probeData.Format != nil {
+		info.format = probeData.Format.FormatName
+		info.bitrate = parseProbeInt(probeData.Format.BitRate)
+	}
+
+	videoStream := probeData.FirstVideoStream()
+
+	if videoStream != nil {
+		info.videoCodec = videoStream.CodecName
+		info.videoProfile = videoStream.Profile
+		info.width = videoStream.Width
+		info.height = videoStream.Height
+		info.frameRate = parseProbeFrameRate(videoStream.AvgFrameRate)
+		info.videoBitrate = parseProbeInt(videoStream.BitRate)
+	}
+
+	audioStream := probeData.FirstAudioStream()
+
+	if audioStream != nil {
+		info.audioCodec = audioStream.CodecName
+		info.audioSampleRate = int(parseProbeInt(audioStream.SampleRate))
+		info.audioChannels = audioStream.Channels
+		info.audioBitrate = parseProbeInt(audioStream.BitRate)
+	}
+
+	return info
+}
+
+// Adds the information to the parameters of the STREAM-INFO message
+// Unknown values are not included
+// params - The message parameters
+func (info *SourceStreamInfo) AddMessageParams(params map[string]string) {
+	setStringParam := func(name string, value string) {
+		value = strings.NewReplacer("\r", "", "\n", " ").Replace(value)
+
+		if value != "" {
+			params[name] = value
+		}
+	}
+
+	setIntParam := func(name string, value int64) {
+		if value > 0 {
+			params[name] = fmt.Sprint(value)
+		}
+	}
+
+	setStringParam("Format", info.format)
+	setIntParam("Bitrate", info.bitrate)
+
+	setStringParam("Video-Codec", info.videoCodec)
+	setStringParam("Video-Profile", info.videoProfile)
+	setIntParam("Width", int64(info.width))
+	setIntParam("Height", int64(info.height))
+
+	if info.frameRate > 0 {
+		params["Frame-Rate"] = strconv.FormatFloat(info.frameRate, 'f', 2, 64)
+	}
+
+	setIntParam("Video-Bitrate", info.videoBitrate)
+
+	setStringParam("Audio-Codec", info.audioCodec)
+	setIntParam("Audio-Sample-Rate", int64(info.audioSampleRate))
+	setIntParam("Audio-Channels", int64(info.audioChannels))
+	setIntParam("Audio-Bitrate", info.audioBitrate)
+}
+
+// Encodes the information for logging
+// Returns the encoded information
+func (info *SourceStreamInfo) String() string {
+	return "format=" + info.format +
+		" | video=" + info.videoCodec + " " + fmt.Sprint(info.width) + "x" + fmt.Sprint(info.height) + " " + strconv.FormatFloat(info.frameRate, 'f', 2, 64) + "fps" +
+		" | audio=" + info.audioCodec + " " + fmt.Sprint(info.audioSampleRate) + "Hz"
+}
+
+// Parses an integer value from the probe data
+// str - The value
+// Returns the parsed value, or 0 if unknown
+func parseProbeInt(str string) int64 {
+	n, err := strconv.ParseInt(str, 10, 64)
+
+	if err != nil || n < 0 {
+		return 0
+	}
+
+	return n
+}
+
+// Parses a frame rate from the probe data, keeping the decimals
+// fr - The frame rate, as a fraction (example: 30000/1001)
+// Returns the frame rate, or 0 if unknown
+func parseProbeFrameRate(fr string) float64 {
+	parts := strings.Split(fr, "/")
+
+	n, err := strconv.ParseFloat(parts[0], 64)
+
+	if err != nil {
+		return 0
+	}
+
+	if len(parts) == 1 {
+		return n
+	}
+
+	d, err := strconv.ParseFloat(parts[1], 64)
+
+	if err != nil || d == 0 {
+		return 0
+	}
+
+	return n / d
+}
@@ -0,0 +1,200 @@
+// System resource usage stats
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const ENCODER_STATS_DEFAULT_INTERVAL = 10 // Default interval to send ENCODER-STATS (seconds)
+
+// Returns the configured interval to send the encoder stats (seconds)
+// Returns 0 if disabled
+func GetConfiguredEncoderStatsInterval() int {
+	configuredInterval := os.Getenv("ENCODER_STATS_INTERVAL_SECONDS")
+	if configuredInterval != "" {
+		t, err := strconv.ParseInt(configuredInterval, 10, 32)
+
+		if err != nil || t < 0 {
+			return ENCODER_STATS_DEFAULT_INTERVAL
+		}
+
+		return int(t)
+	} else {
+		return ENCODER_STATS_DEFAULT_INTERVAL
+	}
+}
+
+// Measures the CPU usage of the system
+// by comparing the counters of /proc/stat between calls
+type CPUUsageMeter struct {
+	mutex *sync.Mutex // Mutex to access the data
+
+	lastTotal uint64 // Total CPU time of the last measure
+	lastIdle  uint64 // Idle CPU time of the last measure
+}
+
+// Creates a CPU usage meter
+// Returns a reference to the meter
+func NewCPUUsageMeter() *CPUUsageMeter {
+	return &CPUUsageMeter{
+		mutex: &sync.Mutex{},
+	}
+}
+
+// Reads the CPU times from /proc/stat
+// Returns:
+//
+//	total - Total CPU time
+//	idle - Idle CPU time
+//	ok - True if the times could be read
+func readCPUTimes() (total uint64, idle uint64, ok bool) {
+	data, err := os.ReadFile("/proc/stat")
+
+	if err != nil {
+		return 0, 0, false
+	}
+
+	lines := strings.Split(string(data), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+
+		for j := 1; j < len(fields); j++ {
+			v, err := strconv.ParseUint(fields[j], 10, 64)
+
+			if err != nil {
+				continue
+			}
+
+			total += v
+
+			if j == 4 || j == 5 {
+				// idle + iowait
+				idle += v
+			}
+		}
+
+		return total, idle, true
+	}
+
+	return 0, 0, false
+}
+
+// Measures the CPU usage since the last call
+// Returns the CPU usage (0 to 100), or -1 if unknown
+func (meter *CPUUsageMeter) Measure() float64 {
+	meter.mutex.Lock()
+	defer meter.mutex.Unlock()
+
+	total, idle, ok := readCPUTimes()
+
+	if !ok {
+		return -1
+	}
+
+	lastTotal := meter.lastTotal
+	lastIdle := meter.lastIdle
+
+	meter.lastTotal = total
+	meter.lastIdle = idle
+
+	if lastTotal == 0 || total <= lastTotal || idle < lastIdle {
+		return -1
+	}
+
+	deltaTotal := total - lastTotal
+	deltaIdle := idle - lastIdle
+
+	if deltaIdle > deltaTotal {
+		return 0
+	}
+
+	return float64(deltaTotal-deltaIdle) * 100 / float64(deltaTotal)
+}
+
+// Reads the memory usage of the system from /proc/meminfo
+// Returns:
+//
+//	used - Used memory (bytes)
+//	total - Total memory (bytes)
+//	ok - True if the values could be read
+func ReadMemoryUsage() (used uint64, total uint64, ok bool) {
+	data, err := os.ReadFile("/proc/meminfo")
+
+	if err != nil {
+		return 0, 0, false
+	}
+
+	lines := strings.Split(string(data), "\n")
+
+	var available uint64 = 0
+	foundTotal := false
+	foundAvailable := false
+
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+
+		if len(fields) < 2 {
+			continue
+		}
+
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+
+		if err != nil {
+			continue
+		}
+
+		switch fields[0] {
+		case "MemTotal:":
+			total = v * 1024
+			foundTotal = true
+		case "MemAvailable:":
+			available = v * 1024
+			foundAvailable = true
+		}
+	}
+
+	if !foundTotal || !foundAvailable || available > total {
+		return 0, 0, false
+	}
+
+	return total - available, total, true
+}
+
+// Stats of an encoding task
+type EncodingTaskStats struct {
+	channel       string  // Channel ID
+	streamId      string  // Stream ID
+	speed         float64 // Encoding speed, relative to real time (1 = real time)
+	fps           float64 // Encoding frames per second
+	droppedFrames uint64  // Number of dropped frames
+
+	renditions       int   // Number of renditions being encoded
+	fragments        int   // Number of fragments of the stream
+	lastFragmentTime int64 // Unix timestamp (milliseconds) of the last fragment. 0 = None yet
+}
+
+// List of encoding task stats
+type EncodingTaskStatsList []EncodingTaskStats
+
+// Encodes the list of task stats to send it to the coordinator
+// Format: {CHANNEL}:{STREAM_ID}:{SPEED}:{FPS}:{DROPPED_FRAMES}:{RENDITIONS}:{FRAGMENTS}:{LAST_FRAGMENT_TIME}, separated by commas
+// Returns the encoded list
+func (list EncodingTaskStatsList) Encode() string {
+	parts := make([]string, len(list))
+
+	for i := 0; i < len(list); i++ {
+		parts[i] = list[i].channel + ":" + list[i].streamId + ":" + strconv.FormatFloat(list[i].speed, 'f', 3, 64) + ":" + strconv.FormatFloat(list[i].fps, 'f', 2, 64) + ":" + strconv.FormatUint(list[i].droppedFrames, 10) +
+			":" + strconv.Itoa(list[i].renditions) + ":" + strconv.Itoa(list[i].fragments) + ":" + strconv.FormatInt(list[i].lastFragmentTime, 10)
+	}
+
+	return strings.Join(parts, ",")
+}
@@ -7,6 +7,15 @@ import (
 	"sync"
 )
 
+// Reasons why a task ends
+const (
+	CLOSE_REASON_STOPPED        = "stopped"        // The coordinator stopped the task (ENCODE-STOP)
+	CLOSE_REASON_SOURCE_ENDED   = "source-ended"   // The source stream ended
+	CLOSE_REASON_PROBE_ERROR    = "probe-error"    // The source stream could not be probed
+	CLOSE_REASON_ENCODER_ERROR  = "encoder-error"  // The encoding process failed
+	CLOSE_REASON_FRAGMENT_LIMIT = "fragment-limit" // The stream reached the max number of fragments (HLS_FRAGMENT_COUNT_LIMIT)
+)
+
 // Encoding task data
 type EncodingTask struct {
 	server *HLS_Encoder_Server // Reference to the server
@@ -23,14 +32,28 @@ type EncodingTask struct {
 
 	previews PreviewsConfiguration // Configuration for making the previews
 
+	options        EncodingOptions // Per-stream encoding options
+	targetDuration int             // Duration of fragments (seconds)
+
+	resumeTime     float64 // Seconds of the stream already encoded by another encoder (0 for new streams)
+	fragmentOffset int     // Index of the first fragment (greater than 0 when resuming)
+	previewsOffset int     // Index of the first preview image (greater than 0 when resuming)
+
 	mutex *sync.Mutex // Mutex to access the status data
 
 	process *os.Process // Encoding process reference
 
 	hasStarted bool // True if the encoding started
 
+	progress FFMPEGProgress // Last progress reported by FFMPEG
+
+	lastFragmentTime int64 // Unix timestamp (milliseconds) when the last fragment was appended to the playlists. 0 = None yet
+
 	killed bool // True if the task was killed
 
+	closeReason  string // Reason why the task ended (sent to the coordinator)
+	closeMessage string // Message explaining why the task ended
+
 	subStreams map[string]*SubStreamStatus // Sub-Streams
 
 	previewsCount               int          // Number of available previews
@@ -89,11 +112,92 @@ func (task *EncodingTask) debug(str string) {
 }
 
 // Call when encoding progress is made
-func (task *EncodingTask) OnEncodingProgress() {
+// progress - Progress reported by FFMPEG
+func (task *EncodingTask) OnEncodingProgress(progress FFMPEGProgress) {
 	task.mutex.Lock()
 	defer task.mutex.Unlock()
 
 	task.hasStarted = true
+	task.progress = progress
+}
+
+// Checks if the task was killed
+// Returns true if killed
+func (task *EncodingTask) IsKilled() bool {
+	task.mutex.Lock()
+	defer task.mutex.Unlock()
+
+	return task.killed
+}
+
+// Sets the reason why the task ended
+// If a reason was already set, it is kept, since it is the original cause
+// Must be called with the task mutex locked
+// code - Reason code
+// message - Message
+func (task *EncodingTask) setCloseReasonInternal(code string, message string) {
+	if task.closeReason != "" {
+		return
+	}
+
+	task.closeReason = code
+	task.closeMessage = message
+}
+
+// Sets the reason why the task ended
+// If a reason was already set, it is kept, since it is the original cause
+// code - Reason code
+// message - Message
+func (task *EncodingTask) SetCloseReason(code string, message string) {
+	task.mutex.Lock()
+	defer task.mutex.Unlock()
+
+	task.setCloseReasonInternal(code, message)
+}
+
+// Gets the reason why the task ended
+// Returns:
+//
+//	code - Reason code
+//	message - Message
+func (task *EncodingTask) GetCloseReason() (code string, message string) {
+	task.mutex.Lock()
+	defer task.mutex.Unlock()
+
+	return task.closeReason, task.closeMessage
+}
+
+// Gets the last progress reported by FFMPEG
+// Returns:
+//
+//	started - True if the encoding started
+//	progress - The last progress
+func (task *EncodingTask) GetProgress() (started bool, progress FFMPEGProgress) {
+	task.mutex.Lock()
+	defer task.mutex.Unlock()
+
+	return task.hasStarted && !task.killed, task.progress
+}
+
+// Gets the stats of the HLS output of the task
+// Returns:
+//
+//	renditions - Number of renditions (resolutions) being encoded
+//	fragments - Number of fragments of the stream (including the ones made by previous encoders, when resuming)
+//	lastFragmentTime - Unix timestamp (milliseconds) when the last fragment was produced. 0 = None yet
+func (task *EncodingTask) GetOutputStats() (renditions int, fragments int, lastFragmentTime int64) {
+	task.mutex.Lock()
+	defer task.mutex.Unlock()
+
+	for _, subStream := range task.subStreams {
+		renditions++
+
+		if subStream.fragmentCount > fragments {
+			fragments = subStream.fragmentCount
+		}
+	}
+
+	return renditions, fragments, task.lastFragmentTime
 }
 
 // Call after the encoding process ended
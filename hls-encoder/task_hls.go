@@ -2,7 +2,10 @@
 
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // Gets or create the sub stream object for the specified resolution
 // resolution - Stream video resolution
@@ -22,18 +25,28 @@ func (task *EncodingTask) getSubStream(resolution Resolution) *SubStreamStatus {
 			vodPlaylist:           nil,
 			vodPlaylistAvailable:  false,
 			vodIndex:              0,
-			vodTime:               0,
-			vodStartTime:          0,
+			vodTime:               task.resumeTime,
+			vodStartTime:          task.resumeTime,
 			vodWriting:            false,
 			vodWritePending:       false,
 			vodWriteData:          nil,
 			vodFragmentBuffer:     make([]HLS_Fragment, 0),
-			fragmentCount:         0,
-			removedFragmentsCount: 0,
+			fragmentCount:         task.fragmentOffset,
+			removedFragmentsCount: task.fragmentOffset,
 			fragments:             make(map[int]*HLS_Fragment),
 			fragmentsReady:        make(map[int]bool),
 		}
 
+		if task.fragmentOffset > 0 {
+			// Resumed stream. Do not overwrite the VOD playlists of the previous encoder
+			subStream.vodIndex = task.fragmentOffset/task.server.hlsVODPlaylistMaxSize + 1
+
+			if !task.record {
+				// Remove the last fragments left by the previous encoder
+				subStream.removedFragmentsCount = max(0, task.fragmentOffset-HLS_RESUME_FRAGMENT_MARGIN-(2*task.server.hlsLivePlayListSize))
+			}
+		}
+
 		cdnPublisher := task.server.cdnPublishController.CreateCdnPublisher(task, subStream)
 
 		if cdnPublisher != nil {
@@ -123,13 +136,14 @@ func (task *EncodingTask) updateHLSInternal(subStream *SubStreamStatus) {
 	}
 
 	subStream.fragmentCount = newFragmentCount
+	task.lastFragmentTime = time.Now().UnixMilli()
 
 	// Update HLS Live playlist
 
 	if subStream.livePlaylist == nil {
 		subStream.livePlaylist = &HLS_PlayList{
 			Version:        M3U8_DEFAULT_VERSION,
-			TargetDuration: task.server.hlsTargetDuration,
+			TargetDuration: task.targetDuration,
 			MediaSequence:  0,
 			IsVOD:          false,
 			IsEnded:        false,
@@ -147,9 +161,16 @@ func (task *EncodingTask) updateHLSInternal(subStream *SubStreamStatus) {
 
 		// Add to the live playlist
 
-		livePlaylist.fragments = append(livePlaylist.fragments, newFragments[i])
+		liveFragment := newFragments[i]
+		liveFragment.Discontinuity = task.fragmentOffset > 0 && liveFragment.Index == task.fragmentOffset
+
+		livePlaylist.fragments = append(livePlaylist.fragments, liveFragment)
 
 		if len(livePlaylist.fragments) > task.server.hlsLivePlayListSize {
+			if livePlaylist.fragments[0].Discontinuity {
+				livePlaylist.DiscontinuitySequence++
+			}
+
 			livePlaylist.fragments = livePlaylist.fragments[1:]
 		}
 	}
@@ -191,6 +212,7 @@ func (task *EncodingTask) updateHLSInternal(subStream *SubStreamStatus) {
 
 	if subStream.fragmentCount >= task.server.hlsMaxFragmentCount {
 		// Limit reached, kill process
+		task.setCloseReasonInternal(CLOSE_REASON_FRAGMENT_LIMIT, "The stream reached the limit of "+fmt.Sprint(task.server.hlsMaxFragmentCount)+" fragments")
 		task.killed = true
 		if task.process != nil {
 			task.process.Kill()
@@ -208,7 +230,7 @@ func (task *EncodingTask) updateVODInternal(subStream *SubStreamStatus) {
 	if subStream.vodPlaylist == nil {
 		subStream.vodPlaylist = &HLS_PlayList{
 			Version:        M3U8_DEFAULT_VERSION,
-			TargetDuration: task.server.hlsTargetDuration,
+			TargetDuration: task.targetDuration,
 			MediaSequence:  0,
 			IsVOD:          true,
 			IsEnded:        true,
@@ -229,7 +251,7 @@ func (task *EncodingTask) updateVODInternal(subStream *SubStreamStatus) {
 		subStream.vodStartTime = subStream.vodTime
 		subStream.vodPlaylist = &HLS_PlayList{
 			Version:        M3U8_DEFAULT_VERSION,
-			TargetDuration: task.server.hlsTargetDuration,
+			TargetDuration: task.targetDuration,
 			MediaSequence:  0,
 			IsVOD:          true,
 			IsEnded:        true,
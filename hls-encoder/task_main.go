@@ -14,15 +14,19 @@ func (task *EncodingTask) Run() {
 			switch x := err.(type) {
 			case string:
 				task.log("Error: " + x)
+				task.SetCloseReason(CLOSE_REASON_ENCODER_ERROR, x)
 			case error:
 				task.log("Error: " + x.Error())
+				task.SetCloseReason(CLOSE_REASON_ENCODER_ERROR, x.Error())
 			default:
 				task.log("Task Crashed!")
+				task.SetCloseReason(CLOSE_REASON_ENCODER_ERROR, "Task crashed")
 			}
 		}
 		task.log("Task ended.")
 		// Announce closed
-		task.server.websocketControlConnection.SendStreamClosed(task.channel, task.streamId)
+		closeReason, closeMessage := task.GetCloseReason()
+		task.server.websocketControlConnection.SendStreamClosed(task.channel, task.streamId, closeReason, closeMessage)
 		// Clear CDN connections
 		task.CloseCdnConnections()
 		// Remove task
@@ -37,6 +41,7 @@ func (task *EncodingTask) Run() {
 
 	if err != nil {
 		task.log("Error: " + err.Error())
+		task.SetCloseReason(CLOSE_REASON_PROBE_ERROR, err.Error())
 		return
 	}
 
@@ -44,24 +49,34 @@ func (task *EncodingTask) Run() {
 		return
 	}
 
+	// Report the source stream information
+
+	sourceInfo := GetSourceStreamInfo(probeData)
+
+	task.debug("Source stream: " + sourceInfo.String())
+
+	task.server.websocketControlConnection.SendStreamInfo(task.channel, task.streamId, sourceInfo)
+
 	// Create encoding process
 
 	cmd, srcManager, err := PrepareEncodingFFMPEGCommand(task, probeData)
 
 	if err != nil {
 		task.log("Error: " + err.Error())
+		task.SetCloseReason(CLOSE_REASON_PROBE_ERROR, err.Error())
 		return
 	}
 
 	task.debug("Command to be run: " + strings.Join(cmd.Args, " "))
 
-	process, err := RunFFMpegCommandAsync(cmd, func(time float64, frames uint64) {
-		task.OnEncodingProgress()
-		task.debug("[FFMPEG-P] TIME=" + fmt.Sprint(time) + ", FRAMES=" + fmt.Sprint(frames))
+	process, err := RunFFMpegCommandAsync(cmd, func(progress FFMPEGProgress) {
+		task.OnEncodingProgress(progress)
+		task.debug("[FFMPEG-P] TIME=" + fmt.Sprint(progress.time) + ", FRAMES=" + fmt.Sprint(progress.frames) + ", FPS=" + fmt.Sprint(progress.fps) + ", SPEED=" + fmt.Sprint(progress.speed) + ", DROP=" + fmt.Sprint(progress.droppedFrames))
 	})
 
 	if err != nil {
 		task.log("Error: " + err.Error())
+		task.SetCloseReason(CLOSE_REASON_ENCODER_ERROR, err.Error())
 		return
 	}
 
@@ -71,6 +86,7 @@ func (task *EncodingTask) Run() {
 		if err != nil {
 			process.Kill()
 			task.log("Error: " + err.Error())
+			task.SetCloseReason(CLOSE_REASON_ENCODER_ERROR, err.Error())
 			return
 		}
 	}
@@ -97,11 +113,18 @@ func (task *EncodingTask) Run() {
 
 	if err != nil {
 		task.log("Error: " + err.Error())
+		task.SetCloseReason(CLOSE_REASON_ENCODER_ERROR, err.Error())
 		return
 	}
 
 	task.debug("FFMPEG ended with state: " + state.String())
 
+	if state.Success() {
+		task.SetCloseReason(CLOSE_REASON_SOURCE_ENDED, "The source stream ended")
+	} else {
+		task.SetCloseReason(CLOSE_REASON_ENCODER_ERROR, "FFMPEG exited with status: "+state.String())
+	}
+
 	task.OnEncodingEnded()
 }
 
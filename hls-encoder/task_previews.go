@@ -41,8 +41,8 @@ func (task *EncodingTask) OnPreviewImageReady(previewIndex int) {
 
 		// Update index file
 		newIndexFile := PreviewsIndexFile{
-			IndexStart: 0,
-			Count:      newCount,
+			IndexStart: task.previewsOffset,
+			Count:      newCount - task.previewsOffset,
 			Pattern:    "%d.jpg",
 		}
 
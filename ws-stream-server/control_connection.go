@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,8 +20,9 @@ import (
 type ControlServerConnection struct {
 	server *WS_Streaming_Server // Reference to the streaming server
 
-	connectionURL string          // Connection URL
-	connection    *websocket.Conn // Websocket connection
+	connectionURLs     []string        // Connection URLs (one per coordinator node)
+	connectionURLIndex int             // Index of the URL to connect to
+	connection         *websocket.Conn // Websocket connection
 
 	lock *sync.Mutex // Mutex to control access to this struct
 
@@ -35,6 +38,10 @@ type ControlServerPendingRequest struct {
 	waiter chan PublishResponse // Channel to wait for the response
 }
 
+const PUBLISHER_STATS_DEFAULT_INTERVAL = 10 // Default interval to send PUBLISHER-STATS (seconds)
+
+const PUBLISH_REQUEST_DEFAULT_TIMEOUT = 20 // Default time to wait for the coordinator to accept or deny a publish request (seconds)
+
 // Response for a publish request
 type PublishResponse struct {
 	accepted bool   // True if accepted, false if denied
@@ -57,26 +64,41 @@ func (c *ControlServerConnection) Initialize(server *WS_Streaming_Server) {
 		return
 	}
 
-	connectionURL, err := url.Parse(baseURL)
+	pathURL, err := url.Parse("/ws/control/wss")
 	if err != nil {
 		LogError(err)
 		LogWarning("CONTROL_BASE_URL not provided. The server will run in stand-alone mode.")
 		c.enabled = false
 		return
 	}
-	pathURL, err := url.Parse("/ws/control/wss")
-	if err != nil {
-		LogError(err)
+
+	// Multiple coordinator nodes can be set, split by commas
+	c.connectionURLs = make([]string, 0)
+
+	baseURLs := strings.Split(baseURL, ",")
+
+	for i := 0; i < len(baseURLs); i++ {
+		connectionURL, err := url.Parse(strings.TrimSpace(baseURLs[i]))
+		if err != nil {
+			LogError(err)
+			continue
+		}
+
+		c.connectionURLs = append(c.connectionURLs, connectionURL.ResolveReference(pathURL).String())
+	}
+
+	if len(c.connectionURLs) == 0 {
 		LogWarning("CONTROL_BASE_URL not provided. The server will run in stand-alone mode.")
 		c.enabled = false
 		return
 	}
 
-	c.connectionURL = connectionURL.ResolveReference(pathURL).String()
+	c.connectionURLIndex = 0
 	c.enabled = true
 
 	go c.Connect()
 	go c.RunHeartBeatLoop()
+	go c.RunStatsLoop()
 }
 
 // Connect to the websocket server
@@ -88,7 +110,9 @@ func (c *ControlServerConnection) Connect() {
 		return // Already connected
 	}
 
-	LogInfo("[WS-CONTROL] Connecting to " + c.connectionURL)
+	connectionURL := c.connectionURLs[c.connectionURLIndex]
+
+	LogInfo("[WS-CONTROL] Connecting to " + connectionURL)
 
 	headers := http.Header{}
 
@@ -116,9 +140,11 @@ func (c *ControlServerConnection) Connect() {
 		headers.Set("x-ssl-use", "true")
 	}
 
-	conn, _, err := websocket.DefaultDialer.Dial(c.connectionURL, headers)
+	conn, _, err := websocket.DefaultDialer.Dial(connectionURL, headers)
 
 	if err != nil {
+		// Try the next coordinator node (it may be the leader)
+		c.connectionURLIndex = (c.connectionURLIndex + 1) % len(c.connectionURLs)
 		c.lock.Unlock()
 		LogErrorMessage("[WS-CONTROL] Connection error: " + err.Error())
 		go c.Reconnect()
@@ -129,9 +155,9 @@ func (c *ControlServerConnection) Connect() {
 
 	c.lock.Unlock()
 
-	// After a connection is established, any previous publishing sessions must be killed,
-	// since the coordinator server thinks the streaming server went down
-	c.server.KillAllActivePublishers()
+	// After a connection is established, report the active publishers,
+	// so the coordinator can keep them (after a restart) or kill them
+	c.SendActivePublishers(c.server.GetActivePublishers())
 
 	go c.RunReaderLoop(conn)
 }
@@ -230,6 +256,8 @@ func (c *ControlServerConnection) ParseIncomingMessage(msg *messages.RPCMessage)
 		c.OnPublishDeny(msg.GetParam("Request-Id"))
 	case "STREAM-KILL":
 		c.OnStreamKill(msg.GetParam("Stream-Channel"), msg.GetParam("Stream-Id"))
+	case "PUBLISH-MIGRATE":
+		c.OnPublishMigrate(msg.GetParam("Stream-Channel"), msg.GetParam("Stream-Id"), msg.GetParam("Migrate-URL"))
 	}
 }
 
@@ -280,17 +308,33 @@ func (c *ControlServerConnection) OnStreamKill(channel string, streamId string)
 		publisher := c.server.GetPublisher(channel)
 
 		if publisher != nil {
+			publisher.SetCloseReason(CLOSE_REASON_KILLED, "The stream was killed by the coordinator")
 			publisher.Kill()
 		}
 	} else {
 		publisher := c.server.GetPublisher(channel)
 
 		if publisher != nil && publisher.streamId == streamId {
+			publisher.SetCloseReason(CLOSE_REASON_KILLED, "The stream was killed by the coordinator")
 			publisher.Kill()
 		}
 	}
 }
 
+// Handles a PUBLISH-MIGRATE message
+// The publisher is asked to reconnect to another streaming server
+// channel - Streaming channel
+// streamId - Stream ID
+// migrateURL - Base URL of the streaming server to reconnect to. Empty if unknown
+func (c *ControlServerConnection) OnPublishMigrate(channel string, streamId string, migrateURL string) {
+	publisher := c.server.GetPublisher(channel)
+
+	if publisher != nil && publisher.streamId == streamId {
+		publisher.log("Asked to migrate to: " + migrateURL)
+		publisher.SendText("MIGRATE:" + migrateURL)
+	}
+}
+
 // Sends heart-beat messages to keep the connection alive
 func (c *ControlServerConnection) RunHeartBeatLoop() {
 	for {
@@ -305,6 +349,53 @@ func (c *ControlServerConnection) RunHeartBeatLoop() {
 	}
 }
 
+// Sends the stats of the active publishers periodically
+func (c *ControlServerConnection) RunStatsLoop() {
+	interval := PUBLISHER_STATS_DEFAULT_INTERVAL
+
+	configuredInterval := os.Getenv("PUBLISHER_STATS_INTERVAL_SECONDS")
+
+	if configuredInterval != "" {
+		n, err := strconv.Atoi(configuredInterval)
+
+		if err == nil {
+			interval = n
+		}
+	}
+
+	if interval <= 0 {
+		return // Disabled
+	}
+
+	for {
+		time.Sleep(time.Duration(interval) * time.Second)
+
+		publishers := c.server.GetPublishersStats()
+
+		if len(publishers) == 0 {
+			continue
+		}
+
+		c.SendPublisherStats(publishers)
+	}
+}
+
+// Sends PUBLISHER-STATS message to the coordinator server
+// publishers - Stats of the active publishers. Format: {CHANNEL}:{STREAM_ID}:{BITRATE}
+// Returns true if success
+func (c *ControlServerConnection) SendPublisherStats(publishers []string) bool {
+	msgParams := make(map[string]string)
+
+	msgParams["Publishers"] = strings.Join(publishers, ",")
+
+	msg := messages.RPCMessage{
+		Method: "PUBLISHER-STATS",
+		Params: msgParams,
+	}
+
+	return c.Send(msg)
+}
+
 // Requests publishing to the coordinator server
 // channel - RTMP channel ID
 // key - Publishing key
@@ -351,7 +442,7 @@ func (c *ControlServerConnection) RequestPublish(channel string, key string, use
 		return false, ""
 	}
 
-	time.AfterFunc(20*time.Second, func() { request.waiter <- PublishResponse{accepted: false, streamId: ""} }) // Timeout
+	time.AfterFunc(time.Duration(getPublishRequestTimeout())*time.Second, func() { request.waiter <- PublishResponse{accepted: false, streamId: ""} }) // Timeout
 
 	res := <-request.waiter // Wait
 
@@ -362,16 +453,57 @@ func (c *ControlServerConnection) RequestPublish(channel string, key string, use
 	return res.accepted, res.streamId
 }
 
+// Gets the time to wait for the coordinator to accept or deny a publish request
+// Returns the timeout (seconds)
+func getPublishRequestTimeout() int {
+	timeout := PUBLISH_REQUEST_DEFAULT_TIMEOUT
+
+	configuredTimeout := os.Getenv("PUBLISH_REQUEST_TIMEOUT_SECONDS")
+
+	if configuredTimeout != "" {
+		n, err := strconv.Atoi(configuredTimeout)
+
+		if err == nil && n > 0 {
+			timeout = n
+		}
+	}
+
+	return timeout
+}
+
+// Sends ACTIVE-PUBLISHERS message to the coordinator server
+// publishers - List of active publishers. Format: {CHANNEL}:{STREAM_ID}
+// Returns true if success
+func (c *ControlServerConnection) SendActivePublishers(publishers []string) bool {
+	msgParams := make(map[string]string)
+
+	msgParams["Publishers"] = strings.Join(publishers, ",")
+
+	msg := messages.RPCMessage{
+		Method: "ACTIVE-PUBLISHERS",
+		Params: msgParams,
+	}
+
+	return c.Send(msg)
+}
+
 // Send Publish-End message to the coordinator server
 // channel - Streaming channel
 // streamId - Streaming session ID
+// closeReason - Reason code (empty if unknown)
+// closeMessage - Message explaining the reason
 // Returns true if success
-func (c *ControlServerConnection) PublishEnd(channel string, streamId string) bool {
+func (c *ControlServerConnection) PublishEnd(channel string, streamId string, closeReason string, closeMessage string) bool {
 	msgParams := make(map[string]string)
 
 	msgParams["Stream-Channel"] = channel
 	msgParams["Stream-ID"] = streamId
 
+	if closeReason != "" {
+		msgParams["Close-Reason"] = closeReason
+		msgParams["Close-Message"] = strings.NewReplacer("\r", "", "\n", " ").Replace(closeMessage)
+	}
+
 	msg := messages.RPCMessage{
 		Method: "PUBLISH-END",
 		Params: msgParams,
@@ -288,16 +288,6 @@ func (server *WS_Streaming_Server) RemoveSession(id uint64) {
 	delete(server.sessions, id)
 }
 
-// Checks if there is an active stream being published on a given channel
-// channel - Channel ID
-// Returns true if active publishing
-func (server *WS_Streaming_Server) isPublishing(channel string) bool {
-	server.mutex.Lock()
-	defer server.mutex.Unlock()
-
-	return server.channels[channel] != nil && server.channels[channel].is_publishing
-}
-
 // Obtains a reference to the session that is publishing on a given channel
 // channel - The channel ID
 // Returns the reference, or nil
@@ -318,17 +308,20 @@ func (server *WS_Streaming_Server) GetPublisher(channel string) *WS_Streaming_Se
 }
 
 // Sets a publisher and a stream for a given channel
+// If there was another session publishing, it is replaced (publisher takeover)
 // channel - The channel ID
 // key - The channel key
 // stream_id - The stream ID
 // s - The session that is publishing
-// Returns true if success, false if there was another session publishing
-func (server *WS_Streaming_Server) SetPublisher(channel string, key string, stream_id string, s *WS_Streaming_Session) bool {
+// Returns the replaced session, or nil
+func (server *WS_Streaming_Server) SetPublisher(channel string, key string, stream_id string, s *WS_Streaming_Session) *WS_Streaming_Session {
 	server.mutex.Lock()
 	defer server.mutex.Unlock()
 
-	if server.channels[channel] != nil && server.channels[channel].is_publishing {
-		return false
+	var replaced *WS_Streaming_Session = nil
+
+	if server.channels[channel] != nil && server.channels[channel].is_publishing && server.channels[channel].publisher != s.id {
+		replaced = server.sessions[server.channels[channel].publisher]
 	}
 
 	if server.channels[channel] == nil {
@@ -348,17 +341,19 @@ func (server *WS_Streaming_Server) SetPublisher(channel string, key string, stre
 		server.channels[channel].publisher = s.id
 	}
 
-	return true
+	return replaced
 }
 
 // Removes the current publisher for a given channel
 // channel - The channel ID
-func (server *WS_Streaming_Server) RemovePublisher(channel string) {
+// id - ID of the publisher session
+// Returns true if removed, false if the session was no longer the publisher (it was replaced)
+func (server *WS_Streaming_Server) RemovePublisher(channel string, id uint64) bool {
 	server.mutex.Lock()
 	defer server.mutex.Unlock()
 
-	if server.channels[channel] == nil {
-		return
+	if server.channels[channel] == nil || server.channels[channel].publisher != id {
+		return false
 	}
 
 	server.channels[channel].publisher = 0
@@ -377,6 +372,8 @@ func (server *WS_Streaming_Server) RemovePublisher(channel string) {
 	if !server.channels[channel].is_publishing && len(server.channels[channel].players) == 0 {
 		delete(server.channels, channel)
 	}
+
+	return true
 }
 
 // Obtains the list of idle players for a given channel
@@ -486,27 +483,57 @@ func (server *WS_Streaming_Server) RemovePlayer(channel string, key string, s *W
 	}
 }
 
-// Kills any sessions publishing streams
-func (server *WS_Streaming_Server) KillAllActivePublishers() {
-	activePublishers := make([]*WS_Streaming_Session, 0)
+// Gets the stats of the active publishers
+// Returns the list of stats. Format: {CHANNEL}:{STREAM_ID}:{BITRATE}, where the bitrate is in bits per second
+func (server *WS_Streaming_Server) GetPublishersStats() []string {
+	publishers := make([]*WS_Streaming_Session, 0)
 
 	server.mutex.Lock()
 
 	for _, channel := range server.channels {
-		if channel == nil || !channel.is_publishing {
+		if channel == nil || !channel.is_publishing || channel.stream_id == "" {
 			continue
 		}
 
 		session := server.sessions[channel.publisher]
 
-		if session != nil {
-			activePublishers = append(activePublishers, session)
+		if session == nil {
+			continue
 		}
+
+		publishers = append(publishers, session)
 	}
 
 	server.mutex.Unlock()
 
-	for i := 0; i < len(activePublishers); i++ {
-		activePublishers[i].Kill()
+	stats := make([]string, 0)
+
+	for i := 0; i < len(publishers); i++ {
+		stats = append(stats, publishers[i].channel+":"+publishers[i].streamId+":"+fmt.Sprint(publishers[i].GetBitRate()*1000))
 	}
+
+	return stats
+}
+
+// Gets the list of active publishers
+// Returns the list of publishers. Format: {CHANNEL}:{STREAM_ID}
+func (server *WS_Streaming_Server) GetActivePublishers() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	activePublishers := make([]string, 0)
+
+	for _, channel := range server.channels {
+		if channel == nil || !channel.is_publishing || channel.stream_id == "" {
+			continue
+		}
+
+		if server.sessions[channel.publisher] == nil {
+			continue
+		}
+
+		activePublishers = append(activePublishers, channel.channel+":"+channel.stream_id)
+	}
+
+	return activePublishers
 }
@@ -6,6 +6,7 @@ import (
 	"container/list"
 	"fmt"
 	"math"
+	"net"
 	"net/http"
 	"strconv"
 	"sync"
@@ -31,6 +32,15 @@ type DataStreamChunk struct {
 	size int    // The size (bytes)
 }
 
+// Reasons why a publishing session ends
+const (
+	CLOSE_REASON_PUBLISHER_ENDED   = "publisher-ended"   // The publisher closed the connection
+	CLOSE_REASON_PUBLISHER_TIMEOUT = "publisher-timeout" // The publisher stopped sending data
+	CLOSE_REASON_CONNECTION_ERROR  = "connection-error"  // The connection failed
+	CLOSE_REASON_KILLED            = "killed"            // The coordinator killed the session (STREAM-KILL)
+	CLOSE_REASON_ERROR             = "error"             // Unexpected error in the server
+)
+
 // Status for a streaming session
 type WS_Streaming_Session struct {
 	server *WS_Streaming_Server // Reference to the server
@@ -64,8 +74,12 @@ type WS_Streaming_Session struct {
 	gopPlayNo        bool       // True if the client refuses to receive the cache packets
 	gopPlayClear     bool       // True if the clients is requesting to clear the cache
 
-	bitRate      uint64       // Bitrate (bit/ms)
-	bitRateCache BitRateCache // Cache to compute bitrate
+	bitRate       uint64       // Bitrate (bit/ms)
+	bitRateUpdate int64        // Last time the bitrate was updated (unix millis)
+	bitRateCache  BitRateCache // Cache to compute bitrate
+
+	closeReason  string // Reason why the connection was closed (sent to the coordinator for publishers)
+	closeMessage string // Message explaining why the connection was closed
 }
 
 // Handles incoming connection
@@ -130,13 +144,7 @@ func (server *WS_Streaming_Server) HandleStreamingSession(sessionId uint64, w ht
 
 	if isPublishing {
 		// Publishing
-		if server.isPublishing(channel) {
-			w.WriteHeader(403)
-			fmt.Fprintf(w, "Already publishing.")
-			server.RemoveIP(ip)
-			return
-		}
-
+		// If the channel is already being published, the coordinator decides if the new publisher can take over
 		LogRequest(sessionId, ip, "PUBLISH REQUEST: '"+channel+"'")
 
 		pubAccepted, publishStreamId := server.controlConnection.RequestPublish(channel, key, ip)
@@ -240,6 +248,49 @@ func (session *WS_Streaming_Session) SendHeartBeatMessages() {
 	}
 }
 
+// Sets the reason why the connection is being closed
+// If a reason was already set, it is kept, since it is the original cause
+// code - Reason code
+// message - Message
+func (session *WS_Streaming_Session) SetCloseReason(code string, message string) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if session.closeReason != "" {
+		return
+	}
+
+	session.closeReason = code
+	session.closeMessage = message
+}
+
+// Gets the bitrate of the data received from the client
+// If the client stopped sending data, the bitrate is 0
+// Returns the bitrate (bit/ms)
+func (session *WS_Streaming_Session) GetBitRate() uint64 {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if time.Now().UnixMilli()-session.bitRateUpdate > 2*session.bitRateCache.intervalMs {
+		// No data received since the last update
+		return 0
+	}
+
+	return session.bitRate
+}
+
+// Gets the reason why the connection was closed
+// Returns:
+//
+//	code - Reason code
+//	message - Message
+func (session *WS_Streaming_Session) GetCloseReason() (code string, message string) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return session.closeReason, session.closeMessage
+}
+
 // Closes the connection
 func (session *WS_Streaming_Session) Kill() {
 	session.mutex.Lock()
@@ -255,10 +306,13 @@ func (session *WS_Streaming_Session) Run() {
 			switch x := err.(type) {
 			case string:
 				session.log("Error: " + x)
+				session.SetCloseReason(CLOSE_REASON_ERROR, x)
 			case error:
 				session.log("Error: " + x.Error())
+				session.SetCloseReason(CLOSE_REASON_ERROR, x.Error())
 			default:
 				session.log("Connection Crashed!")
+				session.SetCloseReason(CLOSE_REASON_ERROR, "Connection crashed")
 			}
 		}
 		session.log("Connection closed.")
@@ -273,7 +327,11 @@ func (session *WS_Streaming_Session) Run() {
 	}()
 
 	if session.isPublishing {
-		session.server.SetPublisher(session.channel, session.key, session.streamId, session)
+		replaced := session.server.SetPublisher(session.channel, session.key, session.streamId, session)
+
+		if replaced != nil {
+			session.ReplacePublisher(replaced)
+		}
 	} else {
 		idle, err := session.server.AddPlayer(session.channel, session.key, session)
 
@@ -302,6 +360,7 @@ func (session *WS_Streaming_Session) Run() {
 	session.bitRateCache.bytes = 0
 	session.bitRateCache.intervalMs = 1000
 	session.bitRateCache.lastUpdate = time.Now().UnixMilli()
+	session.bitRateUpdate = session.bitRateCache.lastUpdate
 
 	// Read incoming messages
 	for {
@@ -314,6 +373,7 @@ func (session *WS_Streaming_Session) Run() {
 		msgType, message, err := session.conn.ReadMessage()
 
 		if err != nil {
+			session.SetCloseReasonFromError(err)
 			return
 		}
 
@@ -338,14 +398,34 @@ func (session *WS_Streaming_Session) Run() {
 		session.bitRateCache.bytes += uint64(len(message))
 		diff := now - session.bitRateCache.lastUpdate
 		if diff >= session.bitRateCache.intervalMs {
-			session.bitRate = uint64(math.Round(float64(session.bitRateCache.bytes) * 8 / float64(diff)))
+			bitRate := uint64(math.Round(float64(session.bitRateCache.bytes) * 8 / float64(diff)))
+			session.mutex.Lock()
+			session.bitRate = bitRate
+			session.bitRateUpdate = now
+			session.mutex.Unlock()
 			session.bitRateCache.bytes = 0
 			session.bitRateCache.lastUpdate = now
-			session.debug("Bitrate is now: " + strconv.Itoa(int(session.bitRate)))
+			session.debug("Bitrate is now: " + strconv.Itoa(int(bitRate)))
 		}
 	}
 }
 
+// Sets the reason why the connection was closed, from the error returned when reading
+// err - The error
+func (session *WS_Streaming_Session) SetCloseReasonFromError(err error) {
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+		session.SetCloseReason(CLOSE_REASON_PUBLISHER_ENDED, "The publisher closed the connection")
+		return
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		session.SetCloseReason(CLOSE_REASON_PUBLISHER_TIMEOUT, "The publisher did not send any data for 60 seconds")
+		return
+	}
+
+	session.SetCloseReason(CLOSE_REASON_CONNECTION_ERROR, err.Error())
+}
+
 // Logs a message for this connection
 // str - message to log
 func (session *WS_Streaming_Session) log(str string) {
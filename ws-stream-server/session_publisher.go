@@ -5,6 +5,7 @@ package main
 import (
 	"container/list"
 	"crypto/subtle"
+	"fmt"
 )
 
 // Starts a specific player
@@ -128,6 +129,32 @@ func (session *WS_Streaming_Session) HandleChunk(data []byte) {
 	}
 }
 
+// Kills the players of the channel, so they reconnect
+// Call only for publishers
+func (session *WS_Streaming_Session) KillPlayers() {
+	players := session.server.GetPlayers(session.channel)
+
+	for i := 0; i < len(players); i++ {
+		players[i].isIdling = true
+		players[i].isPlaying = false
+		players[i].log("PLAY END '" + players[i].channel + "'")
+		players[i].Kill()
+	}
+}
+
+// Replaces the previous publisher of the channel (publisher takeover)
+// Call only for publishers
+// replaced - The previous publisher
+func (session *WS_Streaming_Session) ReplacePublisher(replaced *WS_Streaming_Session) {
+	session.log("PUBLISH TAKEOVER '" + session.channel + "' | PREVIOUS SESSION: #" + fmt.Sprint(replaced.id))
+
+	// The players were receiving the previous source, so they must reconnect
+	session.KillPlayers()
+
+	replaced.SetCloseReason(CLOSE_REASON_KILLED, "Another publisher took over the channel")
+	replaced.Kill()
+}
+
 // Finishes a publishing session
 // Call only for publishers
 func (session *WS_Streaming_Session) EndPublish() {
@@ -135,26 +162,29 @@ func (session *WS_Streaming_Session) EndPublish() {
 	defer session.publishMutex.Unlock()
 
 	if session.isPublishing {
+		if !session.server.RemovePublisher(session.channel, session.id) {
+			// Another session took over the channel, so the stream continues
+			session.log("PUBLISH REPLACED '" + session.channel + "'")
 
-		session.log("PUBLISH END '" + session.channel + "'")
+			session.gopCache = list.New()
 
-		players := session.server.GetPlayers(session.channel)
+			session.isPublishing = false
 
-		for i := 0; i < len(players); i++ {
-			players[i].isIdling = true
-			players[i].isPlaying = false
-			players[i].log("PLAY END '" + players[i].channel + "'")
-			players[i].Kill()
+			return
 		}
 
-		session.server.RemovePublisher(session.channel)
+		session.log("PUBLISH END '" + session.channel + "'")
+
+		session.KillPlayers()
 
 		session.gopCache = list.New()
 
 		session.isPublishing = false
 
 		// Send event
-		if session.server.controlConnection.PublishEnd(session.channel, session.streamId) {
+		closeReason, closeMessage := session.GetCloseReason()
+
+		if session.server.controlConnection.PublishEnd(session.channel, session.streamId, closeReason, closeMessage) {
 			session.debug("Stop event sent")
 		} else {
 			session.debug("Could not send stop event")